@@ -0,0 +1,146 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/john98nf/SequenceClock/deployer/internal/history"
+
+	"github.com/gin-gonic/gin"
+)
+
+const EXECUTIONS_DB_DEFAULT string = "/tmp/executions.db"
+
+var executions *history.Store
+
+/*
+	Opens the execution history kept in
+	EXECUTIONS_DB. The default lives in /tmp,
+	where the chart mounts the persistent
+	controller template volume.
+*/
+func openHistory() *history.Store {
+	path := os.Getenv("EXECUTIONS_DB")
+	if path == "" {
+		path = EXECUTIONS_DB_DEFAULT
+	}
+	store, err := history.Open(path)
+	if err != nil {
+		panic(fmt.Errorf("execution history '%v' not opened: %v", path, err))
+	}
+	return store
+}
+
+/*
+	API call for storing the execution
+	report of a sequence controller.
+*/
+func ingestExecution(c *gin.Context) {
+	var e history.Execution
+	if err := c.ShouldBindJSON(&e); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if e.Sequence == "" {
+		e.Sequence = c.Param("name")
+	} else if e.Sequence != c.Param("name") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("report of sequence '%v' posted to '%v'", e.Sequence, c.Param("name"))})
+		return
+	}
+	if err := executions.Add(&e); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	executionsIngested.WithLabelValues(e.Sequence, e.Outcome).Inc()
+	c.JSON(http.StatusCreated, gin.H{"message": "ok"})
+}
+
+/*
+	API call for querying executions of a sequence,
+	along with latency percentiles of the sequence
+	and of each step. Filters (all optional):
+	from, to: RFC3339 time or a duration back from now (e.g. 168h)
+	outcome: met, missed or failed
+	limit: number of most recent executions listed,
+	the summary covers every match
+*/
+func listExecutions(c *gin.Context) {
+	var (
+		f   history.Filter
+		err error
+	)
+	if f.From, err = parseTime(c.Query("from")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if f.To, err = parseTime(c.Query("to")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if f.Outcome = c.Query("outcome"); f.Outcome != "" && !history.ValidOutcome(f.Outcome) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown outcome '%v'", f.Outcome)})
+		return
+	}
+	limit := 0
+	if l := c.Query("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid limit '%v'", l)})
+			return
+		}
+	}
+	res, err := executions.Query(c.Param("name"), f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	summary := history.Summarize(res)
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"sequence":   c.Param("name"),
+		"executions": res,
+		"summary":    summary,
+	})
+}
+
+/*
+	Parses a time filter, either RFC3339
+	or a duration back from now.
+*/
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time '%v'", value)
+	}
+	return t, nil
+}
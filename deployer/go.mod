@@ -6,9 +6,19 @@ replace github.com/john98nf/SequenceClock/deployer/pkg/sequence => ./pkg/sequenc
 
 replace github.com/john98nf/SequenceClock/deployer/internal/templateHandler => ./internal/templateHandler
 
+replace github.com/john98nf/SequenceClock/deployer/internal/history => ./internal/history
+
+replace github.com/john98nf/SequenceClock/watcher/pkg/auth => ../watcher/pkg/auth
+
+replace github.com/john98nf/SequenceClock/watcher/pkg/certs => ../watcher/pkg/certs
+
 require (
 	github.com/apache/openwhisk-client-go v0.0.0-20210313152306-ea317ea2794c
 	github.com/gin-gonic/gin v1.7.4
+	github.com/john98nf/SequenceClock/deployer/internal/history v0.0.0-00010101000000-000000000000
 	github.com/john98nf/SequenceClock/deployer/internal/templateHandler v0.0.0-00010101000000-000000000000
 	github.com/john98nf/SequenceClock/deployer/pkg/sequence v0.0.0-00010101000000-000000000000
+	github.com/john98nf/SequenceClock/watcher/pkg/auth v0.0.0-00010101000000-000000000000
+	github.com/john98nf/SequenceClock/watcher/pkg/certs v0.0.0-00010101000000-000000000000
+	github.com/prometheus/client_golang v1.11.0
 )
@@ -21,56 +21,163 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"path"
+	"time"
 
-	"github.com/iris-contrib/schema"
+	wrq "github.com/john98nf/SequenceClock/watcher/pkg/request"
 )
 
+// Watchers keep a lease for max(3*profiled time, 5s)
+const LEASE_RENEW_MIN_INTERVAL time.Duration = time.Second
+
+// Returned along a valid reset request when synchronous
+// allocation did not finish within the wait
+var ErrAllocationTimedOut = errors.New("allocation timed out")
+
+// Reaches watcher supreme and the deployer
+var httpClient = newHTTPClient(TLS_CA)
+
 type watcherClientInterface interface {
-	RequestResources(r *Request) (*ResetRequest, error)
-	ResetResources(r *ResetRequest) error
+	RequestResources(ctx context.Context, r *wrq.Request) (*wrq.ResetRequest, *Grant, error)
+	ResetResources(ctx context.Context, r *wrq.ResetRequest) error
+	RenewResources(ctx context.Context, r *wrq.ResetRequest) error
+	KeepAlive(ctx context.Context, r *wrq.ResetRequest, profiledExecutionTime int64) func()
+}
+
+/*
+	Resources applied to a function container,
+	as reported by watcher supreme.
+*/
+type Grant struct {
+	Node      string `json:"node"`
+	Container string `json:"container"`
+	Quotas    int64  `json:"quotas"`    // Effective CPU quotas, -1 when pinned
+	Requested int64  `json:"requested"` // CPU quotas desired by the request
+	Cpuset    string `json:"cpuset,omitempty"`
+	Memory    int64  `json:"memory,omitempty"` // Bytes, 0 when left untouched
 }
 
 type WatcherClient struct {
 	endpoint string
+	wait     string // Synchronous allocation deadline, empty for asynchronous
 }
 
-func NewWatcherClient(host string) *WatcherClient {
+func NewWatcherClient(host, wait string) *WatcherClient {
+	scheme := "http"
+	if TLS_CA != "" {
+		scheme = "https"
+	}
 	return &WatcherClient{
-		endpoint: "http://" + host + ":32042/api/function",
+		endpoint: scheme + "://" + host + ":32042/api/function",
+		wait:     wait,
 	}
 }
 
-func (client *WatcherClient) RequestResources(r *Request) (*ResetRequest, error) {
-	body, err := postHTTPRequest(client.endpoint+"/requestResources", *r)
+/*
+	Asks for resources of a function.
+	When allocation is synchronous, the applied grant
+	is returned too, nil if function was not found.
+	If the wait expires, the reset request comes back
+	with ErrAllocationTimedOut and no grant.
+*/
+func (client *WatcherClient) RequestResources(ctx context.Context, r *wrq.Request) (*wrq.ResetRequest, *Grant, error) {
+	endpoint := client.endpoint + "/requestResources"
+	if client.wait != "" {
+		endpoint += "?wait=" + url.QueryEscape(client.wait)
+	}
+	msg := *r
+	body, err := postHTTPRequest(ctx, endpoint, &msg)
 	if err != nil {
-		return nil, err
-	} else {
-		res := NewResetRequest(0, r.Function)
-		err := json.Unmarshal(body, res)
-		return res, err
+		return nil, nil, err
+	}
+	res := struct {
+		wrq.ResetRequest
+		Found    bool   `json:"found"`
+		TimedOut bool   `json:"timedOut"`
+		Grant    *Grant `json:"grant"`
+	}{ResetRequest: *wrq.NewResetRequest(0, r.Function)}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, nil, err
+	}
+	if res.TimedOut {
+		return &res.ResetRequest, nil, ErrAllocationTimedOut
 	}
+	return &res.ResetRequest, res.Grant, nil
+}
+
+func (client *WatcherClient) ResetResources(ctx context.Context, r *wrq.ResetRequest) error {
+	msg := *r
+	_, err := postHTTPRequest(ctx, client.endpoint+"/resetResources", &msg)
+	return err
 }
 
-func (client *WatcherClient) ResetResources(r *ResetRequest) error {
-	_, err := postHTTPRequest(client.endpoint+"/resetResources", *r)
+func (client *WatcherClient) RenewResources(ctx context.Context, r *wrq.ResetRequest) error {
+	msg := *r
+	_, err := postHTTPRequest(ctx, client.endpoint+"/renewResources", &msg)
 	return err
 }
 
-func postHTTPRequest(endpoint string, data interface{}) ([]byte, error) {
-	var encoder = schema.NewEncoder()
-	params := url.Values{}
-	if err := encoder.Encode(data, params); err != nil {
+/*
+	Renews request lease once per profiled
+	execution time, until returned function is called.
+*/
+func (client *WatcherClient) KeepAlive(ctx context.Context, r *wrq.ResetRequest, profiledExecutionTime int64) func() {
+	interval := time.Duration(profiledExecutionTime)
+	if interval < LEASE_RENEW_MIN_INTERVAL {
+		interval = LEASE_RENEW_MIN_INTERVAL
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := client.RenewResources(ctx, r); err != nil {
+					fmt.Println("lease renewal failed:", err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+/*
+	Posts msg as versioned json in a client span
+	of the trace in ctx, propagating the trace
+	to watcher supreme.
+*/
+func postHTTPRequest(ctx context.Context, endpoint string, msg wrq.Message) (res []byte, err error) {
+	data, err := wrq.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
 		return nil, err
 	}
-	resp, err := http.PostForm(endpoint, params)
+	ctx, span := tracer.Start(ctx, "watcher supreme "+path.Base(req.URL.Path), SPAN_KIND_CLIENT)
+	defer func() { span.Finish(err) }()
+	req.Header.Set("Content-Type", wrq.CONTENT_TYPE_JSON)
+	authorize(req.Header)
+	injectTraceContext(ctx, req.Header)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	span.SetAttribute("http.status_code", resp.StatusCode)
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
@@ -83,3 +190,32 @@ func postHTTPRequest(endpoint string, data interface{}) ([]byte, error) {
 		return nil, fmt.Errorf(string(body))
 	}
 }
+
+/*
+	Presents the controller token injected
+	by the deployer, if auth is enabled.
+*/
+func authorize(h http.Header) {
+	if AUTH_TOKEN != "" {
+		h.Set("Authorization", "Bearer "+AUTH_TOKEN)
+	}
+}
+
+/*
+	Client verifying servers against the PEM
+	bundle ca, injected by the deployer,
+	or the system roots without it.
+*/
+func newHTTPClient(ca string) *http.Client {
+	if ca == "" {
+		return &http.Client{}
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(ca)) {
+		panic(fmt.Errorf("no certificate found in injected ca bundle"))
+	}
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+	}}
+}
@@ -0,0 +1,46 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+/*
+	Stands in for the config.go that
+	the deployer generates for a sequence.
+*/
+const (
+	ALGORITHM_TYPE  string = "greedy"
+	KUBE_MAIN_IP    string = "127.0.0.1"
+	WEIGHT          int64  = 0
+	PRIORITY        int64  = 0
+	ALLOCATION_WAIT string = ""
+	OTLP_ENDPOINT   string = ""
+	SEQUENCE_NAME   string = "seq"
+	REPORT          string = "both"
+	REPORT_ENDPOINT string = ""
+	AUTH_TOKEN      string = "token"
+	TLS_CA          string = ""
+	OPENWHISK_CA    string = ""
+)
+
+var (
+	functionList           = [...]string{"a", "b"}
+	profiledExecutionTimes = [...]int64{100, 200}
+	memoryLimits           = [...]int64{0, 256}
+	pinnedCores            = [...]int64{0, 0}
+)
@@ -2,7 +2,9 @@ module github.com/john98nf/SequenceClock/deployer/internal/controller
 
 go 1.15
 
+replace github.com/john98nf/SequenceClock/watcher/pkg/request => ../../../../watcher/pkg/request
+
 require (
 	github.com/apache/openwhisk-client-go v0.0.0-20210313152306-ea317ea2794c
-	github.com/iris-contrib/schema v0.0.6
+	github.com/john98nf/SequenceClock/watcher/pkg/request v0.0.0-00010101000000-000000000000
 )
@@ -27,20 +27,25 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
+	wrq "github.com/john98nf/SequenceClock/watcher/pkg/request"
+
 	"github.com/apache/openwhisk-client-go/whisk"
 )
 
-type controller (func(map[string]interface{}) map[string]interface{})
+type controller (func(context.Context, map[string]interface{}, *ExecutionReport) map[string]interface{})
 
 var (
 	client         *whisk.Client
+	tracer         *Tracer
 	controllerType = map[string]controller{
 		"greedy": greedyControl,
 		"dummy":  dummyControl,
@@ -55,10 +60,38 @@ func Main(obj map[string]interface{}) map[string]interface{} {
 		Host:      os.Getenv("__OW_API_HOST"),
 		Namespace: os.Getenv("__OW_NAMESPACE"),
 		AuthToken: os.Getenv("__OW_API_KEY"),
-		Insecure:  true,
+		Insecure:  OPENWHISK_CA == "",
+	}
+	whiskHTTP := http.DefaultClient
+	if OPENWHISK_CA != "" {
+		whiskHTTP = newHTTPClient(OPENWHISK_CA)
 	}
-	client, _ = whisk.NewClient(http.DefaultClient, wskConfig)
-	return controllerType[ALGORITHM_TYPE](obj)
+	client, _ = whisk.NewClient(whiskHTTP, wskConfig)
+	tracer = NewTracer(OTLP_ENDPOINT, os.Getenv("__OW_ACTIVATION_ID"))
+	ctx, span := tracer.Start(context.Background(), "sequence "+os.Getenv("__OW_ACTION_NAME"), SPAN_KIND_INTERNAL)
+	span.SetAttribute("algorithm", ALGORITHM_TYPE)
+	report := NewExecutionReport(ctx, os.Getenv("__OW_ACTIVATION_ID"))
+	defer func() {
+		r := recover()
+		if r != nil {
+			err := fmt.Errorf("%v", r)
+			report.Finish(err)
+			report.Deliver(nil)
+			span.Finish(err)
+		} else {
+			span.Finish(nil)
+		}
+		if err := tracer.Flush(); err != nil {
+			fmt.Println("trace export failed:", err)
+		}
+		if r != nil {
+			panic(r)
+		}
+	}()
+	res := controllerType[ALGORITHM_TYPE](ctx, obj, report)
+	report.Finish(nil)
+	report.Deliver(res)
+	return res
 }
 
 /*
@@ -66,7 +99,7 @@ func Main(obj map[string]interface{}) map[string]interface{} {
 	as it just invokes each function.
 	Used for benchmarking purposes and referrence point.
 */
-func dummyControl(obj map[string]interface{}) map[string]interface{} {
+func dummyControl(ctx context.Context, obj map[string]interface{}, report *ExecutionReport) map[string]interface{} {
 	var (
 		id         string
 		status     string
@@ -77,7 +110,8 @@ func dummyControl(obj map[string]interface{}) map[string]interface{} {
 	)
 	aRes := obj
 	for i, f := range functionList {
-		fullRes, _, err = client.Actions.Invoke(f, aRes, true, false)
+		tStart := time.Now()
+		fullRes, err = invoke(ctx, f, aRes)
 		if err != nil {
 			panic(err)
 		}
@@ -86,6 +120,15 @@ func dummyControl(obj map[string]interface{}) map[string]interface{} {
 			panic(errMetrics.Error())
 		}
 		fmt.Println(i, id, latency, strings.Replace(status, " ", "", -1))
+		report.Steps = append(report.Steps, StepReport{
+			Index:              i,
+			Function:           f,
+			ActivationID:       id,
+			Status:             status,
+			ProfiledTime:       profiledExecutionTimes[i],
+			WallTime:           int64(time.Since(tStart)),
+			ActivationDuration: latency * int64(time.Millisecond),
+		})
 	}
 	return aRes
 }
@@ -97,34 +140,62 @@ func dummyControl(obj map[string]interface{}) map[string]interface{} {
 	A positive slack means that the next function invokation may run with fewer resources (slow down).
 	A negative slack means that the next fuction must run with more resources (speed up).
 */
-func greedyControl(obj map[string]interface{}) map[string]interface{} {
+func greedyControl(ctx context.Context, obj map[string]interface{}, report *ExecutionReport) map[string]interface{} {
 	var (
 		tStart     time.Time
 		tEnd       time.Time
+		tWatcher   time.Time
 		elapsed    time.Duration
+		overhead   time.Duration
 		id         string
 		status     string
 		latency    int64
 		fullRes    map[string]interface{}
-		r          *Request = NewRequest("", &Metrics{})
+		r          *wrq.Request = wrq.NewRequest("", &wrq.Metrics{})
 		errMetrics error
 	)
+	r.Weight = WEIGHT
+	r.Priority = PRIORITY
 
-	watcherClient := NewWatcherClient(KUBE_MAIN_IP)
+	watcherClient := NewWatcherClient(KUBE_MAIN_IP, ALLOCATION_WAIT)
 	aRes := obj
 	for i, f := range functionList {
 		tStart = time.Now()
+		stepCtx, step := tracer.Start(ctx, "step "+f, SPAN_KIND_INTERNAL)
+		step.SetAttribute("function", f)
+		step.SetAttribute("step", i)
 		r.Function = functionList[i]
-		reset, err := watcherClient.RequestResources(r)
-		if err != nil {
+		r.Memory = memoryLimits[i]
+		r.PinnedCores = pinnedCores[i]
+		r.Metrics.ProfiledExecutionTime = profiledExecutionTimes[i]
+		tWatcher = time.Now()
+		reset, grant, err := watcherClient.RequestResources(stepCtx, r)
+		timedOut := errors.Is(err, ErrAllocationTimedOut)
+		if err != nil && !timedOut {
 			panic(err)
 		}
+		overhead = time.Since(tWatcher)
+		if timedOut {
+			// Run with whatever the container has now
+			fmt.Println(i, f, "grant: timed out after", ALLOCATION_WAIT)
+			step.SetAttribute("allocation.timed_out", true)
+		} else if ALLOCATION_WAIT != "" {
+			printGrant(i, f, grant)
+		}
+		step.SetAttribute("request.id", int64(reset.ID))
+		stopRenewal := func() {}
+		if !timedOut {
+			stopRenewal = watcherClient.KeepAlive(stepCtx, reset, profiledExecutionTimes[i])
+		}
 
-		fullRes, _, err = client.Actions.Invoke(f, aRes, true, false)
+		fullRes, err = invoke(stepCtx, f, aRes)
 
-		if err := watcherClient.ResetResources(reset); err != nil {
+		stopRenewal()
+		tWatcher = time.Now()
+		if err := watcherClient.ResetResources(stepCtx, reset); err != nil {
 			panic(err)
 		}
+		overhead += time.Since(tWatcher)
 		if err != nil {
 			panic(err)
 		}
@@ -142,10 +213,58 @@ func greedyControl(obj map[string]interface{}) map[string]interface{} {
 		r.Metrics.PreviousSlack = r.Metrics.Slack
 		r.Metrics.Slack += profiledExecutionTimes[i] - int64(elapsed)
 		r.Metrics.SumOfSlack += r.Metrics.Slack
+		step.SetAttribute("slack", r.Metrics.Slack)
+		step.Finish(nil)
+
+		stepReport := StepReport{
+			Index:              i,
+			Function:           f,
+			ActivationID:       id,
+			Status:             status,
+			RequestID:          reset.ID,
+			ProfiledTime:       profiledExecutionTimes[i],
+			WallTime:           int64(elapsed),
+			ActivationDuration: latency * int64(time.Millisecond),
+			WatcherOverhead:    int64(overhead),
+			SlackBefore:        r.Metrics.PreviousSlack,
+			SlackAfter:         r.Metrics.Slack,
+			AllocationTimedOut: timedOut,
+		}
+		if grant != nil {
+			stepReport.RequestedQuotas, stepReport.GrantedQuotas = grant.Requested, grant.Quotas
+			stepReport.Node = grant.Node
+		}
+		report.Steps = append(report.Steps, stepReport)
 	}
 	return aRes
 }
 
+/*
+	Invokes a function blocking, in a span
+	carrying the activation id of the function.
+*/
+func invoke(ctx context.Context, function string, params map[string]interface{}) (map[string]interface{}, error) {
+	_, span := tracer.Start(ctx, "invoke "+function, SPAN_KIND_CLIENT)
+	res, _, err := client.Actions.Invoke(function, params, true, false)
+	if id, ok := res["activationId"].(string); ok {
+		span.SetAttribute("openwhisk.function.activation_id", id)
+	}
+	span.Finish(err)
+	return res, err
+}
+
+/*
+	Reports what watchers applied
+	for a function invocation.
+*/
+func printGrant(i int, function string, grant *Grant) {
+	if grant == nil {
+		fmt.Println(i, function, "grant: not found")
+		return
+	}
+	fmt.Println(i, function, "grant:", grant.Node, grant.Container, grant.Quotas, grant.Cpuset, grant.Memory)
+}
+
 /*
 	Helper function for extracting information
 	from OpenWhisk API output.
@@ -0,0 +1,163 @@
+// Copyright © 2021 Giannis Fakinos
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	REPORT_RESULT     string        = "result"
+	REPORT_DEPLOYER   string        = "deployer"
+	REPORT_BOTH       string        = "both"
+	REPORT_RESULT_KEY string        = "sequenceClockReport"
+	REPORT_TIMEOUT    time.Duration = 5 * time.Second
+	OUTCOME_MET       string        = "met"
+	OUTCOME_MISSED    string        = "missed"
+	OUTCOME_FAILED    string        = "failed"
+)
+
+/*
+	Trace of a single function invocation.
+	Durations are in nanoseconds. Quotas are
+	known only under synchronous allocation.
+*/
+type StepReport struct {
+	Index              int    `json:"index"`
+	Function           string `json:"function"`
+	ActivationID       string `json:"activationId"`
+	Status             string `json:"status"`
+	RequestID          uint64 `json:"requestId,omitempty"`
+	ProfiledTime       int64  `json:"profiledTime"`
+	RequestedQuotas    int64  `json:"requestedQuotas,omitempty"`
+	GrantedQuotas      int64  `json:"grantedQuotas,omitempty"` // -1 when pinned
+	Node               string `json:"node,omitempty"`
+	WallTime           int64  `json:"wallTime"`
+	ActivationDuration int64  `json:"activationDuration"`
+	WatcherOverhead    int64  `json:"watcherOverhead"` // Request and reset round trips
+	SlackBefore        int64  `json:"slackBefore"`
+	SlackAfter         int64  `json:"slackAfter"`
+	AllocationTimedOut bool   `json:"allocationTimedOut,omitempty"` // Ran without a grant
+}
+
+/*
+	Trace of a sequence execution.
+	Deadline is the sum of profiled
+	execution times of its functions.
+*/
+type ExecutionReport struct {
+	Sequence     string       `json:"sequence"`
+	ActivationID string       `json:"activationId"`
+	TraceID      string       `json:"traceId,omitempty"`
+	Algorithm    string       `json:"algorithm"`
+	Start        time.Time    `json:"start"`
+	TotalTime    int64        `json:"totalTime"`
+	Deadline     int64        `json:"deadline"`
+	DeadlineMet  bool         `json:"deadlineMet"`
+	Outcome      string       `json:"outcome"`
+	Error        string       `json:"error,omitempty"`
+	Steps        []StepReport `json:"steps"`
+}
+
+func NewExecutionReport(ctx context.Context, activation string) *ExecutionReport {
+	rep := &ExecutionReport{
+		Sequence:     SEQUENCE_NAME,
+		ActivationID: activation,
+		Algorithm:    ALGORITHM_TYPE,
+		Start:        time.Now(),
+		Steps:        []StepReport{},
+	}
+	if s := spanFromContext(ctx); s != nil {
+		rep.TraceID = hex.EncodeToString(s.traceID[:])
+	}
+	for _, t := range profiledExecutionTimes {
+		rep.Deadline += t
+	}
+	return rep
+}
+
+/*
+	Closes the report, failed if err is not nil.
+*/
+func (rep *ExecutionReport) Finish(err error) {
+	rep.TotalTime = int64(time.Since(rep.Start))
+	rep.DeadlineMet = err == nil && rep.TotalTime <= rep.Deadline
+	switch {
+	case err != nil:
+		rep.Outcome, rep.Error = OUTCOME_FAILED, err.Error()
+	case rep.DeadlineMet:
+		rep.Outcome = OUTCOME_MET
+	default:
+		rep.Outcome = OUTCOME_MISSED
+	}
+}
+
+/*
+	Delivers the report as configured by REPORT,
+	adding it to the action result and/or
+	posting it to the deployer.
+*/
+func (rep *ExecutionReport) Deliver(res map[string]interface{}) {
+	rep.deliver(res, REPORT, REPORT_ENDPOINT)
+}
+
+func (rep *ExecutionReport) deliver(res map[string]interface{}, report, endpoint string) {
+	if (report == REPORT_RESULT || report == REPORT_BOTH) && res != nil {
+		res[REPORT_RESULT_KEY] = rep
+	}
+	if (report == REPORT_DEPLOYER || report == REPORT_BOTH) && endpoint != "" {
+		if err := rep.post(endpoint); err != nil {
+			fmt.Println("execution report not delivered:", err)
+		}
+	}
+}
+
+func (rep *ExecutionReport) post(endpoint string) error {
+	body, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	authorize(req.Header)
+	client := http.Client{Timeout: REPORT_TIMEOUT, Transport: httpClient.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 && resp.StatusCode != 201 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("deployer answered %v: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExecutionReport(t *testing.T) {
+	ctx, root := NewTracer("", "act").Start(context.Background(), "sequence", SPAN_KIND_INTERNAL)
+	rep := NewExecutionReport(ctx, "act")
+	if rep.Sequence != SEQUENCE_NAME || rep.ActivationID != "act" || rep.Algorithm != ALGORITHM_TYPE {
+		t.Fatalf("report %+v", rep)
+	}
+	if rep.TraceID != hex.EncodeToString(root.traceID[:]) {
+		t.Fatalf("trace id %v, want the one of the sequence span", rep.TraceID)
+	}
+	if rep.Deadline != 300 {
+		t.Fatalf("deadline %v, want sum of profiled times 300", rep.Deadline)
+	}
+	if rep := NewExecutionReport(context.Background(), "act"); rep.TraceID != "" {
+		t.Fatalf("trace id %v without a span", rep.TraceID)
+	}
+
+	tests := []struct {
+		name     string
+		deadline time.Duration
+		err      error
+		outcome  string
+		met      bool
+	}{
+		{"within deadline", time.Hour, nil, OUTCOME_MET, true},
+		{"past deadline", 0, nil, OUTCOME_MISSED, false},
+		{"failed within deadline", time.Hour, errors.New("invocation failed"), OUTCOME_FAILED, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rep := NewExecutionReport(context.Background(), "act")
+			rep.Start, rep.Deadline = time.Now().Add(-time.Millisecond), int64(tt.deadline)
+			rep.Finish(tt.err)
+			if rep.Outcome != tt.outcome || rep.DeadlineMet != tt.met {
+				t.Fatalf("outcome %v, met %v, want %v, %v", rep.Outcome, rep.DeadlineMet, tt.outcome, tt.met)
+			}
+			if rep.TotalTime < int64(time.Millisecond) {
+				t.Fatalf("total time %v, want at least 1ms", rep.TotalTime)
+			}
+			if (tt.err != nil) != (rep.Error != "") {
+				t.Fatalf("error %q for %v", rep.Error, tt.err)
+			}
+		})
+	}
+}
+
+func TestReportFields(t *testing.T) {
+	rep := NewExecutionReport(context.Background(), "act")
+	rep.Steps = append(rep.Steps, StepReport{
+		Index: 1, Function: "b", RequestID: 7, GrantedQuotas: -1, Node: "node-a", AllocationTimedOut: true,
+	})
+	rep.Finish(nil)
+	body, err := json.Marshal(rep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	json.Unmarshal(body, &fields)
+	for _, k := range []string{"sequence", "activationId", "algorithm", "start", "totalTime", "deadline", "deadlineMet", "outcome", "steps"} {
+		if _, ok := fields[k]; !ok {
+			t.Errorf("report field %v missing from %s", k, body)
+		}
+	}
+	step := fields["steps"].([]interface{})[0].(map[string]interface{})
+	want := map[string]interface{}{
+		"index": 1.0, "function": "b", "requestId": 7.0, "grantedQuotas": -1.0, "node": "node-a", "allocationTimedOut": true,
+	}
+	for k, v := range want {
+		if step[k] != v {
+			t.Errorf("step field %v: %v, want %v", k, step[k], v)
+		}
+	}
+	if _, ok := step["requestedQuotas"]; ok {
+		t.Error("unknown requested quotas reported")
+	}
+}
+
+func TestReportDelivery(t *testing.T) {
+	var posted []ExecutionReport
+	status := http.StatusCreated
+	deployer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rep ExecutionReport
+		if r.Header.Get("Authorization") != "Bearer "+AUTH_TOKEN || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&rep); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		posted = append(posted, rep)
+		w.WriteHeader(status)
+	}))
+	defer deployer.Close()
+
+	tests := []struct {
+		report   string
+		endpoint string
+		inResult bool
+		posted   bool
+	}{
+		{REPORT_RESULT, deployer.URL, true, false},
+		{REPORT_DEPLOYER, deployer.URL, false, true},
+		{REPORT_BOTH, deployer.URL, true, true},
+		{REPORT_BOTH, "", true, false}, // Deployer endpoint unknown
+	}
+	for _, tt := range tests {
+		posted = nil
+		rep := NewExecutionReport(context.Background(), "act-"+tt.report)
+		rep.Finish(nil)
+		res := map[string]interface{}{"value": 1}
+		rep.deliver(res, tt.report, tt.endpoint)
+		if _, ok := res[REPORT_RESULT_KEY]; ok != tt.inResult {
+			t.Errorf("%v to %q: report in result %v, want %v", tt.report, tt.endpoint, ok, tt.inResult)
+		}
+		if (len(posted) == 1) != tt.posted {
+			t.Errorf("%v to %q: %v reports posted, want posted %v", tt.report, tt.endpoint, len(posted), tt.posted)
+		} else if tt.posted && posted[0].ActivationID != rep.ActivationID {
+			t.Errorf("%v: posted report of %v", tt.report, posted[0].ActivationID)
+		}
+	}
+
+	// A failed activation has no result to carry the report
+	rep := NewExecutionReport(context.Background(), "act")
+	rep.Finish(errors.New("invocation failed"))
+	rep.deliver(nil, REPORT_RESULT, deployer.URL)
+
+	status = http.StatusInternalServerError
+	if err := rep.post(deployer.URL); err == nil {
+		t.Fatal("deployer failure not reported")
+	}
+}
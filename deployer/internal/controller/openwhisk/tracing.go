@@ -0,0 +1,238 @@
+// Copyright © 2021 Giannis Fakinos
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SERVICE_NAME        string        = "sequence-clock-controller"
+	TRACER_NAME         string        = "github.com/john98nf/SequenceClock/deployer/controller"
+	TRACEPARENT_HEADER  string        = "traceparent"
+	BAGGAGE_HEADER      string        = "baggage"
+	ACTIVATION_ID_KEY   string        = "openwhisk.activation_id"
+	OTLP_TRACES_PATH    string        = "/v1/traces"
+	OTLP_EXPORT_TIMEOUT time.Duration = 5 * time.Second
+	SPAN_KIND_INTERNAL  int           = 1
+	SPAN_KIND_CLIENT    int           = 3
+	STATUS_CODE_ERROR   int           = 2
+)
+
+type spanKey struct{}
+
+/*
+	Span of the sequence controller.
+	Identifiers follow W3C trace context,
+	so watcher supreme and watchers
+	continue the same trace.
+*/
+type Span struct {
+	traceID    [16]byte
+	spanID     [8]byte
+	parentID   [8]byte
+	name       string
+	kind       int
+	start      time.Time
+	end        time.Time
+	attributes map[string]interface{}
+	err        error
+	tracer     *Tracer
+}
+
+/*
+	Sets an attribute of the span.
+*/
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.attributes[key] = value
+}
+
+/*
+	Ends the span, failed if err is not nil.
+*/
+func (s *Span) Finish(err error) {
+	s.end, s.err = time.Now(), err
+	s.tracer.record(s)
+}
+
+/*
+	Records spans of one controller activation
+	and exports them to an OTLP/HTTP collector.
+	Empty endpoint disables export, trace
+	context is still propagated.
+*/
+type Tracer struct {
+	mutex      sync.Mutex
+	endpoint   string
+	activation string
+	spans      []*Span
+}
+
+func NewTracer(endpoint, activation string) *Tracer {
+	return &Tracer{
+		endpoint:   endpoint,
+		activation: activation,
+	}
+}
+
+/*
+	Starts a span as child of the span
+	in ctx, or a new trace without one.
+*/
+func (t *Tracer) Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	s := &Span{
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: map[string]interface{}{ACTIVATION_ID_KEY: t.activation},
+		tracer:     t,
+	}
+	if parent := spanFromContext(ctx); parent != nil {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (t *Tracer) record(s *Span) {
+	t.mutex.Lock()
+	t.spans = append(t.spans, s)
+	t.mutex.Unlock()
+}
+
+func spanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+/*
+	Adds W3C traceparent of the span in ctx
+	to outgoing headers, along with the
+	activation id as baggage.
+*/
+func injectTraceContext(ctx context.Context, h http.Header) {
+	s := spanFromContext(ctx)
+	if s == nil {
+		return
+	}
+	h.Set(TRACEPARENT_HEADER, fmt.Sprintf("00-%x-%x-01", s.traceID, s.spanID))
+	if s.tracer.activation != "" {
+		h.Set(BAGGAGE_HEADER, ACTIVATION_ID_KEY+"="+url.QueryEscape(s.tracer.activation))
+	}
+}
+
+/*
+	Exports finished spans in OTLP/JSON.
+*/
+func (t *Tracer) Flush() error {
+	t.mutex.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mutex.Unlock()
+	if t.endpoint == "" || len(spans) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: OTLP_EXPORT_TIMEOUT}
+	resp, err := client.Post(t.endpoint+OTLP_TRACES_PATH, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("collector answered %v: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
+
+/*
+	Builds an ExportTraceServiceRequest
+	following the OTLP/JSON mapping.
+*/
+func otlpRequest(spans []*Span) map[string]interface{} {
+	res := make([]map[string]interface{}, len(spans))
+	for i, s := range spans {
+		span := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attributes),
+		}
+		if s.parentID != [8]byte{} {
+			span["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != nil {
+			span["status"] = map[string]interface{}{"code": STATUS_CODE_ERROR, "message": s.err.Error()}
+		}
+		res[i] = span
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": SERVICE_NAME}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": TRACER_NAME},
+				"spans": res,
+			}},
+		}},
+	}
+}
+
+func otlpAttributes(attrs map[string]interface{}) []interface{} {
+	res := make([]interface{}, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]interface{}
+		switch v := v.(type) {
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		res = append(res, map[string]interface{}{"key": k, "value": value})
+	}
+	return res
+}
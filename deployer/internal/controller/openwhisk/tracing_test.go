@@ -0,0 +1,205 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+var traceparentExp = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-01$`)
+
+func TestTraceparent(t *testing.T) {
+	tracer := NewTracer("", "act/1")
+	h := http.Header{}
+	injectTraceContext(context.Background(), h)
+	if len(h) != 0 {
+		t.Fatalf("headers %v without a span", h)
+	}
+
+	ctx, root := tracer.Start(context.Background(), "sequence", SPAN_KIND_INTERNAL)
+	ctx, child := tracer.Start(ctx, "allocate", SPAN_KIND_CLIENT)
+	injectTraceContext(ctx, h)
+	m := traceparentExp.FindStringSubmatch(h.Get(TRACEPARENT_HEADER))
+	if m == nil {
+		t.Fatalf("traceparent %q, want version 00, 32 and 16 hex digits, sampled flag", h.Get(TRACEPARENT_HEADER))
+	}
+	if m[1] != hex.EncodeToString(root.traceID[:]) || m[2] != hex.EncodeToString(child.spanID[:]) {
+		t.Fatalf("traceparent %q, want trace of root and span of child", h.Get(TRACEPARENT_HEADER))
+	}
+	if m[1] == "00000000000000000000000000000000" || m[2] == "0000000000000000" {
+		t.Fatalf("traceparent %q carries an invalid all zero id", h.Get(TRACEPARENT_HEADER))
+	}
+	if child.parentID != root.spanID {
+		t.Fatal("child span not parented to root")
+	}
+	if got := h.Get(BAGGAGE_HEADER); got != ACTIVATION_ID_KEY+"=act%2F1" {
+		t.Fatalf("baggage %q", got)
+	}
+}
+
+/*
+	Span of an OTLP/JSON body,
+	as far as the tests look at it.
+*/
+type otlpSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Kind         int    `json:"kind"`
+	Start        string `json:"startTimeUnixNano"`
+	End          string `json:"endTimeUnixNano"`
+	Attributes   []struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	} `json:"attributes"`
+	Status *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+type otlpBody struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []struct {
+				Key   string            `json:"key"`
+				Value map[string]string `json:"value"`
+			} `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Scope struct {
+				Name string `json:"name"`
+			} `json:"scope"`
+			Spans []otlpSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+func TestOTLPExport(t *testing.T) {
+	var (
+		body   []byte
+		path   string
+		ctype  string
+		status = http.StatusOK
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ctype = r.URL.Path, r.Header.Get("Content-Type")
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer collector.Close()
+
+	tracer := NewTracer(collector.URL, "act")
+	ctx, root := tracer.Start(context.Background(), "sequence", SPAN_KIND_INTERNAL)
+	_, child := tracer.Start(ctx, "allocate", SPAN_KIND_CLIENT)
+	child.SetAttribute("function", "a")
+	child.SetAttribute("allocation.quotas", int64(50000))
+	child.SetAttribute("step", 1)
+	child.SetAttribute("allocation.timed_out", true)
+	child.Finish(errors.New("watcher down"))
+	root.Finish(nil)
+	if err := tracer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if path != OTLP_TRACES_PATH || ctype != "application/json" {
+		t.Fatalf("exported to %v as %v", path, ctype)
+	}
+
+	var req otlpBody
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("body %s, want one resource and one scope", body)
+	}
+	resource := req.ResourceSpans[0].Resource.Attributes
+	if len(resource) != 1 || resource[0].Key != "service.name" || resource[0].Value["stringValue"] != SERVICE_NAME {
+		t.Fatalf("resource attributes %+v", resource)
+	}
+	scope := req.ResourceSpans[0].ScopeSpans[0]
+	if scope.Scope.Name != TRACER_NAME || len(scope.Spans) != 2 {
+		t.Fatalf("scope %v with %v spans", scope.Scope.Name, len(scope.Spans))
+	}
+
+	exported, parent := scope.Spans[0], scope.Spans[1]
+	if exported.Name != "allocate" || exported.Kind != SPAN_KIND_CLIENT || parent.Name != "sequence" {
+		t.Fatalf("spans %v and %v, want allocate then sequence", exported.Name, parent.Name)
+	}
+	if len(exported.TraceID) != 32 || len(exported.SpanID) != 16 || exported.TraceID != parent.TraceID {
+		t.Fatalf("ids %v/%v, want 16 and 8 hex bytes in the trace of the root", exported.TraceID, exported.SpanID)
+	}
+	if exported.ParentSpanID != parent.SpanID || parent.ParentSpanID != "" {
+		t.Fatalf("parent %q of child, %q of root", exported.ParentSpanID, parent.ParentSpanID)
+	}
+	start, errS := strconv.ParseInt(exported.Start, 10, 64)
+	end, errE := strconv.ParseInt(exported.End, 10, 64)
+	if errS != nil || errE != nil || end < start {
+		t.Fatalf("times %q to %q, want ordered nanoseconds as strings", exported.Start, exported.End)
+	}
+	if exported.Status == nil || exported.Status.Code != STATUS_CODE_ERROR || exported.Status.Message != "watcher down" {
+		t.Fatalf("status %+v of a failed span", exported.Status)
+	}
+	if parent.Status != nil {
+		t.Fatalf("status %+v of a successful span", parent.Status)
+	}
+	want := map[string]map[string]interface{}{
+		ACTIVATION_ID_KEY:      {"stringValue": "act"},
+		"function":             {"stringValue": "a"},
+		"allocation.quotas":    {"intValue": "50000"},
+		"step":                 {"intValue": "1"},
+		"allocation.timed_out": {"boolValue": true},
+	}
+	if len(exported.Attributes) != len(want) {
+		t.Fatalf("%v attributes, want %v", len(exported.Attributes), len(want))
+	}
+	for _, a := range exported.Attributes {
+		if w, ok := want[a.Key]; !ok || len(a.Value) != 1 {
+			t.Errorf("attribute %v: %v", a.Key, a.Value)
+		} else {
+			for k, v := range w {
+				if a.Value[k] != v {
+					t.Errorf("attribute %v: %v, want %v %v", a.Key, a.Value, k, v)
+				}
+			}
+		}
+	}
+
+	// Spans go once, failures of the collector are reported
+	body = nil
+	if err := tracer.Flush(); err != nil || body != nil {
+		t.Fatalf("second flush exported %s, error %v", body, err)
+	}
+	status = http.StatusServiceUnavailable
+	_, s := tracer.Start(context.Background(), "sequence", SPAN_KIND_INTERNAL)
+	s.Finish(nil)
+	if err := tracer.Flush(); err == nil {
+		t.Fatal("collector failure not reported")
+	}
+}
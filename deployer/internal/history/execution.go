@@ -0,0 +1,98 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package history
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	OUTCOME_MET    string = "met"
+	OUTCOME_MISSED string = "missed"
+	OUTCOME_FAILED string = "failed"
+)
+
+/*
+	Trace of a single function invocation,
+	as reported by the sequence controller.
+	Durations are in nanoseconds.
+*/
+type Step struct {
+	Index              int    `json:"index"`
+	Function           string `json:"function"`
+	ActivationID       string `json:"activationId"`
+	Status             string `json:"status"`
+	RequestID          uint64 `json:"requestId,omitempty"`
+	ProfiledTime       int64  `json:"profiledTime"`
+	RequestedQuotas    int64  `json:"requestedQuotas,omitempty"`
+	GrantedQuotas      int64  `json:"grantedQuotas,omitempty"`
+	Node               string `json:"node,omitempty"`
+	WallTime           int64  `json:"wallTime"`
+	ActivationDuration int64  `json:"activationDuration"`
+	WatcherOverhead    int64  `json:"watcherOverhead"`
+	SlackBefore        int64  `json:"slackBefore"`
+	SlackAfter         int64  `json:"slackAfter"`
+	AllocationTimedOut bool   `json:"allocationTimedOut,omitempty"`
+}
+
+/*
+	Trace of a sequence execution,
+	as reported by the sequence controller.
+*/
+type Execution struct {
+	Sequence     string    `json:"sequence"`
+	ActivationID string    `json:"activationId"`
+	TraceID      string    `json:"traceId,omitempty"`
+	Algorithm    string    `json:"algorithm"`
+	Start        time.Time `json:"start"`
+	TotalTime    int64     `json:"totalTime"`
+	Deadline     int64     `json:"deadline"`
+	DeadlineMet  bool      `json:"deadlineMet"`
+	Outcome      string    `json:"outcome"`
+	Error        string    `json:"error,omitempty"`
+	Steps        []Step    `json:"steps"`
+}
+
+/*
+	Validates an execution before it is stored.
+*/
+func (e *Execution) Validate() error {
+	if e.Sequence == "" {
+		return fmt.Errorf("execution without sequence")
+	}
+	if e.Start.IsZero() {
+		return fmt.Errorf("execution without start time")
+	}
+	if !ValidOutcome(e.Outcome) {
+		return fmt.Errorf("unknown outcome %v", e.Outcome)
+	}
+	return nil
+}
+
+func ValidOutcome(outcome string) bool {
+	switch outcome {
+	case OUTCOME_MET, OUTCOME_MISSED, OUTCOME_FAILED:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package history
+
+import (
+	"math"
+	"sort"
+)
+
+/*
+	Nearest-rank percentiles of a
+	duration, in nanoseconds.
+*/
+type Percentiles struct {
+	P50 int64 `json:"p50"`
+	P90 int64 `json:"p90"`
+	P95 int64 `json:"p95"`
+	P99 int64 `json:"p99"`
+	Max int64 `json:"max"`
+}
+
+/*
+	Latencies of one step of a sequence.
+*/
+type StepSummary struct {
+	Index              int         `json:"index"`
+	Function           string      `json:"function"`
+	Count              int         `json:"count"`
+	WallTime           Percentiles `json:"wallTime"`
+	ActivationDuration Percentiles `json:"activationDuration"`
+	WatcherOverhead    Percentiles `json:"watcherOverhead"`
+}
+
+/*
+	Aggregate of a set of executions.
+	MissRate counts failed executions
+	as missed targets.
+*/
+type Summary struct {
+	Count     int           `json:"count"`
+	Met       int           `json:"met"`
+	Missed    int           `json:"missed"`
+	Failed    int           `json:"failed"`
+	MissRate  float64       `json:"missRate"`
+	TotalTime Percentiles   `json:"totalTime"`
+	Steps     []StepSummary `json:"steps"`
+}
+
+/*
+	Aggregates executions of a sequence.
+	Latencies of failed executions are left out,
+	as they stop early.
+*/
+func Summarize(executions []Execution) Summary {
+	var (
+		res    = Summary{Count: len(executions), Steps: []StepSummary{}}
+		total  []int64
+		wall   = map[int][]int64{}
+		act    = map[int][]int64{}
+		over   = map[int][]int64{}
+		byStep = map[int]*StepSummary{}
+	)
+	for _, e := range executions {
+		switch e.Outcome {
+		case OUTCOME_MET:
+			res.Met++
+		case OUTCOME_MISSED:
+			res.Missed++
+		case OUTCOME_FAILED:
+			res.Failed++
+			continue
+		}
+		total = append(total, e.TotalTime)
+		for _, s := range e.Steps {
+			if _, ok := byStep[s.Index]; !ok {
+				byStep[s.Index] = &StepSummary{Index: s.Index, Function: s.Function}
+			}
+			byStep[s.Index].Count++
+			wall[s.Index] = append(wall[s.Index], s.WallTime)
+			act[s.Index] = append(act[s.Index], s.ActivationDuration)
+			over[s.Index] = append(over[s.Index], s.WatcherOverhead)
+		}
+	}
+	if res.Count > 0 {
+		res.MissRate = float64(res.Missed+res.Failed) / float64(res.Count)
+	}
+	res.TotalTime = percentiles(total)
+	for i, s := range byStep {
+		s.WallTime = percentiles(wall[i])
+		s.ActivationDuration = percentiles(act[i])
+		s.WatcherOverhead = percentiles(over[i])
+		res.Steps = append(res.Steps, *s)
+	}
+	sort.Slice(res.Steps, func(i, j int) bool { return res.Steps[i].Index < res.Steps[j].Index })
+	return res
+}
+
+func percentiles(values []int64) Percentiles {
+	if len(values) == 0 {
+		return Percentiles{}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return Percentiles{
+		P50: rank(values, 0.50),
+		P90: rank(values, 0.90),
+		P95: rank(values, 0.95),
+		P99: rank(values, 0.99),
+		Max: values[len(values)-1],
+	}
+}
+
+/*
+	Nearest-rank percentile p of sorted values.
+*/
+func rank(sorted []int64, p float64) int64 {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
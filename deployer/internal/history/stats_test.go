@@ -0,0 +1,81 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package history
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPercentiles(t *testing.T) {
+	hundred := make([]int64, 100)
+	for i := range hundred {
+		hundred[i] = int64(100 - i)
+	}
+	tests := []struct {
+		name   string
+		values []int64
+		want   Percentiles
+	}{
+		{"empty", nil, Percentiles{}},
+		{"single sample", []int64{7}, Percentiles{7, 7, 7, 7, 7}},
+		{"two samples", []int64{9, 3}, Percentiles{3, 9, 9, 9, 9}},
+		{"ten samples", []int64{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, Percentiles{5, 9, 10, 10, 10}},
+		{"hundred samples", hundred, Percentiles{50, 90, 95, 99, 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentiles(tt.values); got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	if s := Summarize(nil); s.Count != 0 || s.MissRate != 0 || s.Steps == nil {
+		t.Fatalf("summary of nothing %+v", s)
+	}
+
+	step := func(index int, wall int64) Step {
+		return Step{Index: index, Function: string(rune('a' + index)), WallTime: wall, ActivationDuration: wall / 2, WatcherOverhead: 1}
+	}
+	s := Summarize([]Execution{
+		{Outcome: OUTCOME_MET, TotalTime: 30, Steps: []Step{step(1, 10), step(0, 20)}},
+		{Outcome: OUTCOME_MISSED, TotalTime: 50, Steps: []Step{step(0, 40), step(1, 10)}},
+		{Outcome: OUTCOME_FAILED, TotalTime: 1, Steps: []Step{step(0, 1000)}},
+		{Outcome: OUTCOME_MET, TotalTime: 40, Steps: []Step{step(0, 30), step(1, 10)}},
+	})
+	if s.Count != 4 || s.Met != 2 || s.Missed != 1 || s.Failed != 1 {
+		t.Fatalf("counts %+v", s)
+	}
+	if math.Abs(s.MissRate-0.5) > 1e-9 {
+		t.Fatalf("miss rate %v, want failures counted as misses", s.MissRate)
+	}
+	if s.TotalTime != (Percentiles{40, 50, 50, 50, 50}) {
+		t.Fatalf("total time %+v, want failed executions left out", s.TotalTime)
+	}
+	if len(s.Steps) != 2 || s.Steps[0].Index != 0 || s.Steps[1].Index != 1 {
+		t.Fatalf("steps %+v, want ordered by index", s.Steps)
+	}
+	if st := s.Steps[0]; st.Function != "a" || st.Count != 3 || st.WallTime.Max != 40 || st.ActivationDuration.P50 != 15 {
+		t.Fatalf("first step %+v", st)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const OPEN_TIMEOUT time.Duration = 5 * time.Second
+
+/*
+	Selects executions of a sequence.
+	Zero From/To leave the range open,
+	empty Outcome matches every outcome
+	and Limit 0 returns all matches.
+*/
+type Filter struct {
+	From    time.Time
+	To      time.Time
+	Outcome string
+	Limit   int
+}
+
+/*
+	Embedded store of sequence executions.
+	Each sequence has a bucket, keyed by start
+	time and activation id, so time ranges
+	are served by a cursor scan.
+*/
+type Store struct {
+	db *bolt.DB
+}
+
+/*
+	Opens the store kept in file path,
+	creating it if missing.
+*/
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: OPEN_TIMEOUT})
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+/*
+	Records an execution.
+	Reporting the same activation twice
+	overwrites the first report.
+*/
+func (s *Store) Add(e *Execution) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	value, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(e.Sequence))
+		if err != nil {
+			return err
+		}
+		return b.Put(executionKey(e.Start, e.ActivationID), value)
+	})
+}
+
+/*
+	Returns executions of sequence matching
+	filter, most recent first.
+*/
+func (s *Store) Query(sequence string, f Filter) ([]Execution, error) {
+	res := []Execution{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(sequence))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		var k, v []byte
+		if f.To.IsZero() {
+			k, v = c.Last()
+		} else if k, v = c.Seek(executionKey(f.To.Add(time.Nanosecond), "")); k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		for ; k != nil; k, v = c.Prev() {
+			if !f.From.IsZero() && keyTime(k).Before(f.From) {
+				break
+			}
+			var e Execution
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if f.Outcome != "" && e.Outcome != f.Outcome {
+				continue
+			}
+			res = append(res, e)
+			if f.Limit > 0 && len(res) == f.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return res, err
+}
+
+/*
+	Returns names of sequences
+	with recorded executions.
+*/
+func (s *Store) Sequences() ([]string, error) {
+	res := []string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			res = append(res, string(name))
+			return nil
+		})
+	})
+	return res, err
+}
+
+func executionKey(start time.Time, activation string) []byte {
+	k := make([]byte, 8, 8+len(activation))
+	binary.BigEndian.PutUint64(k, uint64(start.UnixNano()))
+	return append(k, activation...)
+}
+
+func keyTime(k []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(k[:8])))
+}
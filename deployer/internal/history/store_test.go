@@ -0,0 +1,121 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package history
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func openTestStore(t *testing.T) *Store {
+	s, err := Open(filepath.Join(t.TempDir(), "executions.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func activations(l []Execution) []string {
+	res := []string{}
+	for _, e := range l {
+		res = append(res, e.ActivationID)
+	}
+	return res
+}
+
+func TestQuery(t *testing.T) {
+	s := openTestStore(t)
+	for i, outcome := range []string{OUTCOME_MET, OUTCOME_MISSED, OUTCOME_MET, OUTCOME_FAILED, OUTCOME_MET} {
+		e := Execution{
+			Sequence:     "seq",
+			ActivationID: string(rune('a' + i)),
+			Start:        epoch.Add(time.Duration(i) * time.Second),
+			Outcome:      outcome,
+		}
+		if err := s.Add(&e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Add(&Execution{Sequence: "other", ActivationID: "z", Start: epoch, Outcome: OUTCOME_MET})
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"everything", Filter{}, []string{"e", "d", "c", "b", "a"}},
+		{"from", Filter{From: epoch.Add(2 * time.Second)}, []string{"e", "d", "c"}},
+		{"to", Filter{To: epoch.Add(time.Second)}, []string{"b", "a"}},
+		{"range", Filter{From: epoch.Add(time.Second), To: epoch.Add(3 * time.Second)}, []string{"d", "c", "b"}},
+		{"to past the last", Filter{To: epoch.Add(time.Hour)}, []string{"e", "d", "c", "b", "a"}},
+		{"to before the first", Filter{To: epoch.Add(-time.Second)}, []string{}},
+		{"outcome", Filter{Outcome: OUTCOME_MET}, []string{"e", "c", "a"}},
+		{"outcome in range", Filter{From: epoch.Add(time.Second), Outcome: OUTCOME_MET}, []string{"e", "c"}},
+		{"unmatched outcome", Filter{To: epoch.Add(2 * time.Second), Outcome: OUTCOME_FAILED}, []string{}},
+		{"limit", Filter{Outcome: OUTCOME_MET, Limit: 2}, []string{"e", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := s.Query("seq", tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := activations(l); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if l, err := s.Query("missing", Filter{}); err != nil || len(l) != 0 {
+		t.Fatalf("unknown sequence: %v, %v", l, err)
+	}
+	if seqs, _ := s.Sequences(); !reflect.DeepEqual(seqs, []string{"other", "seq"}) {
+		t.Fatalf("sequences %v", seqs)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	s := openTestStore(t)
+	for _, e := range []Execution{
+		{ActivationID: "a", Start: epoch, Outcome: OUTCOME_MET},
+		{Sequence: "seq", ActivationID: "a", Outcome: OUTCOME_MET},
+		{Sequence: "seq", ActivationID: "a", Start: epoch, Outcome: "late"},
+	} {
+		if err := s.Add(&e); err == nil {
+			t.Errorf("invalid execution %+v stored", e)
+		}
+	}
+
+	first := Execution{Sequence: "seq", ActivationID: "a", Start: epoch, Outcome: OUTCOME_MISSED}
+	again := first
+	again.Outcome = OUTCOME_MET
+	again.Steps = []Step{{Index: 1, Function: "f", AllocationTimedOut: true}}
+	s.Add(&first)
+	s.Add(&again)
+	l, _ := s.Query("seq", Filter{})
+	if len(l) != 1 || l[0].Outcome != OUTCOME_MET || !l[0].Steps[0].AllocationTimedOut {
+		t.Fatalf("reported twice: %+v", l)
+	}
+}
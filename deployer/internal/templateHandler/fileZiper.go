@@ -24,6 +24,7 @@ import (
 	"archive/zip"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -36,14 +37,30 @@ const (
 `
 	CONFIG_CONTROLLER_FILE string = "config.go"
 	ZIP_ARCHIVE_PATH       string = "%v/%v.zip"
+	EXECUTIONS_PATH        string = "%v/api/sequences/%v/executions"
+	CONTROLLER_TOKEN_ENV   string = "AUTH_CONTROLLER_TOKEN" // Token controllers present to watcher supreme and deployer
+	TLS_CA_ENV             string = "TLS_CA_FILE"           // CA of watcher supreme and deployer certificates
+	OPENWHISK_CA_ENV       string = "OPENWHISK_CA_FILE"
 	CONSTANTS              string = `const (
 		ALGORITHM_TYPE string = "%v"
 		KUBE_MAIN_IP string = "%v"
+		WEIGHT int64 = %v
+		PRIORITY int64 = %v
+		ALLOCATION_WAIT string = "%v"
+		OTLP_ENDPOINT string = "%v"
+		SEQUENCE_NAME string = "%v"
+		REPORT string = "%v"
+		REPORT_ENDPOINT string = "%v"
+		AUTH_TOKEN string = "%v"
+		TLS_CA string = %q
+		OPENWHISK_CA string = %q
 )
 `
 	VARIABLES string = `var (
 		functionList = [...]string{"%v"}
 		profiledExecutionTimes = [...]int64{%v}
+		memoryLimits = [...]int64{%v}
+		pinnedCores = [...]int64{%v}
 )
 `
 )
@@ -89,6 +106,7 @@ func (obj *fileZiper) zipTemplate(seq sq.Sequence) (string, error) {
 /*
 	Recursive function that reads the contents of basePath
 	and moves them to a zip folder, provided by w *zip.Writer.
+	Go test files are left out.
 */
 func addFiles(w *zip.Writer, basePath, baseInZip string) error {
 	files, err := ioutil.ReadDir(basePath)
@@ -97,7 +115,10 @@ func addFiles(w *zip.Writer, basePath, baseInZip string) error {
 	}
 
 	for _, file := range files {
-		if !file.IsDir() {
+		if strings.HasSuffix(file.Name(), "_test.go") {
+			// Tests of the template stay out of actions
+			continue
+		} else if !file.IsDir() {
 			dat, errR := ioutil.ReadFile(basePath + file.Name())
 			if errR != nil {
 				return errR
@@ -125,16 +146,27 @@ func addFiles(w *zip.Writer, basePath, baseInZip string) error {
 	to zip archive.
 */
 func addConfig(w *zip.Writer, seq sq.Sequence) error {
+	tlsCA, errT := readPEM(TLS_CA_ENV)
+	if errT != nil {
+		return errT
+	}
+	whiskCA, errC := readPEM(OPENWHISK_CA_ENV)
+	if errC != nil {
+		return errC
+	}
 	f, errF := w.Create(CONFIG_CONTROLLER_FILE)
 	if errF != nil {
 		return errF
 	}
 
 	dat := []byte(PACKAGE_DEFINITION +
-		fmt.Sprintf(CONSTANTS, seq.AlgorithmType, os.Getenv("HOST_IP")) +
+		fmt.Sprintf(CONSTANTS, seq.AlgorithmType, os.Getenv("HOST_IP"), seq.Weight, seq.Priority(), seq.AllocationWait,
+			os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), seq.Name, seq.Report, reportEndpoint(seq), os.Getenv(CONTROLLER_TOKEN_ENV), tlsCA, whiskCA) +
 		fmt.Sprintf(VARIABLES,
 			strings.Join(seq.Functions, "\",\""),
-			strings.Join(stringify(seq.ProfiledExecutionTimes), ",")))
+			strings.Join(stringify(seq.ProfiledExecutionTimes), ","),
+			strings.Join(stringify(perFunction(seq.MemoryLimits, len(seq.Functions))), ","),
+			strings.Join(stringify(perFunction(seq.PinnedCores, len(seq.Functions))), ",")))
 
 	_, errW := f.Write(dat)
 	if errW != nil {
@@ -143,6 +175,47 @@ func addConfig(w *zip.Writer, seq sq.Sequence) error {
 	return nil
 }
 
+/*
+	Returns where the controller posts execution
+	reports, empty if deployer does not store them
+	or its endpoint (DEPLOYER_ENDPOINT) is unknown.
+*/
+func reportEndpoint(seq sq.Sequence) string {
+	deployer := os.Getenv("DEPLOYER_ENDPOINT")
+	if deployer == "" || (seq.Report != sq.REPORT_DEPLOYER && seq.Report != sq.REPORT_BOTH) {
+		return ""
+	}
+	return fmt.Sprintf(EXECUTIONS_PATH, strings.TrimSuffix(deployer, "/"), url.PathEscape(seq.Name))
+}
+
+/*
+	Reads the PEM bundle named by env, read at every
+	deployment so controllers pick up rotated CAs.
+	Empty when env is not set.
+*/
+func readPEM(env string) (string, error) {
+	path := os.Getenv(env)
+	if path == "" {
+		return "", nil
+	}
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(dat)), nil
+}
+
+/*
+	Fills an optional per function setting
+	with zeros when it is omitted.
+*/
+func perFunction(l []int64, n int) []int64 {
+	if len(l) == 0 {
+		return make([]int64, n)
+	}
+	return l
+}
+
 func stringify(l []int64) []string {
 	s := make([]string, len(l))
 	for i, elem := range l {
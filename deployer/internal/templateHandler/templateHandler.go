@@ -22,6 +22,7 @@ package templateHandler
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -37,6 +38,7 @@ const (
 	OPENWHISK_CONTROLLER_TEMPLATE string = "/tmp/controller/openwhisk/"
 	OPENFAAS_CONTROLLER_TEMPLATE  string = "/tmp/controller/openfaas/"
 	GO_RUNTIME                    string = "go:1.15"
+	SEQUENCE_ANNOTATION           string = "sequence-clock"
 )
 
 type TemplateInterface interface {
@@ -91,9 +93,12 @@ func (tpl *Template) Deploy() error {
 	timeout := 300000
 	concurrency := 1
 	newAction := whisk.Action{
-		Name:        tpl.Sequence.Name,
-		Namespace:   os.Getenv("NAMESPACE"),
-		Annotations: whisk.KeyValueArr{whisk.KeyValue{Key: "provide-api-key", Value: "true"}},
+		Name:      tpl.Sequence.Name,
+		Namespace: os.Getenv("NAMESPACE"),
+		Annotations: whisk.KeyValueArr{
+			whisk.KeyValue{Key: "provide-api-key", Value: "true"},
+			whisk.KeyValue{Key: SEQUENCE_ANNOTATION, Value: *tpl.Sequence},
+		},
 		Limits: &whisk.Limits{
 			Timeout:     &timeout,
 			Concurrency: &concurrency,
@@ -130,6 +135,27 @@ func (tpl *Template) Delete() error {
 	return nil
 }
 
+/*
+	Returns sequence deployed as action,
+	read from its annotation. Reports false
+	for actions not deployed by SequenceClock.
+*/
+func SequenceOf(action whisk.Action) (*sq.Sequence, bool) {
+	value := action.Annotations.GetValue(SEQUENCE_ANNOTATION)
+	if value == nil {
+		return nil, false
+	}
+	dat, err := json.Marshal(value)
+	if err != nil {
+		return nil, false
+	}
+	var seq sq.Sequence
+	if err := json.Unmarshal(dat, &seq); err != nil {
+		return nil, false
+	}
+	return &seq, true
+}
+
 /*
 	Mini function that finds execution path.
 */
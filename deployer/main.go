@@ -21,32 +21,68 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	tpl "github.com/john98nf/SequenceClock/deployer/internal/templateHandler"
 	"github.com/john98nf/SequenceClock/deployer/pkg/sequence"
+	"github.com/john98nf/SequenceClock/watcher/pkg/auth"
+	"github.com/john98nf/SequenceClock/watcher/pkg/certs"
 
 	"github.com/apache/openwhisk-client-go/whisk"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	ACTION_LIST_PAGE int           = 200 // Openwhisk maximum
+	WHISK_TIMEOUT    time.Duration = 30 * time.Second
+)
+
+var (
+	guard     *auth.Guard
+	certStore *certs.Store
+	whiskCA   *certs.Store
 )
 
 func main() {
+	guard = auth.MustFromEnv()
+	certStore = certs.FromEnv()
 	router := gin.Default()
+	registerMetrics(prometheus.DefaultRegisterer)
+	executions = openHistory()
+
+	// GET: http://localhost:8080/metrics
+	router.GET("/metrics", guard.Require(auth.ROLE_METRICS, auth.ROLE_ADMIN), gin.WrapH(promhttp.Handler()))
 
 	deployerAPI := router.Group("/api")
 	{
 		// GET: http://localhost:8080/api/check
 		deployerAPI.GET("/check", check)
 		// POST: http://localhost:8080/api/create?name=x
-		deployerAPI.POST("/create", create)
+		deployerAPI.POST("/create", guard.Require(auth.ROLE_ADMIN), create)
 		// DELETE: http://localhost:8080/api/delete?name=x
-		deployerAPI.DELETE("/delete", delete)
+		deployerAPI.DELETE("/delete", guard.Require(auth.ROLE_ADMIN), delete)
+		// GET: http://localhost:8080/api/sequences
+		deployerAPI.GET("/sequences", guard.Require(auth.ROLE_ADMIN), listSequences)
+		// GET: http://localhost:8080/api/sequences/x
+		deployerAPI.GET("/sequences/:name", guard.Require(auth.ROLE_ADMIN), getSequence)
+		// POST: http://localhost:8080/api/sequences/x/executions
+		deployerAPI.POST("/sequences/:name/executions", guard.Require(auth.ROLE_CONTROLLER, auth.ROLE_ADMIN), ingestExecution)
+		// GET: http://localhost:8080/api/sequences/x/executions?from=168h&outcome=missed
+		deployerAPI.GET("/sequences/:name/executions", guard.Require(auth.ROLE_ADMIN), listExecutions)
 	}
 
-	router.Run(":42000")
+	whiskCA = openWhiskCA()
+
+	if err := certStore.ListenAndServe(":42000", router); err != nil {
+		panic(err)
+	}
 }
 
 /*
@@ -61,6 +97,8 @@ func check(c *gin.Context) {
 	and deploying it to cluster.
 */
 func create(c *gin.Context) {
+	start := time.Now()
+	defer func() { observeDeploy(start, c.Writer.Status()) }()
 	var seq sequence.Sequence
 	if err := c.ShouldBind(&seq); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -70,13 +108,7 @@ func create(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	wskConfig := &whisk.Config{
-		Host:      os.Getenv("API_HOST"),
-		Namespace: os.Getenv("NAMESPACE"),
-		AuthToken: os.Getenv("OPENWHISK_AUTH_TOKEN"),
-		Insecure:  true,
-	}
-	client, errCl := whisk.NewClient(http.DefaultClient, wskConfig)
+	client, errCl := newWhiskClient()
 	if errCl != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": errCl.Error()})
 		return
@@ -105,19 +137,14 @@ func create(c *gin.Context) {
 	API call for deleting a sequence
 */
 func delete(c *gin.Context) {
+	defer func() { observeDelete(c.Writer.Status()) }()
 	sequence := c.Query("name")
 	if sequence == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "no sequence name provided"})
 		return
 	}
 
-	wskConfig := &whisk.Config{
-		Host:      os.Getenv("API_HOST"),
-		Namespace: os.Getenv("NAMESPACE"),
-		AuthToken: os.Getenv("OPENWHISK_AUTH_TOKEN"),
-		Insecure:  true,
-	}
-	client, errCl := whisk.NewClient(http.DefaultClient, wskConfig)
+	client, errCl := newWhiskClient()
 	if errCl != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": errCl.Error()})
 		return
@@ -143,6 +170,98 @@ func delete(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("sequence '%v' deleted", sequence)})
 		}
 	} else {
-		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprint("no '%v' sequence detected", sequence)})
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no '%v' sequence detected", sequence)})
+	}
+}
+
+/*
+	API call for listing sequences
+	deployed by SequenceClock.
+*/
+func listSequences(c *gin.Context) {
+	client, errCl := newWhiskClient()
+	if errCl != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errCl.Error()})
+		return
+	}
+	res := []sequence.Sequence{}
+	options := &whisk.ActionListOptions{Limit: ACTION_LIST_PAGE}
+	for {
+		actions, _, errL := client.Actions.List("", options)
+		if errL != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": errL.Error()})
+			return
+		}
+		for _, a := range actions {
+			if seq, ok := tpl.SequenceOf(a); ok {
+				res = append(res, *seq)
+			}
+		}
+		if len(actions) < options.Limit {
+			break
+		}
+		options.Skip += len(actions)
+	}
+	c.JSON(http.StatusOK, gin.H{"sequences": res})
+}
+
+/*
+	API call for the spec of a
+	deployed sequence.
+*/
+func getSequence(c *gin.Context) {
+	client, errCl := newWhiskClient()
+	if errCl != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errCl.Error()})
+		return
+	}
+	action, resp, errG := client.Actions.Get(c.Param("name"), false)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no '%v' sequence detected", c.Param("name"))})
+		return
+	} else if errG != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errG.Error()})
+		return
+	}
+	seq, ok := tpl.SequenceOf(*action)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("'%v' is not a sequence", c.Param("name"))})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sequence": seq})
+}
+
+/*
+	Creates an openwhisk client for
+	the namespace of the deployer.
+	The api host is verified against
+	OPENWHISK_CA_FILE when given, and is
+	otherwise trusted unless OPENWHISK_INSECURE
+	is false.
+*/
+func newWhiskClient() (*whisk.Client, error) {
+	wskConfig := &whisk.Config{
+		Host:      os.Getenv("API_HOST"),
+		Namespace: os.Getenv("NAMESPACE"),
+		AuthToken: os.Getenv("OPENWHISK_AUTH_TOKEN"),
+		Insecure:  whiskCA == nil && os.Getenv("OPENWHISK_INSECURE") != "false",
+	}
+	var httpClient *http.Client
+	if whiskCA != nil {
+		httpClient = whiskCA.HTTPClient(true)
+		httpClient.Timeout = WHISK_TIMEOUT
+	}
+	return whisk.NewClient(httpClient, wskConfig)
+}
+
+/*
+	CA bundle of the openwhisk api host,
+	reloaded as it rotates.
+*/
+func openWhiskCA() *certs.Store {
+	store, err := certs.NewStore(context.Background(), certs.Files{CA: os.Getenv("OPENWHISK_CA_FILE")})
+	if err != nil {
+		panic(fmt.Errorf("openwhisk ca not loaded: %v", err))
 	}
+	return store
 }
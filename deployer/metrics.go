@@ -0,0 +1,85 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const METRICS_NAMESPACE string = "sequence_clock_deployer"
+
+var (
+	deploys = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "deploys_total",
+		Help:      "Sequence deployments by outcome (ok, invalid, error).",
+	}, []string{"result"})
+	deployDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "deploy_duration_seconds",
+		Help:      "Duration of sequence deployments.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	})
+	deletes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "deletes_total",
+		Help:      "Sequence deletions by outcome (ok, invalid, error).",
+	}, []string{"result"})
+	executionsIngested = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "executions_ingested_total",
+		Help:      "Execution reports stored, by sequence and outcome (met, missed, failed).",
+	}, []string{"sequence", "outcome"})
+)
+
+/*
+	Outcome label of an api call response.
+*/
+func resultOf(status int) string {
+	switch {
+	case status < 300:
+		return "ok"
+	case status < 500:
+		return "invalid"
+	default:
+		return "error"
+	}
+}
+
+/*
+	Registers deployer metrics
+	with reg, the default registry
+	outside of tests.
+*/
+func registerMetrics(reg prometheus.Registerer) {
+	reg.MustRegister(deploys, deployDuration, deletes, executionsIngested)
+}
+
+func observeDeploy(start time.Time, status int) {
+	deployDuration.Observe(time.Since(start).Seconds())
+	deploys.WithLabelValues(resultOf(status)).Inc()
+}
+
+func observeDelete(status int) {
+	deletes.WithLabelValues(resultOf(status)).Inc()
+}
@@ -0,0 +1,264 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/john98nf/SequenceClock/deployer/internal/history"
+	sq "github.com/john98nf/SequenceClock/deployer/pkg/sequence"
+)
+
+type DeployerInterface interface {
+	Check(ctx context.Context) error
+	Create(ctx context.Context, seq *sq.Sequence) error
+	Delete(ctx context.Context, name string) error
+	List(ctx context.Context) ([]sq.Sequence, error)
+	Get(ctx context.Context, name string) (*sq.Sequence, error)
+	ReportExecution(ctx context.Context, e *Execution) error
+	Executions(ctx context.Context, name string, f Filter) (*ExecutionList, error)
+}
+
+const (
+	REQUEST_TIMEOUT time.Duration = 5 * time.Minute // Deployments build and upload a controller
+	DEFAULT_RETRIES int           = 3
+	DEFAULT_BACKOFF time.Duration = 500 * time.Millisecond
+)
+
+type (
+	Execution = history.Execution
+	Step      = history.Step
+	Summary   = history.Summary
+	Filter    = history.Filter // Limit caps listed executions, the summary covers every match
+)
+
+/*
+	Executions of a sequence, newest first,
+	along with their latency summary.
+*/
+type ExecutionList struct {
+	Sequence   string      `json:"sequence"`
+	Executions []Execution `json:"executions"`
+	Summary    Summary     `json:"summary"`
+}
+
+type DeployerClient struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	Retries    int           // Extra attempts after network failures or temporary backend errors
+	Backoff    time.Duration // Delay before the first retry, doubled on every next one
+	Token      string        // Bearer token, needed when the deployer enables auth
+}
+
+/*
+	Client of the deployer at endpoint,
+	e.g. http://node:32043.
+*/
+func NewDeployerClient(endpoint string) *DeployerClient {
+	return &DeployerClient{
+		BaseURL:    strings.TrimSuffix(endpoint, "/") + "/api",
+		HTTPClient: &http.Client{Timeout: REQUEST_TIMEOUT},
+		Retries:    DEFAULT_RETRIES,
+		Backoff:    DEFAULT_BACKOFF,
+	}
+}
+
+/*
+	Liveness of the deployer.
+*/
+func (d *DeployerClient) Check(ctx context.Context) error {
+	return d.do(ctx, "GET", "/check", nil, nil)
+}
+
+/*
+	Creates a sequence, or replaces
+	a sequence with the same name.
+*/
+func (d *DeployerClient) Create(ctx context.Context, seq *sq.Sequence) error {
+	if err := seq.Validate(); err != nil {
+		return &ValidationError{Message: err.Error()}
+	}
+	return d.do(ctx, "POST", "/create", seq, nil)
+}
+
+func (d *DeployerClient) Delete(ctx context.Context, name string) error {
+	return d.do(ctx, "DELETE", "/delete?name="+url.QueryEscape(name), nil, nil)
+}
+
+/*
+	Sequences deployed by SequenceClock.
+*/
+func (d *DeployerClient) List(ctx context.Context) ([]sq.Sequence, error) {
+	res := struct {
+		Sequences []sq.Sequence `json:"sequences"`
+	}{}
+	if err := d.do(ctx, "GET", "/sequences", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Sequences, nil
+}
+
+func (d *DeployerClient) Get(ctx context.Context, name string) (*sq.Sequence, error) {
+	res := struct {
+		Sequence *sq.Sequence `json:"sequence"`
+	}{}
+	if err := d.do(ctx, "GET", "/sequences/"+url.PathEscape(name), nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Sequence, nil
+}
+
+/*
+	Stores an execution report. Reports are keyed
+	by start time and activation id, so sending
+	the same report again replaces it.
+*/
+func (d *DeployerClient) ReportExecution(ctx context.Context, e *Execution) error {
+	if err := e.Validate(); err != nil {
+		return &ValidationError{Message: err.Error()}
+	}
+	return d.do(ctx, "POST", "/sequences/"+url.PathEscape(e.Sequence)+"/executions", e, nil)
+}
+
+func (d *DeployerClient) Executions(ctx context.Context, name string, f Filter) (*ExecutionList, error) {
+	q := url.Values{}
+	if !f.From.IsZero() {
+		q.Set("from", f.From.Format(time.RFC3339Nano))
+	}
+	if !f.To.IsZero() {
+		q.Set("to", f.To.Format(time.RFC3339Nano))
+	}
+	if f.Outcome != "" {
+		q.Set("outcome", f.Outcome)
+	}
+	if f.Limit > 0 {
+		q.Set("limit", fmt.Sprint(f.Limit))
+	}
+	res := &ExecutionList{}
+	if err := d.do(ctx, "GET", "/sequences/"+url.PathEscape(name)+"/executions?"+q.Encode(), nil, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+/*
+	Calls the deployer, retrying network failures
+	and temporary backend errors with exponential
+	backoff until retries are spent or ctx is done.
+	Every deployer call is safe to repeat.
+*/
+func (d *DeployerClient) do(ctx context.Context, method, path string, body, res interface{}) error {
+	var dat []byte
+	if body != nil {
+		var err error
+		if dat, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	backoff := d.Backoff
+	for attempt := 0; ; attempt++ {
+		err := d.send(ctx, method, path, dat, res)
+		if err == nil || attempt >= d.Retries || !retriable(ctx, err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (d *DeployerClient) send(ctx context.Context, method, path string, body []byte, res interface{}) error {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, d.BaseURL+path, rd)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if d.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+d.Token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := d.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dat, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if res == nil {
+			return nil
+		}
+		if err := json.Unmarshal(dat, res); err != nil {
+			return &BackendError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("invalid answer: %v", err)}
+		}
+		return nil
+	}
+
+	msg := struct {
+		Error string `json:"error"`
+	}{}
+	if json.Unmarshal(dat, &msg) != nil || msg.Error == "" {
+		msg.Error = strings.TrimSpace(string(dat))
+	}
+	switch resp.StatusCode {
+	case http.StatusBadRequest:
+		return &ValidationError{Message: msg.Error}
+	case http.StatusNotFound:
+		return &NotFoundError{Message: msg.Error}
+	default:
+		return &BackendError{StatusCode: resp.StatusCode, Message: msg.Error}
+	}
+}
+
+func retriable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var be *BackendError
+	if errors.As(err, &be) {
+		return be.Temporary()
+	}
+	var ve *ValidationError
+	var ne *NotFoundError
+	return !errors.As(err, &ve) && !errors.As(err, &ne)
+}
@@ -0,0 +1,211 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	sq "github.com/john98nf/SequenceClock/deployer/pkg/sequence"
+)
+
+/*
+	Call received by the test deployer.
+*/
+type call struct {
+	method string
+	path   string
+	query  url.Values
+	token  string
+	body   []byte
+}
+
+/*
+	Deployer answering every call with status
+	and body, recording the calls it received.
+*/
+func testDeployer(t *testing.T, status int, body string) (*DeployerClient, *[]call) {
+	calls := &[]call{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := call{method: r.Method, path: r.URL.Path, query: r.URL.Query(), token: r.Header.Get("Authorization")}
+		if r.Body != nil {
+			var dat json.RawMessage
+			json.NewDecoder(r.Body).Decode(&dat)
+			c.body = dat
+		}
+		*calls = append(*calls, c)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	d := NewDeployerClient(srv.URL + "/")
+	d.Token = "admin"
+	d.Backoff = time.Millisecond
+	return d, calls
+}
+
+var testSequence = sq.Sequence{
+	Name: "seq", Framework: "openwhisk", AlgorithmType: "greedy",
+	Functions: []string{"a", "b"}, ProfiledExecutionTimes: []int64{100, 200},
+}
+
+func TestCalls(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	d, calls := testDeployer(t, http.StatusOK, `{"message":"ok"}`)
+	if err := d.Create(ctx, &testSequence); err != nil {
+		t.Fatal(err)
+	}
+	var sent sq.Sequence
+	json.Unmarshal((*calls)[0].body, &sent)
+	if c := (*calls)[0]; c.method != "POST" || c.path != "/api/create" || c.token != "Bearer admin" || sent.Name != "seq" {
+		t.Fatalf("create sent %+v", c)
+	}
+	if err := d.Delete(ctx, "a seq"); err != nil {
+		t.Fatal(err)
+	}
+	if c := (*calls)[1]; c.method != "DELETE" || c.path != "/api/delete" || c.query.Get("name") != "a seq" {
+		t.Fatalf("delete sent %+v", c)
+	}
+
+	d, calls = testDeployer(t, http.StatusOK, `{"sequences":[{"name":"seq","functions":["a","b"]}]}`)
+	l, err := d.List(ctx)
+	if err != nil || len(l) != 1 || l[0].Name != "seq" || len(l[0].Functions) != 2 {
+		t.Fatalf("list %+v: %v", l, err)
+	}
+	if c := (*calls)[0]; c.method != "GET" || c.path != "/api/sequences" {
+		t.Fatalf("list sent %+v", c)
+	}
+
+	d, calls = testDeployer(t, http.StatusOK, `{"sequence":{"name":"seq","allocationWait":"500ms"}}`)
+	seq, err := d.Get(ctx, "seq")
+	if err != nil || seq.Name != "seq" || seq.AllocationWait != "500ms" {
+		t.Fatalf("get %+v: %v", seq, err)
+	}
+	if c := (*calls)[0]; c.method != "GET" || c.path != "/api/sequences/seq" {
+		t.Fatalf("get sent %+v", c)
+	}
+
+	d, calls = testDeployer(t, http.StatusOK, `{"sequence":"seq","executions":[{"activationId":"act","outcome":"met","steps":[{"index":0,"allocationTimedOut":true}]}],"summary":{"count":1,"met":1}}`)
+	res, err := d.Executions(ctx, "seq", Filter{From: start, To: start.Add(time.Hour), Outcome: "met", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Sequence != "seq" || len(res.Executions) != 1 || !res.Executions[0].Steps[0].AllocationTimedOut || res.Summary.Met != 1 {
+		t.Fatalf("executions %+v", res)
+	}
+	want := url.Values{
+		"from": {"2021-06-01T12:00:00Z"}, "to": {"2021-06-01T13:00:00Z"},
+		"outcome": {"met"}, "limit": {"10"},
+	}
+	if c := (*calls)[0]; c.path != "/api/sequences/seq/executions" || c.query.Encode() != want.Encode() {
+		t.Fatalf("executions sent %+v", c)
+	}
+	d.Executions(ctx, "seq", Filter{})
+	if q := (*calls)[1].query; len(q) != 0 {
+		t.Fatalf("empty filter sent %v", q)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	d, calls := testDeployer(t, http.StatusOK, `{}`)
+	var ve *ValidationError
+	invalid := testSequence
+	invalid.ProfiledExecutionTimes = []int64{100}
+	if err := d.Create(context.Background(), &invalid); !errors.As(err, &ve) {
+		t.Fatalf("inconsistent sequence: %v", err)
+	}
+	if err := d.ReportExecution(context.Background(), &Execution{Sequence: "seq", Outcome: "late", Start: time.Now()}); !errors.As(err, &ve) {
+		t.Fatalf("unknown outcome: %v", err)
+	}
+	if len(*calls) != 0 {
+		t.Fatalf("invalid calls sent: %+v", *calls)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		check  func(error) bool
+		want   string
+		calls  int
+	}{
+		{"validation", http.StatusBadRequest, `{"error":"bad spec"}`,
+			func(err error) bool { var e *ValidationError; return errors.As(err, &e) }, "validation failed: bad spec", 1},
+		{"not found", http.StatusNotFound, `{"error":"unknown sequence"}`,
+			func(err error) bool { var e *NotFoundError; return errors.As(err, &e) }, "not found: unknown sequence", 1},
+		{"backend", http.StatusInternalServerError, "openwhisk unreachable\n",
+			func(err error) bool { var e *BackendError; return errors.As(err, &e) && !e.Temporary() }, "deployer failure (500): openwhisk unreachable", 1},
+		{"unauthorized", http.StatusUnauthorized, `{"error":"unauthorized"}`,
+			func(err error) bool { var e *BackendError; return errors.As(err, &e) && e.StatusCode == 401 }, "deployer failure (401): unauthorized", 1},
+		{"temporary", http.StatusServiceUnavailable, `{"error":"busy"}`,
+			func(err error) bool { var e *BackendError; return errors.As(err, &e) && e.Temporary() }, "deployer failure (503): busy", 1 + DEFAULT_RETRIES},
+		{"invalid answer", http.StatusOK, "<html>",
+			func(err error) bool { var e *BackendError; return errors.As(err, &e) && e.StatusCode == 200 }, "", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, calls := testDeployer(t, tt.status, tt.body)
+			_, err := d.List(context.Background())
+			if err == nil || !tt.check(err) {
+				t.Fatalf("error %#v", err)
+			}
+			if tt.want != "" && err.Error() != tt.want {
+				t.Fatalf("error %q, want %q", err, tt.want)
+			}
+			if len(*calls) != tt.calls {
+				t.Fatalf("%v calls, want %v", len(*calls), tt.calls)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts++; attempts < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"sequences":[]}`))
+	}))
+	defer srv.Close()
+	d := NewDeployerClient(srv.URL)
+	d.Backoff = time.Millisecond
+	if _, err := d.List(context.Background()); err != nil || attempts != 3 {
+		t.Fatalf("after %v attempts: %v", attempts, err)
+	}
+
+	attempts = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := d.Check(ctx); err == nil || attempts != 0 {
+		t.Fatalf("canceled call: %v after %v attempts", err, attempts)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package client
+
+import "fmt"
+
+/*
+	Sequence or execution rejected, either
+	by the deployer or before being sent.
+*/
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return "validation failed: " + e.Message
+}
+
+/*
+	Sequence not deployed by SequenceClock.
+*/
+type NotFoundError struct {
+	Message string
+}
+
+func (e *NotFoundError) Error() string {
+	return "not found: " + e.Message
+}
+
+/*
+	Failure of the deployer or of a system
+	behind it (openwhisk, execution store).
+*/
+type BackendError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *BackendError) Error() string {
+	return fmt.Sprintf("deployer failure (%v): %v", e.StatusCode, e.Message)
+}
+
+/*
+	Whether a failed call may succeed if repeated.
+*/
+func (e *BackendError) Temporary() bool {
+	switch e.StatusCode {
+	case 429, 502, 503, 504:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,12 @@
+module github.com/john98nf/SequenceClock/deployer/pkg/client
+
+go 1.15
+
+replace github.com/john98nf/SequenceClock/deployer/pkg/sequence => ../sequence
+
+replace github.com/john98nf/SequenceClock/deployer/internal/history => ../../internal/history
+
+require (
+	github.com/john98nf/SequenceClock/deployer/internal/history v0.0.0-00010101000000-000000000000
+	github.com/john98nf/SequenceClock/deployer/pkg/sequence v0.0.0-00010101000000-000000000000
+)
@@ -2,14 +2,40 @@ package sequence
 
 import (
 	"fmt"
+	"time"
 )
 
+const (
+	BATCH_CLASS       string = "batch"
+	STANDARD_CLASS    string = "standard"
+	INTERACTIVE_CLASS string = "interactive"
+	REPORT_RESULT     string = "result"
+	REPORT_DEPLOYER   string = "deployer"
+	REPORT_BOTH       string = "both"
+)
+
+/*
+	Priority of every sequence class.
+	Watchers serve higher priorities first.
+*/
+var PriorityClasses = map[string]int64{
+	BATCH_CLASS:       -1,
+	STANDARD_CLASS:    0,
+	INTERACTIVE_CLASS: 1,
+}
+
 type Sequence struct {
-	Name                   string   `form:"name" binding:"required" schema:"name"`
-	Framework              string   `form:"framework" binding:"required" schema:"framework"`
-	AlgorithmType          string   `form:"algorithm" binding:"required" schema:"algorithm"`
-	Functions              []string `form:"functions" binding:"required" schema:"functions"`
-	ProfiledExecutionTimes []int64  `form:"profiledExecutionTimes" binding:"required" schema:"profiledExecutionTimes"`
+	Name                   string   `json:"name" form:"name" binding:"required" schema:"name"`
+	Framework              string   `json:"framework" form:"framework" binding:"required" schema:"framework"`
+	AlgorithmType          string   `json:"algorithm" form:"algorithm" binding:"required" schema:"algorithm"`
+	Functions              []string `json:"functions" form:"functions" binding:"required" schema:"functions"`
+	ProfiledExecutionTimes []int64  `json:"profiledExecutionTimes" form:"profiledExecutionTimes" binding:"required" schema:"profiledExecutionTimes"`
+	MemoryLimits           []int64  `json:"memoryLimits,omitempty" form:"memoryLimits" schema:"memoryLimits"`       // Optional, MB per function, 0 keeps container limit
+	PinnedCores            []int64  `json:"pinnedCores,omitempty" form:"pinnedCores" schema:"pinnedCores"`          // Optional, dedicated cores per function, 0 disables pinning
+	Weight                 int64    `json:"weight,omitempty" form:"weight" schema:"weight"`                         // Optional, share under weighted allocation policy
+	PriorityClass          string   `json:"priorityClass,omitempty" form:"priorityClass" schema:"priorityClass"`    // Optional, defaults to standard
+	AllocationWait         string   `json:"allocationWait,omitempty" form:"allocationWait" schema:"allocationWait"` // Optional, wait for each grant up to this long (e.g. 500ms)
+	Report                 string   `json:"report,omitempty" form:"report" schema:"report"`                         // Optional, execution report goes to result, deployer or both
 }
 
 /*
@@ -37,5 +63,31 @@ func (s *Sequence) Validate() error {
 	if len(s.ProfiledExecutionTimes) != len(s.Functions) {
 		return fmt.Errorf(("inconsistent sequence"))
 	}
+	if len(s.MemoryLimits) != 0 && len(s.MemoryLimits) != len(s.Functions) {
+		return fmt.Errorf("inconsistent memory limits")
+	}
+	if len(s.PinnedCores) != 0 && len(s.PinnedCores) != len(s.Functions) {
+		return fmt.Errorf("inconsistent pinned cores")
+	}
+	if _, ok := PriorityClasses[s.PriorityClass]; s.PriorityClass != "" && !ok {
+		return fmt.Errorf("unknown priority class %v", s.PriorityClass)
+	}
+	switch s.Report {
+	case "", REPORT_RESULT, REPORT_DEPLOYER, REPORT_BOTH:
+	default:
+		return fmt.Errorf("unknown report destination %v", s.Report)
+	}
+	if s.AllocationWait != "" {
+		if d, err := time.ParseDuration(s.AllocationWait); err != nil || d <= 0 {
+			return fmt.Errorf("invalid allocation wait %v", s.AllocationWait)
+		}
+	}
 	return nil
 }
+
+/*
+	Returns numeric priority of sequence class.
+*/
+func (s *Sequence) Priority() int64 {
+	return PriorityClasses[s.PriorityClass]
+}
@@ -0,0 +1,86 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const REQUEST_TIMEOUT time.Duration = 5 * time.Minute // Deployments build and upload a controller
+
+var httpClient = &http.Client{Timeout: REQUEST_TIMEOUT}
+
+/*
+	Calls a SequenceClock api, decoding a json
+	answer into res if not nil. Answers other
+	than 2xx are returned as errors, using the
+	error message of the body when present.
+	The token of the context is presented.
+*/
+func (env *environment) call(method, url string, body interface{}, res interface{}) error {
+	var rd io.Reader
+	if body != nil {
+		dat, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(dat)
+	}
+	req, err := http.NewRequest(method, url, rd)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if env.context.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+env.context.Token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dat, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg := struct {
+			Error string `json:"error"`
+		}{}
+		if json.Unmarshal(dat, &msg) == nil && msg.Error != "" {
+			return fmt.Errorf("%v (%v)", msg.Error, resp.StatusCode)
+		}
+		return fmt.Errorf("%s (%v)", bytes.TrimSpace(dat), resp.StatusCode)
+	}
+	if res == nil {
+		return nil
+	}
+	return json.Unmarshal(dat, res)
+}
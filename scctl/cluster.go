@@ -0,0 +1,173 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+	Catalogs of watcher supreme.
+*/
+type catalogs struct {
+	Requests  map[string]*string `json:"requests"` // Request id -> node, nil while pending
+	Functions map[string]string  `json:"functions"`
+	Orphaned  uint64             `json:"orphaned"`
+}
+
+/*
+	Part of a watcher registry entry shown by scctl.
+*/
+type functionState struct {
+	Container     string
+	Quotas        int64
+	DesiredQuotas int64
+	Memory        int64
+	Cpuset        string
+	Priority      int64
+	Preempted     bool
+	Requests      struct {
+		Active map[string]int64
+	}
+}
+
+type registry struct {
+	Node          string                   `json:"node"`
+	Registry      map[string]functionState `json:"registry"`
+	Policy        string                   `json:"policy"`
+	ReapedLeases  uint64                   `json:"reapedLeases"`
+	LambdaResyncs uint64                   `json:"lambdaResyncs"`
+}
+
+func fetchCatalogs(env *environment) (*catalogs, error) {
+	res := &catalogs{}
+	if err := env.call("GET", env.context.WatcherSupreme+"/api/catalogs", nil, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+/*
+	scctl catalogs
+*/
+func catalogsCmd(env *environment, args []string) error {
+	cat, err := fetchCatalogs(env)
+	if err != nil {
+		return err
+	}
+	if env.output == OUTPUT_JSON {
+		return env.print(cat, nil, nil)
+	}
+	perNode := map[string]int{}
+	pending := 0
+	for _, node := range cat.Requests {
+		if node == nil {
+			pending++
+		} else {
+			perNode[*node]++
+		}
+	}
+	rows := [][]string{}
+	for _, f := range sortedKeys(cat.Functions) {
+		node := cat.Functions[f]
+		rows = append(rows, []string{f, node, fmt.Sprint(perNode[node])})
+	}
+	if err := env.print(nil, []string{"FUNCTION", "NODE", "NODE REQUESTS"}, rows); err != nil {
+		return err
+	}
+	fmt.Printf("\nrequests: %v, pending: %v, orphaned resets: %v\n", len(cat.Requests), pending, cat.Orphaned)
+	return nil
+}
+
+/*
+	scctl registry [NODE...]
+	Without nodes, watchers of the context are used,
+	or the nodes found in watcher supreme catalogs.
+*/
+func registryCmd(env *environment, args []string) error {
+	nodes := args
+	if len(nodes) == 0 {
+		nodes = env.context.Watchers
+	}
+	if len(nodes) == 0 {
+		cat, err := fetchCatalogs(env)
+		if err != nil {
+			return fmt.Errorf("no watchers configured and catalogs unavailable: %v", err)
+		}
+		seen := map[string]bool{}
+		for _, n := range cat.Functions {
+			if !seen[n] {
+				seen[n] = true
+				nodes = append(nodes, n)
+			}
+		}
+		sort.Strings(nodes)
+	}
+	res := []registry{}
+	for _, n := range nodes {
+		reg := registry{Node: n}
+		if err := env.call("GET", env.context.watcherEndpoint(n)+"/api/registry", nil, &reg); err != nil {
+			return fmt.Errorf("watcher %v: %v", n, err)
+		}
+		res = append(res, reg)
+	}
+	rows := [][]string{}
+	for _, reg := range res {
+		for _, f := range sortedKeys(reg.Registry) {
+			s := reg.Registry[f]
+			quotas := fmt.Sprint(s.Quotas)
+			if s.Cpuset != "" {
+				quotas = "cpuset " + s.Cpuset
+			}
+			preempted := ""
+			if s.Preempted {
+				preempted = "yes"
+			}
+			rows = append(rows, []string{reg.Node, f, shortID(s.Container), quotas, fmt.Sprint(s.DesiredQuotas),
+				fmt.Sprint(s.Memory >> 20), fmt.Sprint(s.Priority), fmt.Sprint(len(s.Requests.Active)), preempted})
+		}
+	}
+	return env.print(res, []string{"NODE", "FUNCTION", "CONTAINER", "QUOTAS", "DESIRED", "MEMORY MB", "PRIORITY", "REQUESTS", "PREEMPTED"}, rows)
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+func sortedKeys(m interface{}) []string {
+	var res []string
+	switch m := m.(type) {
+	case map[string]string:
+		for k := range m {
+			res = append(res, k)
+		}
+	case map[string]functionState:
+		for k := range m {
+			res = append(res, k)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,311 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/john98nf/SequenceClock/deployer/pkg/client"
+	sq "github.com/john98nf/SequenceClock/deployer/pkg/sequence"
+)
+
+const TEST_TOKEN string = "admin"
+
+/*
+	Deployer keeping sequences in memory.
+	Sequence "broken" fails to deploy.
+*/
+type fakeDeployer struct {
+	mu        sync.Mutex
+	sequences map[string]sq.Sequence
+	query     url.Values // Of the last executions call
+}
+
+func (d *fakeDeployer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	answer := func(code int, body interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(body)
+	}
+	if r.Header.Get("Authorization") != "Bearer "+TEST_TOKEN {
+		answer(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/api/sequences/")
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/api/create":
+		var seq sq.Sequence
+		if err := json.NewDecoder(r.Body).Decode(&seq); err != nil {
+			answer(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		} else if seq.Name == "broken" {
+			answer(http.StatusInternalServerError, map[string]string{"error": "openwhisk unreachable"})
+		} else {
+			d.sequences[seq.Name] = seq
+			answer(http.StatusOK, map[string]string{"message": "ok"})
+		}
+	case r.Method == "DELETE" && r.URL.Path == "/api/delete":
+		if _, ok := d.sequences[r.URL.Query().Get("name")]; !ok {
+			answer(http.StatusNotFound, map[string]string{"error": "unknown sequence"})
+			return
+		}
+		delete(d.sequences, r.URL.Query().Get("name"))
+		answer(http.StatusOK, map[string]string{"message": "ok"})
+	case r.Method == "GET" && r.URL.Path == "/api/sequences":
+		l := []sq.Sequence{}
+		for _, s := range d.sequences {
+			l = append(l, s)
+		}
+		answer(http.StatusOK, map[string]interface{}{"sequences": l})
+	case r.Method == "GET" && strings.HasSuffix(name, "/executions"):
+		d.query = r.URL.Query()
+		start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+		answer(http.StatusOK, client.ExecutionList{
+			Sequence: strings.TrimSuffix(name, "/executions"),
+			Executions: []client.Execution{
+				{ActivationID: "act-2", Start: start.Add(time.Second), Outcome: "missed", TotalTime: int64(2 * time.Second), Steps: []client.Step{{Node: "node-b"}}},
+				{ActivationID: "act-1", Start: start, Outcome: "met", TotalTime: int64(time.Second), Steps: []client.Step{{Node: "node-a"}}},
+			},
+			Summary: client.Summary{Count: 2, Met: 1, Missed: 1, MissRate: 0.5},
+		})
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/api/sequences/"):
+		seq, ok := d.sequences[name]
+		if !ok {
+			answer(http.StatusNotFound, map[string]string{"error": "unknown sequence"})
+			return
+		}
+		answer(http.StatusOK, map[string]interface{}{"sequence": seq})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func testEnv(t *testing.T, output string) (*environment, *fakeDeployer) {
+	d := &fakeDeployer{sequences: map[string]sq.Sequence{}}
+	srv := httptest.NewServer(d)
+	t.Cleanup(srv.Close)
+	env := &environment{
+		context:  Context{Deployer: srv.URL, WatcherSupreme: srv.URL, Token: TEST_TOKEN},
+		output:   output,
+		deployer: client.NewDeployerClient(srv.URL),
+	}
+	env.deployer.Token = TEST_TOKEN
+	env.deployer.Retries = 0
+	return env, d
+}
+
+/*
+	Runs a command, returning what it printed.
+*/
+func run(t *testing.T, env *environment, cmd string, args ...string) (string, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = commands[cmd].run(env, args)
+	os.Stdout = stdout
+	w.Close()
+	out, _ := ioutil.ReadAll(r)
+	return string(out), err
+}
+
+func writeSpec(t *testing.T, spec string) string {
+	path := filepath.Join(t.TempDir(), "spec.yaml")
+	if err := ioutil.WriteFile(path, []byte(spec), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const SPEC string = `name: first
+framework: openwhisk
+algorithm: greedy
+functions: [a, b]
+profiledExecutionTimes: [100, 200]
+priorityClass: interactive
+---
+name: second
+framework: openwhisk
+algorithm: fair
+functions: [c]
+profiledExecutionTimes: [300]
+`
+
+func TestApply(t *testing.T) {
+	env, d := testEnv(t, OUTPUT_TABLE)
+	out, err := run(t, env, "apply", "-f", writeSpec(t, SPEC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "sequence 'first' applied\nsequence 'second' applied\n" {
+		t.Fatalf("output %q", out)
+	}
+	if s := d.sequences["first"]; s.PriorityClass != sq.INTERACTIVE_CLASS || len(s.Functions) != 2 || s.ProfiledExecutionTimes[1] != 200 {
+		t.Fatalf("deployed %+v", s)
+	}
+
+	for spec, want := range map[string]string{
+		"name: first\nframework: openwhisk\nunknown: 1\n":                                                      "unknown field",
+		"name: first\nframework: openwhisk\nfunctions: [a]\nprofiledExecutionTimes: [1, 2]\n":                  "inconsistent sequence",
+		"name: broken\nframework: openwhisk\nalgorithm: greedy\nfunctions: [a]\nprofiledExecutionTimes: [1]\n": "sequence 'broken': deployer failure (500): openwhisk unreachable",
+	} {
+		if _, err := run(t, env, "apply", "-f", writeSpec(t, spec)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("apply %q: error %v, want %q", spec, err, want)
+		}
+	}
+	if _, ok := d.sequences["broken"]; ok || len(d.sequences) != 2 {
+		t.Fatalf("sequences %v", d.sequences)
+	}
+}
+
+func TestListAndGet(t *testing.T) {
+	env, d := testEnv(t, OUTPUT_TABLE)
+	d.sequences["first"] = sq.Sequence{Name: "first", Framework: "openwhisk", AlgorithmType: "greedy",
+		Functions: []string{"a", "b"}, ProfiledExecutionTimes: []int64{100, 200}, AllocationWait: "500ms"}
+
+	out, err := run(t, env, "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || strings.Join(strings.Fields(lines[0]), " ") != "NAME ALGORITHM FUNCTIONS CLASS WAIT REPORT" ||
+		strings.Join(strings.Fields(lines[1]), " ") != "first greedy a,b standard 500ms" {
+		t.Fatalf("list %q", out)
+	}
+
+	out, err = run(t, env, "get", "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"name: first\n", "algorithm: greedy\n", "allocationWait: 500ms\n", "    - 200\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("get yaml %q, missing %q", out, want)
+		}
+	}
+
+	env.output = OUTPUT_JSON
+	out, _ = run(t, env, "list")
+	var seqs []sq.Sequence
+	if err := json.Unmarshal([]byte(out), &seqs); err != nil || len(seqs) != 1 || seqs[0].Name != "first" {
+		t.Fatalf("list json %q: %v", out, err)
+	}
+	out, _ = run(t, env, "get", "first")
+	var seq sq.Sequence
+	if err := json.Unmarshal([]byte(out), &seq); err != nil || seq.AllocationWait != "500ms" {
+		t.Fatalf("get json %q: %v", out, err)
+	}
+
+	if _, err := run(t, env, "get", "missing"); err == nil || err.Error() != "not found: unknown sequence" {
+		t.Fatalf("get of unknown sequence: %v", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	env, d := testEnv(t, OUTPUT_TABLE)
+	d.sequences["first"] = sq.Sequence{Name: "first"}
+	d.sequences["second"] = sq.Sequence{Name: "second"}
+
+	out, err := run(t, env, "delete", "first", "missing", "second")
+	if err == nil || err.Error() != "sequence 'missing': not found: unknown sequence" {
+		t.Fatalf("delete of unknown sequence: %v", err)
+	}
+	if out != "sequence 'first' deleted\n" {
+		t.Fatalf("output %q, want deletion stopped at the unknown sequence", out)
+	}
+	if _, ok := d.sequences["second"]; !ok || len(d.sequences) != 1 {
+		t.Fatalf("sequences %v", d.sequences)
+	}
+	if _, err := run(t, env, "delete"); err == nil {
+		t.Fatal("delete without names accepted")
+	}
+}
+
+func TestExecutions(t *testing.T) {
+	env, d := testEnv(t, OUTPUT_TABLE)
+	out, err := run(t, env, "executions", "first", "-from", "2021-06-01T12:00:00Z", "-outcome", "met", "-limit", "5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := url.Values{"from": {"2021-06-01T12:00:00Z"}, "outcome": {"met"}, "limit": {"5"}}
+	if d.query.Encode() != want.Encode() {
+		t.Fatalf("query %v, want %v", d.query, want)
+	}
+	lines := strings.Split(out, "\n")
+	if len(lines) < 3 || !strings.Contains(lines[1], "act-2") || !strings.Contains(lines[1], "node-b") || !strings.Contains(lines[2], "act-1") {
+		t.Fatalf("executions %q, want newest first", out)
+	}
+	if !strings.Contains(out, "executions: 2, met: 1, missed: 1, failed: 0, miss rate: 50.00%") {
+		t.Fatalf("executions %q, missing summary", out)
+	}
+
+	if _, err := run(t, env, "executions", "first", "-from", "yesterday"); err == nil {
+		t.Fatal("invalid time accepted")
+	}
+	env.output = OUTPUT_JSON
+	out, _ = run(t, env, "executions", "first")
+	var l client.ExecutionList
+	if err := json.Unmarshal([]byte(out), &l); err != nil || l.Sequence != "first" || len(l.Executions) != 2 {
+		t.Fatalf("executions json %q: %v", out, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	env, _ := testEnv(t, OUTPUT_TABLE)
+	env.deployer.Token = "wrong"
+	if _, err := run(t, env, "list"); err == nil || err.Error() != "deployer failure (401): unauthorized" {
+		t.Fatalf("list with a wrong token: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{"json error", http.StatusServiceUnavailable, `{"error":"not leading"}`, "not leading (503)"},
+		{"plain error", http.StatusBadGateway, "bad gateway\n", "bad gateway (502)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			env.context.WatcherSupreme = srv.URL
+			if _, err := run(t, env, "catalogs"); err == nil || err.Error() != tt.want {
+				t.Fatalf("error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	CONFIG_ENV       string = "SCCTL_CONFIG"
+	TOKEN_ENV        string = "SCCTL_TOKEN"
+	CONFIG_FILE      string = ".scctl/config"
+	DEPLOYER_DEFAULT string = "http://localhost:42000"
+	SUPREME_DEFAULT  string = "http://localhost:32042"
+	WATCHER_PORT     string = "8080"
+)
+
+/*
+	Endpoints of a SequenceClock installation.
+	Watchers are optional, node addresses
+	are taken from watcher supreme otherwise.
+*/
+type Context struct {
+	Name           string   `yaml:"name" json:"name"`
+	Deployer       string   `yaml:"deployer" json:"deployer"`
+	WatcherSupreme string   `yaml:"watcherSupreme" json:"watcherSupreme"`
+	Watchers       []string `yaml:"watchers,omitempty" json:"watchers,omitempty"`
+	Token          string   `yaml:"token,omitempty" json:"-"`             // Bearer token of a sequence admin
+	CA             string   `yaml:"ca,omitempty" json:"ca,omitempty"`     // CA bundle of the installation certificates
+	Cert           string   `yaml:"cert,omitempty" json:"cert,omitempty"` // Client certificate, when watchers enforce mutual TLS
+	Key            string   `yaml:"key,omitempty" json:"key,omitempty"`
+}
+
+/*
+	Kubeconfig-like scctl configuration.
+*/
+type Config struct {
+	CurrentContext string    `yaml:"current-context"`
+	Contexts       []Context `yaml:"contexts"`
+}
+
+func defaultConfigPath() string {
+	if p := os.Getenv(CONFIG_ENV); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return CONFIG_FILE
+	}
+	return filepath.Join(home, CONFIG_FILE)
+}
+
+/*
+	Reads config from path.
+	A missing file gives an empty config.
+*/
+func LoadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	dat, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(dat, cfg); err != nil {
+		return nil, fmt.Errorf("config %v: %v", path, err)
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) Save(path string) error {
+	dat, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, dat, 0600)
+}
+
+/*
+	Returns context named name, or current
+	context if name is empty. Without any,
+	local default endpoints are used.
+*/
+func (cfg *Config) Context(name string) (Context, error) {
+	if name == "" {
+		name = cfg.CurrentContext
+	}
+	for _, c := range cfg.Contexts {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	if name == "" {
+		return Context{Deployer: DEPLOYER_DEFAULT, WatcherSupreme: SUPREME_DEFAULT}, nil
+	}
+	return Context{}, fmt.Errorf("context '%v' not found", name)
+}
+
+func (cfg *Config) setContext(ctx Context) {
+	for i, c := range cfg.Contexts {
+		if c.Name == ctx.Name {
+			cfg.Contexts[i] = ctx
+			return
+		}
+	}
+	cfg.Contexts = append(cfg.Contexts, ctx)
+}
+
+/*
+	Returns watcher endpoint of a node,
+	given either as address or as url.
+	Watchers serve https when a CA is set.
+*/
+func (c Context) watcherEndpoint(node string) string {
+	if strings.Contains(node, "://") {
+		return strings.TrimSuffix(node, "/")
+	}
+	if !strings.Contains(node, ":") {
+		node += ":" + WATCHER_PORT
+	}
+	if c.CA != "" {
+		return "https://" + node
+	}
+	return "http://" + node
+}
+
+/*
+	TLS settings of the context, nil
+	when neither a CA nor a client
+	certificate is configured.
+*/
+func (c Context) tlsConfig() (*tls.Config, error) {
+	if c.CA == "" && c.Cert == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.CA != "" {
+		dat, err := ioutil.ReadFile(c.CA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(dat) {
+			return nil, fmt.Errorf("no certificate found in %v", c.CA)
+		}
+	}
+	if c.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+/*
+	scctl config get-contexts
+	scctl config use-context NAME
+	scctl config set-context NAME [-deployer URL] [-supreme URL] [-watchers NODE,...] [-token TOKEN]
+
+		[-ca FILE] [-cert FILE -key FILE]
+*/
+func configCmd(env *environment, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("config needs a subcommand: get-contexts, use-context or set-context")
+	}
+	switch args[0] {
+	case "get-contexts":
+		rows := [][]string{}
+		for _, c := range env.config.Contexts {
+			current := ""
+			if c.Name == env.config.CurrentContext {
+				current = "*"
+			}
+			rows = append(rows, []string{current, c.Name, c.Deployer, c.WatcherSupreme, strings.Join(c.Watchers, ",")})
+		}
+		return env.print(env.config.Contexts, []string{"CURRENT", "NAME", "DEPLOYER", "WATCHER SUPREME", "WATCHERS"}, rows)
+	case "use-context":
+		if len(args) != 2 {
+			return fmt.Errorf("use-context needs a context name")
+		}
+		if _, err := env.config.Context(args[1]); err != nil {
+			return err
+		}
+		env.config.CurrentContext = args[1]
+		return env.config.Save(env.configPath)
+	case "set-context":
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			return fmt.Errorf("set-context needs a context name before its flags")
+		}
+		ctx, err := env.config.Context(args[1])
+		if err != nil {
+			ctx = Context{Name: args[1], Deployer: DEPLOYER_DEFAULT, WatcherSupreme: SUPREME_DEFAULT}
+		}
+		fs := flag.NewFlagSet("set-context", flag.ContinueOnError)
+		deployer := fs.String("deployer", ctx.Deployer, "deployer endpoint")
+		supreme := fs.String("supreme", ctx.WatcherSupreme, "watcher supreme endpoint")
+		watchers := fs.String("watchers", strings.Join(ctx.Watchers, ","), "comma separated watcher nodes")
+		token := fs.String("token", ctx.Token, "admin token, when auth is enabled")
+		ca := fs.String("ca", ctx.CA, "CA bundle, when tls is enabled")
+		cert := fs.String("cert", ctx.Cert, "client certificate, when watchers require one")
+		key := fs.String("key", ctx.Key, "key of the client certificate")
+		if err := fs.Parse(args[2:]); err != nil {
+			return err
+		}
+		ctx.Deployer, ctx.WatcherSupreme, ctx.Token = *deployer, *supreme, *token
+		ctx.CA, ctx.Cert, ctx.Key = absPath(*ca), absPath(*cert), absPath(*key)
+		ctx.Watchers = nil
+		if *watchers != "" {
+			ctx.Watchers = strings.Split(*watchers, ",")
+		}
+		env.config.setContext(ctx)
+		if env.config.CurrentContext == "" {
+			env.config.CurrentContext = ctx.Name
+		}
+		return env.config.Save(env.configPath)
+	default:
+		return fmt.Errorf("unknown config subcommand '%v'", args[0])
+	}
+}
+
+/*
+	Context files are kept absolute,
+	scctl may run from any directory.
+*/
+func absPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
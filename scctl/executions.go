@@ -0,0 +1,163 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/john98nf/SequenceClock/deployer/pkg/client"
+)
+
+const FOLLOW_INTERVAL time.Duration = 5 * time.Second
+
+/*
+	scctl executions NAME [-from T] [-to T] [-outcome O] [-limit N] [-follow]
+*/
+func executionsCmd(env *environment, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing sequence name")
+	}
+	name := args[0]
+	fs := flag.NewFlagSet("executions", flag.ContinueOnError)
+	from := fs.String("from", "", "RFC3339 time or duration back from now (e.g. 1h)")
+	to := fs.String("to", "", "RFC3339 time or duration back from now")
+	outcome := fs.String("outcome", "", "met, missed or failed")
+	limit := fs.Int("limit", 0, "maximum number of executions")
+	follow := fs.Bool("follow", false, "poll for new executions")
+	interval := fs.Duration("interval", FOLLOW_INTERVAL, "polling interval of -follow")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	var (
+		f   client.Filter
+		err error
+	)
+	if f.From, err = parseTime(*from); err != nil {
+		return err
+	}
+	if f.To, err = parseTime(*to); err != nil {
+		return err
+	}
+	f.Outcome, f.Limit = *outcome, *limit
+
+	res, err := env.deployer.Executions(context.Background(), name, f)
+	if err != nil {
+		return err
+	}
+	if !*follow {
+		if env.output == OUTPUT_JSON {
+			return env.print(res, nil, nil)
+		}
+		if err := env.printExecutions(res.Executions, true); err != nil {
+			return err
+		}
+		s := res.Summary
+		fmt.Printf("\nexecutions: %v, met: %v, missed: %v, failed: %v, miss rate: %.2f%%\n",
+			s.Count, s.Met, s.Missed, s.Failed, 100*s.MissRate)
+		fmt.Printf("total time p50: %v, p90: %v, p99: %v, max: %v\n",
+			duration(s.TotalTime.P50), duration(s.TotalTime.P90), duration(s.TotalTime.P99), duration(s.TotalTime.Max))
+		return nil
+	}
+
+	// Executions come newest first, print them oldest first
+	// and afterwards only those started after the last one seen.
+	var last time.Time
+	seen := map[string]bool{}
+	header := true
+	f.To, f.Limit = time.Time{}, 0
+	for {
+		fresh := []client.Execution{}
+		for i := len(res.Executions) - 1; i >= 0; i-- {
+			e := res.Executions[i]
+			if seen[e.ActivationID] {
+				continue
+			}
+			seen[e.ActivationID] = true
+			fresh = append(fresh, e)
+			if e.Start.After(last) {
+				last = e.Start
+			}
+		}
+		if len(fresh) > 0 || header {
+			if err := env.printExecutions(fresh, header); err != nil {
+				return err
+			}
+			header = false
+		}
+		time.Sleep(*interval)
+		if !last.IsZero() {
+			f.From = last
+		}
+		if res, err = env.deployer.Executions(context.Background(), name, f); err != nil {
+			return err
+		}
+	}
+}
+
+func (env *environment) printExecutions(l []client.Execution, header bool) error {
+	if env.output == OUTPUT_JSON {
+		for _, e := range l {
+			if err := env.print(e, nil, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	rows := make([][]string, len(l))
+	for i, e := range l {
+		nodes := ""
+		for j, s := range e.Steps {
+			if j > 0 {
+				nodes += ","
+			}
+			nodes += s.Node
+		}
+		rows[i] = []string{e.Start.Local().Format("2006-01-02 15:04:05.000"), e.ActivationID, e.Outcome,
+			duration(e.TotalTime), duration(e.Deadline), nodes, e.Error}
+	}
+	var h []string
+	if header {
+		h = []string{"START", "ACTIVATION", "OUTCOME", "TOTAL", "DEADLINE", "NODES", "ERROR"}
+	}
+	return env.print(nil, h, rows)
+}
+
+/*
+	Parses a time flag, either RFC3339
+	or a duration back from now.
+*/
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time '%v', expected RFC3339 or a duration", value)
+	}
+	return t, nil
+}
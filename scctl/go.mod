@@ -0,0 +1,15 @@
+module github.com/john98nf/SequenceClock/scctl
+
+go 1.15
+
+replace github.com/john98nf/SequenceClock/deployer/pkg/sequence => ../deployer/pkg/sequence
+
+replace github.com/john98nf/SequenceClock/deployer/pkg/client => ../deployer/pkg/client
+
+replace github.com/john98nf/SequenceClock/deployer/internal/history => ../deployer/internal/history
+
+require (
+	github.com/john98nf/SequenceClock/deployer/pkg/client v0.0.0-00010101000000-000000000000
+	github.com/john98nf/SequenceClock/deployer/pkg/sequence v0.0.0-00010101000000-000000000000
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,144 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/john98nf/SequenceClock/deployer/pkg/client"
+)
+
+/*
+	Subcommand of scctl.
+*/
+type command struct {
+	usage string
+	run   func(env *environment, args []string) error
+}
+
+var commands = map[string]command{
+	"apply":      {"apply -f FILE           create or update sequences of a spec file", applyCmd},
+	"delete":     {"delete NAME...          delete sequences", deleteCmd},
+	"list":       {"list                    list deployed sequences", listCmd},
+	"get":        {"get NAME                show spec of a sequence", getCmd},
+	"executions": {"executions NAME [flags] list or follow executions of a sequence", executionsCmd},
+	"catalogs":   {"catalogs                show watcher supreme catalogs", catalogsCmd},
+	"registry":   {"registry [NODE...]      show registries of watchers", registryCmd},
+	"config":     {"config SUBCOMMAND       manage contexts (get-contexts, use-context, set-context)", configCmd},
+}
+
+/*
+	Settings every command runs with.
+*/
+type environment struct {
+	config     *Config
+	configPath string
+	context    Context
+	output     string
+	deployer   *client.DeployerClient
+}
+
+func main() {
+	global := flag.NewFlagSet("scctl", flag.ExitOnError)
+	configPath := global.String("config", defaultConfigPath(), "config file")
+	contextName := global.String("context", "", "context to use, current context by default")
+	output := global.String("o", OUTPUT_TABLE, "output format: table or json")
+	deployer := global.String("deployer", "", "deployer endpoint, overrides context")
+	supreme := global.String("supreme", "", "watcher supreme endpoint, overrides context")
+	token := global.String("token", os.Getenv(TOKEN_ENV), "admin token, overrides context")
+	global.Usage = usage(global)
+	global.Parse(os.Args[1:])
+
+	if global.NArg() == 0 {
+		global.Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[global.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command '%v'\n", global.Arg(0))
+		global.Usage()
+		os.Exit(2)
+	}
+	if *output != OUTPUT_TABLE && *output != OUTPUT_JSON {
+		fmt.Fprintf(os.Stderr, "unknown output format '%v'\n", *output)
+		os.Exit(2)
+	}
+	config, err := LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	ctx, err := config.Context(*contextName)
+	if err != nil && global.Arg(0) != "config" {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	env := &environment{
+		config:     config,
+		configPath: *configPath,
+		context:    ctx,
+		output:     *output,
+	}
+	if *deployer != "" {
+		env.context.Deployer = *deployer
+	}
+	if *supreme != "" {
+		env.context.WatcherSupreme = *supreme
+	}
+	if *token != "" {
+		env.context.Token = *token
+	}
+	tlsConfig, err := env.context.tlsConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	if tlsConfig != nil {
+		httpClient.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}
+	}
+	env.deployer = client.NewDeployerClient(env.context.Deployer)
+	env.deployer.Token = env.context.Token
+	env.deployer.HTTPClient.Transport = httpClient.Transport
+	if err := cmd.run(env, global.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func usage(global *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintln(os.Stderr, "Usage: scctl [flags] COMMAND [args]\n\nCommands:")
+		names := make([]string, 0, len(commands))
+		for n := range commands {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			fmt.Fprintln(os.Stderr, "  "+commands[n].usage)
+		}
+		fmt.Fprintln(os.Stderr, "\nFlags:")
+		global.PrintDefaults()
+	}
+}
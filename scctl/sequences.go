@@ -0,0 +1,181 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	sq "github.com/john98nf/SequenceClock/deployer/pkg/sequence"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+	Reads sequences of a yaml or json spec file,
+	one per yaml document. Path "-" reads stdin.
+*/
+func readSpecs(path string) ([]sq.Sequence, error) {
+	var (
+		dat []byte
+		err error
+	)
+	if path == "-" {
+		dat, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		dat, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := []sq.Sequence{}
+	dec := yaml.NewDecoder(bytes.NewReader(dat))
+	for {
+		var doc interface{}
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		} else if doc == nil {
+			continue
+		}
+		js, err := json.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		var seq sq.Sequence
+		jdec := json.NewDecoder(bytes.NewReader(js))
+		jdec.DisallowUnknownFields()
+		if err := jdec.Decode(&seq); err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		if err := seq.Validate(); err != nil {
+			return nil, fmt.Errorf("%v: sequence '%v': %v", path, seq.Name, err)
+		}
+		res = append(res, seq)
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("%v: no sequence found", path)
+	}
+	return res, nil
+}
+
+/*
+	scctl apply -f FILE
+	Deploys every sequence of the file,
+	replacing sequences of the same name.
+*/
+func applyCmd(env *environment, args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	file := fs.String("f", "", "spec file, - for stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf("apply needs a spec file (-f)")
+	}
+	specs, err := readSpecs(*file)
+	if err != nil {
+		return err
+	}
+	for _, seq := range specs {
+		if err := env.deployer.Create(context.Background(), &seq); err != nil {
+			return fmt.Errorf("sequence '%v': %v", seq.Name, err)
+		}
+		fmt.Printf("sequence '%v' applied\n", seq.Name)
+	}
+	return nil
+}
+
+/*
+	scctl delete NAME...
+*/
+func deleteCmd(env *environment, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("delete needs a sequence name")
+	}
+	for _, name := range args {
+		if err := env.deployer.Delete(context.Background(), name); err != nil {
+			return fmt.Errorf("sequence '%v': %v", name, err)
+		}
+		fmt.Printf("sequence '%v' deleted\n", name)
+	}
+	return nil
+}
+
+/*
+	scctl list
+*/
+func listCmd(env *environment, args []string) error {
+	seqs, err := env.deployer.List(context.Background())
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, len(seqs))
+	for i, s := range seqs {
+		class := s.PriorityClass
+		if class == "" {
+			class = sq.STANDARD_CLASS
+		}
+		rows[i] = []string{s.Name, s.AlgorithmType, strings.Join(s.Functions, ","), class, s.AllocationWait, s.Report}
+	}
+	return env.print(seqs, []string{"NAME", "ALGORITHM", "FUNCTIONS", "CLASS", "WAIT", "REPORT"}, rows)
+}
+
+/*
+	scctl get NAME
+	Table output prints the spec as yaml,
+	ready to be edited and applied again.
+*/
+func getCmd(env *environment, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("get needs a sequence name")
+	}
+	seq, err := env.deployer.Get(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+	if env.output == OUTPUT_JSON {
+		return env.print(seq, nil, nil)
+	}
+	// Through json, so yaml keys match the spec files
+	js, err := json.Marshal(seq)
+	if err != nil {
+		return err
+	}
+	var spec interface{}
+	if err := json.Unmarshal(js, &spec); err != nil {
+		return err
+	}
+	dat, err := yaml.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(dat)
+	return err
+}
@@ -0,0 +1,76 @@
+#!/bin/sh
+# Copyright © 2021 Giannis Fakinos
+
+# Permission is hereby granted, free of charge, to any person obtaining a copy
+# of this software and associated documentation files (the "Software"), to deal
+# in the Software without restriction, including without limitation the rights
+# to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+# copies of the Software, and to permit persons to whom the Software is
+# furnished to do so, subject to the following conditions:
+
+# The above copyright notice and this permission notice shall be included in all
+# copies or substantial portions of the Software.
+
+# THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+# IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+# FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+# AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+# LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+# OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+# SOFTWARE.
+
+# Generates a CA and a certificate signed by it, usable by watchers,
+# watcher supreme and the deployer both as server and client certificate.
+# The CA is kept, so re-running rotates the certificate only.
+#
+#   scripts/gen-certs.sh DIR HOST...
+#
+# HOST are node addresses or names reached by controllers, watcher
+# supreme and scctl, e.g. every invoker and master node ip. Then:
+#
+#   kubectl create secret generic sc-tls --from-file=DIR/tls.crt \
+#       --from-file=DIR/tls.key --from-file=DIR/ca.crt
+#   helm install ... --set tls.enabled=true --set tls.secretName=sc-tls
+
+set -e
+
+if [ $# -lt 2 ]; then
+	echo "usage: $0 DIR HOST..." >&2
+	exit 2
+fi
+DIR=$1
+shift
+DAYS=${DAYS:-365}
+mkdir -p "$DIR"
+
+if [ ! -f "$DIR/ca.crt" ]; then
+	openssl req -x509 -newkey rsa:2048 -nodes -days 3650 \
+		-subj "/CN=SequenceClock CA" \
+		-keyout "$DIR/ca.key" -out "$DIR/ca.crt"
+fi
+
+SAN="DNS:localhost,IP:127.0.0.1"
+for h in "$@"; do
+	case $h in
+	*[!0-9.]*) SAN="$SAN,DNS:$h" ;;
+	*) SAN="$SAN,IP:$h" ;;
+	esac
+done
+
+EXT=$(mktemp)
+trap 'rm -f "$EXT" "$DIR/tls.csr"' EXIT
+cat >"$EXT" <<EOF
+basicConstraints=CA:FALSE
+keyUsage=digitalSignature,keyEncipherment
+extendedKeyUsage=serverAuth,clientAuth
+subjectAltName=$SAN
+EOF
+
+openssl req -newkey rsa:2048 -nodes -subj "/CN=sequence-clock" \
+	-keyout "$DIR/tls.key.new" -out "$DIR/tls.csr"
+openssl x509 -req -in "$DIR/tls.csr" -days "$DAYS" \
+	-CA "$DIR/ca.crt" -CAkey "$DIR/ca.key" -CAcreateserial \
+	-extfile "$EXT" -out "$DIR/tls.crt.new"
+mv "$DIR/tls.key.new" "$DIR/tls.key"
+mv "$DIR/tls.crt.new" "$DIR/tls.crt"
+echo "certificate for $SAN written to $DIR"
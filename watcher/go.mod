@@ -26,13 +26,32 @@ replace github.com/john98nf/SequenceClock/watcher/internal/conflicts => ./intern
 
 replace github.com/john98nf/SequenceClock/watcher/pkg/request => ./pkg/request
 
+replace github.com/john98nf/SequenceClock/watcher/pkg/request/bind => ./pkg/request/bind
+
 replace github.com/john98nf/SequenceClock/watcher/internal/state => ./internal/state
 
+replace github.com/john98nf/SequenceClock/watcher/pkg/stream => ./pkg/stream
+
+replace github.com/john98nf/SequenceClock/watcher/pkg/auth => ./pkg/auth
+
+replace github.com/john98nf/SequenceClock/watcher/pkg/certs => ./pkg/certs
+
 require (
 	github.com/docker/docker v20.10.8+incompatible
 	github.com/gin-gonic/gin v1.7.4
 	github.com/john98nf/SequenceClock/watcher/internal/conflicts v0.0.0-00010101000000-000000000000
 	github.com/john98nf/SequenceClock/watcher/internal/state v0.0.0-20210901212831-7d78eb166378
+	github.com/john98nf/SequenceClock/watcher/pkg/auth v0.0.0-00010101000000-000000000000
+	github.com/john98nf/SequenceClock/watcher/pkg/certs v0.0.0-00010101000000-000000000000
 	github.com/john98nf/SequenceClock/watcher/pkg/request v0.0.0-20210820205221-369ee2bc9c4d
+	github.com/john98nf/SequenceClock/watcher/pkg/request/bind v0.0.0-00010101000000-000000000000
+	github.com/john98nf/SequenceClock/watcher/pkg/stream v0.0.0-00010101000000-000000000000
 	github.com/morikuni/aec v1.0.0 // indirect
+	github.com/prometheus/client_golang v1.11.0
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	google.golang.org/grpc v1.41.0
+	k8s.io/cri-api v0.22.1 // indirect
 )
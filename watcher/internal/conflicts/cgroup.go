@@ -0,0 +1,216 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package conflicts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	CPU_MAX_FILE       string      = "cpu.max"
+	CPU_WEIGHT_FILE    string      = "cpu.weight"
+	MEMORY_MAX_FILE    string      = "memory.max"
+	CPUSET_CPUS_FILE   string      = "cpuset.cpus"
+	CPU_WEIGHT_DEFAULT int64       = 100
+	CPU_WEIGHT_MIN     int64       = 1
+	CPU_WEIGHT_MAX     int64       = 10000
+	CGROUP_FILE_MODE   os.FileMode = 0644
+)
+
+// Stops the cgroup walk at the first match
+var errCgroupFound = errors.New("cgroup found")
+
+/*
+	Resource controller that writes cgroup v2
+	interface files of a container directly.
+*/
+type CgroupController struct {
+	mutex sync.Mutex
+	Root  string
+	paths map[string]string
+}
+
+func NewCgroupController(root string) *CgroupController {
+	return &CgroupController{
+		mutex: sync.Mutex{},
+		Root:  root,
+		paths: make(map[string]string),
+	}
+}
+
+/*
+	Writes cpu.max and cpu.weight of container cgroup.
+	Quota -1 lifts the limit and restores default weight.
+	Weight follows the granted share of a core,
+	so contended cores are split the same way.
+*/
+func (cc *CgroupController) UpdateCPUQuota(ctx context.Context, containerID string, cpuQuota int64) error {
+	cpuMax, weight := cgroupCPUValues(cpuQuota)
+	err := cc.write(containerID, CPU_MAX_FILE, cpuMax, CPU_WEIGHT_FILE, weight)
+	if os.IsNotExist(err) {
+		// Cached cgroup may belong to a restarted container.
+		cc.forget(containerID)
+		err = cc.write(containerID, CPU_MAX_FILE, cpuMax, CPU_WEIGHT_FILE, weight)
+	}
+	return err
+}
+
+/*
+	Writes memory.max of container cgroup.
+*/
+func (cc *CgroupController) UpdateMemory(ctx context.Context, containerID string, memory int64) error {
+	value := strconv.FormatInt(memory, 10)
+	err := cc.write(containerID, MEMORY_MAX_FILE, value)
+	if os.IsNotExist(err) {
+		cc.forget(containerID)
+		err = cc.write(containerID, MEMORY_MAX_FILE, value)
+	}
+	return err
+}
+
+/*
+	Writes cpuset.cpus of container cgroup.
+	Requires the cpuset controller to be enabled
+	down to the container cgroup.
+*/
+func (cc *CgroupController) UpdateCpuset(ctx context.Context, containerID string, cpus string) error {
+	err := cc.write(containerID, CPUSET_CPUS_FILE, cpus)
+	if os.IsNotExist(err) {
+		cc.forget(containerID)
+		err = cc.write(containerID, CPUSET_CPUS_FILE, cpus)
+	}
+	return err
+}
+
+/*
+	Reads memory.max of container cgroup,
+	0 if unlimited.
+*/
+func (cc *CgroupController) MemoryLimit(ctx context.Context, containerID string) (int64, error) {
+	dir, err := cc.cgroupPath(containerID)
+	if err != nil {
+		return 0, err
+	}
+	dat, err := ioutil.ReadFile(filepath.Join(dir, MEMORY_MAX_FILE))
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(dat))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+/*
+	Writes pairs of (interface file, value)
+	into container cgroup.
+*/
+func (cc *CgroupController) write(containerID string, files ...string) error {
+	dir, err := cc.cgroupPath(containerID)
+	if err != nil {
+		return err
+	}
+	for i := 0; i+1 < len(files); i += 2 {
+		if err := ioutil.WriteFile(filepath.Join(dir, files[i]), []byte(files[i+1]), CGROUP_FILE_MODE); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+	Finds the cgroup directory of a container.
+	Both cgroupfs (<id>) and systemd (*-<id>.scope)
+	layouts are recognised. Results are cached,
+	the walk runs without holding the cache lock.
+*/
+func (cc *CgroupController) cgroupPath(containerID string) (string, error) {
+	cc.mutex.Lock()
+	p, ok := cc.paths[containerID]
+	cc.mutex.Unlock()
+	if ok {
+		return p, nil
+	}
+	var found string
+	err := filepath.Walk(cc.Root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		name := info.Name()
+		if name == containerID || strings.HasSuffix(name, "-"+containerID+".scope") {
+			found = path
+			return errCgroupFound
+		}
+		return nil
+	})
+	if err != nil && err != errCgroupFound {
+		return "", err
+	}
+	if found == "" {
+		return "", fmt.Errorf("no cgroup found for container %v", containerID)
+	}
+	cc.mutex.Lock()
+	cc.paths[containerID] = found
+	cc.mutex.Unlock()
+	return found, nil
+}
+
+/*
+	Looks up and caches the cgroup of a container,
+	so that updates made under the registry
+	lock do not walk the hierarchy.
+*/
+func (cc *CgroupController) Resolve(containerID string) error {
+	_, err := cc.cgroupPath(containerID)
+	return err
+}
+
+func (cc *CgroupController) forget(containerID string) {
+	cc.mutex.Lock()
+	delete(cc.paths, containerID)
+	cc.mutex.Unlock()
+}
+
+/*
+	Translates CPU quotas into cpu.max
+	and cpu.weight file contents.
+*/
+func cgroupCPUValues(cpuQuota int64) (string, string) {
+	if cpuQuota < 0 {
+		return fmt.Sprintf("max %d", CPU_PERIOD_OPENWHISK_DEFAULT), fmt.Sprint(CPU_WEIGHT_DEFAULT)
+	}
+	weight := CPU_WEIGHT_DEFAULT * cpuQuota / CPU_PERIOD_OPENWHISK_DEFAULT
+	if weight < CPU_WEIGHT_MIN {
+		weight = CPU_WEIGHT_MIN
+	} else if weight > CPU_WEIGHT_MAX {
+		weight = CPU_WEIGHT_MAX
+	}
+	return fmt.Sprintf("%d %d", cpuQuota, CPU_PERIOD_OPENWHISK_DEFAULT), fmt.Sprint(weight)
+}
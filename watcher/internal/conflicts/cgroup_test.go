@@ -0,0 +1,157 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package conflicts
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+	Lays out a cgroup v2 hierarchy under a temp
+	directory, with a container cgroup at rel.
+*/
+func fakeCgroup(t *testing.T, rel string) (string, string) {
+	root := t.TempDir()
+	dir := filepath.Join(root, rel)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for file, value := range map[string]string{
+		CPU_MAX_FILE:     "max 100000",
+		CPU_WEIGHT_FILE:  "100",
+		MEMORY_MAX_FILE:  "max",
+		CPUSET_CPUS_FILE: "",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(value), CGROUP_FILE_MODE); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, dir
+}
+
+func readCgroupFile(t *testing.T, dir, file string) string {
+	dat, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(dat))
+}
+
+func TestCgroupControllerLayouts(t *testing.T) {
+	layouts := map[string]string{
+		"cgroupfs": "kubepods/burstable/pod1/abc123",
+		"systemd":  "kubepods.slice/kubepods-burstable.slice/cri-containerd-abc123.scope",
+	}
+	for name, rel := range layouts {
+		t.Run(name, func(t *testing.T) {
+			root, dir := fakeCgroup(t, rel)
+			cc := NewCgroupController(root)
+			if err := cc.UpdateCPUQuota(context.Background(), "abc123", 50000); err != nil {
+				t.Fatal(err)
+			}
+			if v := readCgroupFile(t, dir, CPU_MAX_FILE); v != "50000 100000" {
+				t.Fatalf("cpu.max %q", v)
+			}
+			if v := readCgroupFile(t, dir, CPU_WEIGHT_FILE); v != "50" {
+				t.Fatalf("cpu.weight %q", v)
+			}
+			if err := cc.UpdateCPUQuota(context.Background(), "abc123", -1); err != nil {
+				t.Fatal(err)
+			}
+			if v := readCgroupFile(t, dir, CPU_MAX_FILE); v != "max 100000" {
+				t.Fatalf("cpu.max %q after lifting the limit", v)
+			}
+			if v := readCgroupFile(t, dir, CPU_WEIGHT_FILE); v != "100" {
+				t.Fatalf("cpu.weight %q after lifting the limit", v)
+			}
+		})
+	}
+}
+
+func TestCgroupControllerMemoryAndCpuset(t *testing.T) {
+	root, dir := fakeCgroup(t, "kubepods/pod1/abc123")
+	cc := NewCgroupController(root)
+	if limit, err := cc.MemoryLimit(context.Background(), "abc123"); err != nil || limit != 0 {
+		t.Fatalf("unlimited memory read as %v, %v", limit, err)
+	}
+	if err := cc.UpdateMemory(context.Background(), "abc123", 256*MEGABYTE); err != nil {
+		t.Fatal(err)
+	}
+	if limit, err := cc.MemoryLimit(context.Background(), "abc123"); err != nil || limit != 256*MEGABYTE {
+		t.Fatalf("memory limit %v, %v", limit, err)
+	}
+	if err := cc.UpdateCpuset(context.Background(), "abc123", "2,3"); err != nil {
+		t.Fatal(err)
+	}
+	if v := readCgroupFile(t, dir, CPUSET_CPUS_FILE); v != "2,3" {
+		t.Fatalf("cpuset.cpus %q", v)
+	}
+}
+
+func TestCgroupControllerFindsMovedCgroup(t *testing.T) {
+	root, dir := fakeCgroup(t, "kubepods/pod1/abc123")
+	cc := NewCgroupController(root)
+	if err := cc.Resolve("abc123"); err != nil {
+		t.Fatal(err)
+	}
+	moved := filepath.Join(root, "kubepods", "pod2", "abc123")
+	os.MkdirAll(filepath.Dir(moved), 0755)
+	if err := os.Rename(dir, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.UpdateCPUQuota(context.Background(), "abc123", 20000); err != nil {
+		t.Fatal(err)
+	}
+	if v := readCgroupFile(t, moved, CPU_MAX_FILE); v != "20000 100000" {
+		t.Fatalf("cpu.max %q in moved cgroup", v)
+	}
+}
+
+func TestCgroupControllerUnknownContainer(t *testing.T) {
+	root, _ := fakeCgroup(t, "kubepods/pod1/abc123")
+	cc := NewCgroupController(root)
+	if err := cc.UpdateCPUQuota(context.Background(), "missing", 20000); err == nil {
+		t.Fatal("update of unknown container succeeded")
+	}
+}
+
+func TestCgroupCPUValues(t *testing.T) {
+	cases := []struct {
+		quota          int64
+		cpuMax, weight string
+	}{
+		{-1, "max 100000", "100"},
+		{100000, "100000 100000", "100"},
+		{400000, "400000 100000", "400"},
+		{500, "500 100000", "1"},
+	}
+	for _, c := range cases {
+		cpuMax, weight := cgroupCPUValues(c.quota)
+		if cpuMax != c.cpuMax || weight != c.weight {
+			t.Errorf("quota %v: got %q %q, want %q %q", c.quota, cpuMax, weight, c.cpuMax, c.weight)
+		}
+	}
+}
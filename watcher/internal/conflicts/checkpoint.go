@@ -0,0 +1,245 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package conflicts
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	wfs "github.com/john98nf/SequenceClock/watcher/internal/state"
+)
+
+const (
+	CHECKPOINT_FILE_MODE os.FileMode   = 0600
+	CHECKPOINT_DEBOUNCE  time.Duration = 200 * time.Millisecond // Coalesces bursts of registry updates
+)
+
+/*
+	Registry state kept on local disk,
+	so that a restarted watcher can
+	restore the grants of its containers.
+*/
+type checkpoint struct {
+	Registry map[string]*wfs.FunctionState `json:"registry"`
+	Reserved map[int]string                `json:"reserved"`
+	Reaped   uint64                        `json:"reaped"`
+}
+
+/*
+	Reads checkpoint file.
+	A missing file yields an empty checkpoint.
+*/
+func loadCheckpoint(path string) (*checkpoint, error) {
+	empty := &checkpoint{
+		Registry: make(map[string]*wfs.FunctionState),
+		Reserved: make(map[int]string),
+	}
+	if path == "" {
+		return empty, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return empty, nil
+	} else if err != nil {
+		return empty, err
+	}
+	cp := &checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return empty, err
+	}
+	if cp.Registry == nil {
+		cp.Registry = empty.Registry
+	}
+	if cp.Reserved == nil {
+		cp.Reserved = empty.Reserved
+	}
+	for _, s := range cp.Registry {
+		if s.Requests.Active == nil {
+			s.Requests.Active = map[uint64]int64{}
+		}
+		if s.Requests.Memory == nil {
+			s.Requests.Memory = map[uint64]int64{}
+		}
+		if s.Requests.Pinned == nil {
+			s.Requests.Pinned = map[uint64]int64{}
+		}
+		if s.Requests.Leases == nil {
+			s.Requests.Leases = map[uint64]wfs.Lease{}
+		}
+	}
+	return cp, nil
+}
+
+/*
+	Snapshots registry state for the checkpoint
+	writer, which puts it on disk in the background.
+	Caller must hold the registry lock.
+*/
+func (cr *ConflictResolver) saveCheckpoint() {
+	if cr.checkpointPath == "" {
+		return
+	}
+	data, err := json.Marshal(checkpoint{
+		Registry: cr.Registry,
+		Reserved: cr.reserved,
+		Reaped:   cr.reaped,
+	})
+	if err != nil {
+		log.Println("Registry checkpoint failed:", err.Error())
+		return
+	}
+	cr.checkpointMutex.Lock()
+	cr.checkpoint = data
+	cr.checkpointMutex.Unlock()
+	select {
+	case cr.checkpointDirty <- struct{}{}:
+	default:
+	}
+}
+
+/*
+	Writes the latest registry snapshot,
+	at most once every CHECKPOINT_DEBOUNCE.
+*/
+func (cr *ConflictResolver) runCheckpointWriter(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-cr.checkpointDirty:
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(CHECKPOINT_DEBOUNCE):
+		}
+		cr.checkpointMutex.Lock()
+		data := cr.checkpoint
+		cr.checkpoint = nil
+		cr.checkpointMutex.Unlock()
+		if data == nil {
+			continue
+		}
+		if err := writeCheckpoint(cr.checkpointPath, data); err != nil {
+			log.Println("Registry checkpoint failed:", err.Error())
+		}
+	}
+}
+
+/*
+	Replaces checkpoint file atomically. File and
+	directory are synced, so the new checkpoint
+	survives a node crash right after the rename.
+*/
+func writeCheckpoint(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if errC := tmp.Close(); err == nil {
+		err = errC
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), CHECKPOINT_FILE_MODE)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if errC := d.Close(); err == nil {
+		err = errC
+	}
+	return err
+}
+
+/*
+	Waits for the first container index sync
+	and brings every action container back to
+	its restored grant, or to CPU_QUOTAS = -1
+	when the registry does not track it.
+*/
+func (cr *ConflictResolver) reconcile(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-cr.Index.Synced():
+	}
+	cr.mutex.Lock()
+	defer cr.flushCpusets(ctx)
+	defer cr.mutex.Unlock()
+
+	for f, s := range cr.Registry {
+		cnt := cr.Index.Lookup(f, "user-action")
+		if cnt == nil || cnt.ID != s.Container {
+			log.Printf("Container %v of function '%v' is gone, dropping restored entry\n", s.Container, f)
+			cr.dropCores(ctx, f, s)
+			delete(cr.Registry, f)
+		}
+	}
+	tracked := make(map[string]bool)
+	for f, s := range cr.Registry {
+		tracked[s.Container] = true
+		for id, lease := range s.Requests.Leases {
+			grantLease(s, id, lease.TTL)
+		}
+		if s.Cpuset != "" {
+			cr.queueCpuset(s.Container, s.Cpuset)
+		}
+		cr.applyCPUQuota(ctx, s, s.Quotas)
+		// Force reconfigureMemory to apply recorded limits
+		s.Memory = 0
+		log.Printf("Restored grant of function '%v' on container %v\n", f, s.Container)
+	}
+	for _, cnt := range cr.Index.Containers("user-action") {
+		if tracked[cnt.ID] {
+			continue
+		}
+		if err := cr.updateContainerCPUQuota(ctx, cnt.ID, -1); err != nil {
+			log.Println(err.Error())
+		}
+	}
+	if len(cr.reserved) != 0 {
+		cr.updateSharedCpuset()
+	}
+	cr.lambdaPrevious = 0
+	if len(cr.Registry) != 0 {
+		cr.ReconfigureRegistry(ctx, 0, 0)
+	}
+	cr.reconfigureMemory(ctx)
+	cr.saveCheckpoint()
+}
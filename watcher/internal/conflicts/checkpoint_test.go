@@ -0,0 +1,73 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package conflicts
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpointWriter(t *testing.T) {
+	cr, _ := newTestResolver(2, &ProportionalPolicy{}, "a", "b")
+	cr.checkpointPath = filepath.Join(t.TempDir(), "registry.json")
+	cr.checkpointDirty = make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cr.runCheckpointWriter(ctx)
+
+	mustUpdate(t, cr, requestFor(1, "a", 50000))
+	mustUpdate(t, cr, requestFor(2, "b", 50000))
+	if _, err := os.Stat(cr.checkpointPath); !os.IsNotExist(err) {
+		t.Fatal("checkpoint written before debounce")
+	}
+
+	deadline := time.Now().Add(10 * CHECKPOINT_DEBOUNCE)
+	for {
+		cp, err := loadCheckpoint(cr.checkpointPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cp.Registry) == 2 {
+			if cp.Registry["b"].DesiredQuotas != 50000 {
+				t.Fatalf("restored %+v", cp.Registry["b"])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("checkpoint holds %v functions, want 2", len(cp.Registry))
+		}
+		time.Sleep(CHECKPOINT_DEBOUNCE / 4)
+	}
+	info, err := os.Stat(cr.checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != CHECKPOINT_FILE_MODE {
+		t.Fatalf("checkpoint mode %v", info.Mode().Perm())
+	}
+	matches, _ := filepath.Glob(cr.checkpointPath + ".*")
+	if len(matches) != 0 {
+		t.Fatalf("temporary files left behind: %v", matches)
+	}
+}
@@ -22,17 +22,18 @@ package conflicts
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math"
-	"regexp"
 	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types"
-	containertypes "github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
 	wfs "github.com/john98nf/SequenceClock/watcher/internal/state"
 	wrq "github.com/john98nf/SequenceClock/watcher/pkg/request"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
 const (
@@ -53,6 +54,8 @@ const (
 	RESTART_POLICY_DEFAULT       string = "" // Openwhisk default {"Name": "no",MaximumRetryCount: 0}
 	CPU_PERIOD_OPENWHISK_DEFAULT int64  = 100000
 	CPU_QUOTAS_LOWER_BOUND       int64  = 1000
+	MEMORY_LOWER_BOUND           int64  = 128 * MEGABYTE // Openwhisk minimum action memory
+	MEGABYTE                     int64  = 1 << 20
 	Kp                           int64  = 10
 	Ki                           int64  = 1
 	Kd                           int64  = 0
@@ -60,6 +63,8 @@ const (
 
 var found bool
 
+var ErrRequestNotFound = errors.New("request not found")
+
 type ConflictResolverInterface interface {
 	SearchDockerRuntime(function, podType string) (*types.Container, error)
 	RegistryContains(function string) bool
@@ -70,26 +75,140 @@ type ConflictResolverInterface interface {
 	ExportRegistry() map[string]interface{}
 }
 
+const (
+	DOCKER_RUNTIME string = "docker"
+	CRI_RUNTIME    string = "cri"
+)
+
+/*
+	Watcher settings for the conflict resolver.
+	Runtime selects how action containers are located
+	(docker or cri), ResourceController how their
+	limits are applied (see NewResourceController).
+*/
+type Config struct {
+	Cores              int64
+	Memory             int64 // Bytes available to action containers
+	Policy             string
+	Runtime            string
+	RuntimeEndpoint    string
+	ResourceController string
+	Checkpoint         string // Registry checkpoint file, empty disables persistence
+	ExactLambda        bool   // Recompute λ over the whole registry on every change
+	LambdaCheck        time.Duration
+	Observer           ControllerObserver // Optional, reports resource updates
+	Events             EventListener      // Optional, reports container and registry changes
+}
+
 type ConflictResolver struct {
-	mutex          sync.RWMutex
-	Registry       map[string]*wfs.FunctionState
-	DockerClient   *client.Client
-	Cores          int64
-	lambdaPrevious float64
+	mutex           sync.RWMutex
+	Registry        map[string]*wfs.FunctionState
+	DockerClient    *client.Client
+	Index           ContainerIndexInterface
+	Controller      ResourceController
+	Policy          AllocationPolicy
+	Cores           int64
+	Memory          int64
+	lambdaPrevious  float64
+	desiredSum      int64          // Σ DesiredQuotas of unpinned functions, kept along λ
+	reserved        map[int]string // Pinned core -> function
+	reaped          uint64         // Requests reclaimed on lease expiry
+	ExactLambda     bool
+	checkpointPath  string
+	lambdaResyncs   uint64 // Incremental λ drifts found by CheckLambda
+	events          EventListener
+	cpusets         map[string]string // Cpuset updates queued under the registry lock
+	cpusetMutex     sync.Mutex        // Serializes cpuset flushes
+	checkpoint      []byte            // Latest registry snapshot not yet on disk
+	checkpointDirty chan struct{}     // Wakes checkpoint writer
+	checkpointMutex sync.Mutex        // Guards checkpoint
 }
 
-func NewConflictResolver(cores int64) ConflictResolver {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+/*
+	Creates a new ConflictResolver and starts
+	tracking openwhisk action containers
+	of the configured runtime.
+*/
+func NewConflictResolver(cfg Config) *ConflictResolver {
+	var (
+		cli     *client.Client
+		runtime criapi.RuntimeServiceClient
+		index   ContainerIndexInterface
+		err     error
+	)
+	switch cfg.Runtime {
+	case "", DOCKER_RUNTIME:
+		cli, err = client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			panic(err)
+		}
+		index = NewDockerIndex(cli)
+	case CRI_RUNTIME:
+		runtime, err = NewCRIRuntimeClient(cfg.RuntimeEndpoint)
+		if err != nil {
+			panic(err)
+		}
+		index = NewCRIIndex(runtime)
+	default:
+		panic(fmt.Errorf("unknown container runtime '%v'", cfg.Runtime))
+	}
+	controller, err := NewResourceController(cfg.ResourceController, cli, runtime)
 	if err != nil {
 		panic(err)
 	}
-	registry := make(map[string]*wfs.FunctionState)
-	return ConflictResolver{
-		mutex:        sync.RWMutex{},
-		Registry:     registry,
-		DockerClient: cli,
-		Cores:        cores,
+	policy, err := NewAllocationPolicy(cfg.Policy)
+	if err != nil {
+		panic(err)
+	}
+	cp, err := loadCheckpoint(cfg.Checkpoint)
+	if err != nil {
+		log.Println("Registry checkpoint not restored:", err.Error())
+	}
+	cr := &ConflictResolver{
+		mutex:           sync.RWMutex{},
+		Registry:        cp.Registry,
+		DockerClient:    cli,
+		Index:           index,
+		Controller:      controller,
+		Policy:          policy,
+		Cores:           cfg.Cores,
+		Memory:          cfg.Memory,
+		reserved:        cp.Reserved,
+		reaped:          cp.Reaped,
+		ExactLambda:     cfg.ExactLambda,
+		checkpointPath:  cfg.Checkpoint,
+		checkpointDirty: make(chan struct{}, 1),
+		events:          cfg.Events,
 	}
+	if cfg.Observer != nil {
+		cr.Controller = NewObservedController(controller, cfg.Observer)
+	}
+	go cr.Index.Run(context.Background(), cr.containerChanged)
+	if cr.checkpointPath != "" {
+		go cr.runCheckpointWriter(context.Background())
+	}
+	go func() {
+		// Restored leases are extended before the reaper
+		// starts, so watcher downtime does not expire them
+		cr.reconcile(context.Background())
+		cr.runReaper(context.Background())
+	}()
+	if !cr.ExactLambda {
+		if cfg.LambdaCheck <= 0 {
+			cfg.LambdaCheck = LAMBDA_CHECK_INTERVAL
+		}
+		go cr.runLambdaCheck(context.Background(), cfg.LambdaCheck)
+	}
+	return cr
+}
+
+/*
+	Releases the registry lock and applies
+	cpuset updates queued while holding it.
+*/
+func (cr *ConflictResolver) unlock(ctx context.Context) {
+	cr.mutex.Unlock()
+	cr.flushCpusets(ctx)
 }
 
 /*
@@ -103,27 +222,82 @@ func (cr *ConflictResolver) RegistryContains(function string) bool {
 	return ok
 }
 
+/*
+	Resources applied to a function container
+	once a request is placed.
+*/
+type Grant struct {
+	Node      string `json:"node,omitempty"`
+	Container string `json:"container"`
+	Quotas    int64  `json:"quotas"`    // Effective CPU quotas, -1 when pinned
+	Requested int64  `json:"requested"` // CPU quotas desired by the request
+	Cpuset    string `json:"cpuset,omitempty"`
+	Memory    int64  `json:"memory,omitempty"` // Bytes, 0 when left untouched
+}
+
+func grantOf(state *wfs.FunctionState) *Grant {
+	grant := &Grant{
+		Container: state.Container,
+		Quotas:    state.Quotas,
+		Cpuset:    state.Cpuset,
+		Memory:    state.Memory,
+	}
+	if state.Cpuset != "" {
+		grant.Quotas = -1
+	}
+	return grant
+}
+
 /*
 	Places new request into registry and
 	update container resources.
+	Returns nil grant if function
+	container was not found, and ErrIndexNotSynced
+	if containers are not listed yet. A request already
+	in registry is answered with its grant,
+	leaving registry untouched.
 */
-func (cr *ConflictResolver) UpdateRegistry(req *wrq.Request) (bool, error) {
+func (cr *ConflictResolver) UpdateRegistry(ctx context.Context, req *wrq.Request) (*Grant, error) {
+	if err := awaitSynced(ctx, cr.Index); err != nil {
+		return nil, err
+	}
+	if cnt := cr.Index.Lookup(req.Function, "user-action"); cnt != nil {
+		cr.resolveContainer(cnt.ID)
+	}
 	cr.mutex.Lock()
 	state, ok := cr.Registry[req.Function]
 	if !ok {
 		// TO DO: Solve Openwhisk autoscaling problem
 		container, err := cr.SearchDockerRuntime(req.Function, "user-action")
 		if err != nil {
-			cr.mutex.Unlock()
-			return false, err
+			cr.unlock(ctx)
+			return nil, err
 		} else if container == nil {
-			cr.mutex.Unlock()
-			return false, nil
+			cr.unlock(ctx)
+			return nil, nil
 		}
 		state = wfs.NewFunctionState(container.ID)
 		cr.Registry[req.Function] = state
+	} else if quotas, known := requestedQuotas(state, req.ID); known {
+		// Retried request, e.g. resent over http after the
+		// stream channel broke. Its grant is already in place.
+		grantLease(state, req.ID, leaseTTL(req))
+		grant := grantOf(state)
+		grant.Requested = quotas
+		cr.unlock(ctx)
+		return grant, nil
+	}
+	if req.PinnedCores > 0 {
+		if err := cr.pinCores(ctx, req.Function, state, req.ID, req.PinnedCores); err != nil {
+			if !ok {
+				delete(cr.Registry, req.Function)
+			}
+			cr.unlock(ctx)
+			return nil, err
+		}
 	}
 
+	state.Priority, state.Weight, state.Slack = req.Priority, req.Weight, req.Metrics.Slack
 	quotas := retainCPUThreshold(computePIDControllerOutput(req)+CPU_PERIOD_OPENWHISK_DEFAULT, cr.Cores)
 
 	if quotas > state.DesiredQuotas {
@@ -133,37 +307,71 @@ func (cr *ConflictResolver) UpdateRegistry(req *wrq.Request) (bool, error) {
 		quotas_old := state.DesiredQuotas
 		state.Requests.Current, state.DesiredQuotas = req.ID, quotas
 		state.Quotas = quotas
-		if err := cr.updateContainerCPUQuota(state.Container, quotas); err != nil {
-			log.Println(err.Error())
-		}
-		cr.ReconfigureRegistry(quotas, quotas_old)
+		cr.applyCPUQuota(ctx, state, quotas)
+		cr.ReconfigureRegistry(ctx, quotas, quotas_old)
 	} else {
 		state.Requests.Active[req.ID] = quotas
 	}
-	cr.mutex.Unlock()
-	return true, nil
+	grantLease(state, req.ID, leaseTTL(req))
+	if req.Memory > 0 {
+		cr.requestMemory(ctx, state, req.ID, req.Memory*MEGABYTE)
+	}
+	cr.saveCheckpoint()
+	cr.emit(EVENT_REGISTRY_CHANGED, req.Function, state.Container)
+	grant := grantOf(state)
+	grant.Requested = quotas
+	cr.unlock(ctx)
+	return grant, nil
+}
+
+/*
+	CPU quotas requested by a request
+	of the function, if it is in registry.
+*/
+func requestedQuotas(state *wfs.FunctionState, id uint64) (int64, bool) {
+	if q, ok := state.Requests.Active[id]; ok {
+		return q, true
+	} else if state.Requests.Current == id && state.DesiredQuotas != 0 {
+		return state.DesiredQuotas, true
+	}
+	return 0, false
 }
 
 /*
 	Removes a request from registry
 	and resets function state.
 */
-func (cr *ConflictResolver) RemoveFromRegistry(rs wrq.ResetRequest) error {
+func (cr *ConflictResolver) RemoveFromRegistry(ctx context.Context, rs wrq.ResetRequest) error {
 	cr.mutex.Lock()
+	err := cr.removeRequest(ctx, rs)
+	if err == nil {
+		cr.saveCheckpoint()
+	}
+	cr.unlock(ctx)
+	return err
+}
+
+/*
+	Removes a request from registry.
+	Caller must hold the registry lock.
+*/
+func (cr *ConflictResolver) removeRequest(ctx context.Context, rs wrq.ResetRequest) error {
 	state, ok := cr.Registry[rs.Function]
 	if !ok {
-		cr.mutex.Unlock()
-		return fmt.Errorf("request for '%v' function not found", rs.Function)
+		return fmt.Errorf("%w: no request for '%v' function", ErrRequestNotFound, rs.Function)
 	}
+	delete(state.Requests.Leases, rs.ID)
 	if state.Requests.Current == rs.ID {
+		cr.releaseMemory(ctx, state, rs.ID)
+		cr.releaseCores(ctx, rs.Function, state, rs.ID)
 		if len(state.Requests.Active) == 0 {
 			// TO DO: Solve Openwhisk autoscaling problem
-			if err := cr.updateContainerCPUQuota(state.Container, -1); err != nil {
+			if err := cr.updateContainerCPUQuota(ctx, state.Container, -1); err != nil {
 				log.Println(err.Error())
 			}
 			delete(cr.Registry, rs.Function)
 			if len(cr.Registry) != 0 {
-				cr.ReconfigureRegistry(0, state.DesiredQuotas)
+				cr.ReconfigureRegistry(ctx, 0, state.DesiredQuotas)
 			} else {
 				cr.lambdaPrevious = 0
 			}
@@ -172,22 +380,139 @@ func (cr *ConflictResolver) RemoveFromRegistry(rs wrq.ResetRequest) error {
 			state.Requests.Current, state.DesiredQuotas = nextRequest(state)
 			state.Quotas = state.DesiredQuotas
 			delete(state.Requests.Active, state.Requests.Current)
-			if err := cr.updateContainerCPUQuota(state.Container, state.Quotas); err != nil {
-				log.Println(err.Error())
-			}
-			cr.ReconfigureRegistry(state.DesiredQuotas, quotas_old)
+			cr.applyCPUQuota(ctx, state, state.Quotas)
+			cr.ReconfigureRegistry(ctx, state.DesiredQuotas, quotas_old)
 		}
 	} else {
 		if _, ok := state.Requests.Active[rs.ID]; !ok {
-			cr.mutex.Unlock()
-			return fmt.Errorf("request %v not found", rs.ID)
+			return fmt.Errorf("%w: %v", ErrRequestNotFound, rs.ID)
 		}
 		delete(state.Requests.Active, rs.ID)
+		cr.releaseMemory(ctx, state, rs.ID)
+		cr.releaseCores(ctx, rs.Function, state, rs.ID)
 	}
-	cr.mutex.Unlock()
+	cr.emit(EVENT_REGISTRY_CHANGED, rs.Function, state.Container)
 	return nil
 }
 
+/*
+	Drops registry entry of a function whose
+	container is no longer running.
+*/
+func (cr *ConflictResolver) forgetContainer(function, containerID string) {
+	ctx := context.Background()
+	cr.mutex.Lock()
+	defer cr.flushCpusets(ctx)
+	defer cr.mutex.Unlock()
+	state, ok := cr.Registry[function]
+	if !ok || state.Container != containerID {
+		return
+	}
+	log.Printf("Container %v of function '%v' is gone, dropping its registry entry\n", containerID, function)
+	cr.dropCores(ctx, function, state)
+	delete(cr.Registry, function)
+	if len(cr.Registry) != 0 {
+		cr.ReconfigureRegistry(ctx, 0, state.DesiredQuotas)
+	} else {
+		cr.lambdaPrevious = 0
+	}
+	cr.reconfigureMemory(ctx)
+	cr.saveCheckpoint()
+	cr.emit(EVENT_REGISTRY_CHANGED, function, containerID)
+}
+
+/*
+	Records memory demand of a request.
+	Function desires the largest demand of its
+	active requests. Original container limit is
+	kept for when every demand is released.
+*/
+func (cr *ConflictResolver) requestMemory(ctx context.Context, state *wfs.FunctionState, id uint64, memory int64) {
+	if len(state.Requests.Memory) == 0 {
+		limit, err := cr.Controller.MemoryLimit(ctx, state.Container)
+		if err != nil {
+			log.Println(err.Error())
+		}
+		state.InitialMemory = limit
+	}
+	state.Requests.Memory[id] = memory
+	state.DesiredMemory = maxMemory(state)
+	cr.reconfigureMemory(ctx)
+}
+
+/*
+	Drops memory demand of a request, restoring
+	original container limit after the last one.
+*/
+func (cr *ConflictResolver) releaseMemory(ctx context.Context, state *wfs.FunctionState, id uint64) {
+	if _, ok := state.Requests.Memory[id]; !ok {
+		return
+	}
+	delete(state.Requests.Memory, id)
+	state.DesiredMemory = maxMemory(state)
+	if state.DesiredMemory == 0 && state.Memory != 0 {
+		if state.InitialMemory > 0 {
+			if err := cr.Controller.UpdateMemory(ctx, state.Container, state.InitialMemory); err != nil {
+				log.Println(err.Error())
+			}
+		} else {
+			log.Printf("Unknown initial memory of container %v, keeping %v bytes\n", state.Container, state.Memory)
+		}
+		state.Memory = 0
+	}
+	cr.reconfigureMemory(ctx)
+}
+
+/*
+	Recompute memory limit of each container
+	asking for memory, using μ*DesiredMemory
+	where μ = node memory / sum of DesiredMemory.
+	Node memory 0 disables arbitration.
+*/
+func (cr *ConflictResolver) reconfigureMemory(ctx context.Context) {
+	var sum int64
+	for _, s := range cr.Registry {
+		sum += s.DesiredMemory
+	}
+	if sum == 0 {
+		return
+	}
+	mu := 1.0
+	if cr.Memory > 0 {
+		mu = float64(cr.Memory) / float64(sum)
+	}
+	for _, s := range cr.Registry {
+		if s.DesiredMemory == 0 {
+			continue
+		}
+		memory := s.DesiredMemory
+		if mu < 1.0 {
+			memory = memoryLowerBound(int64(mu * float64(s.DesiredMemory)))
+		}
+		if memory == s.Memory {
+			continue
+		}
+		if err := cr.Controller.UpdateMemory(ctx, s.Container, memory); err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		s.Memory = memory
+	}
+}
+
+/*
+	Largest memory demand among function requests.
+*/
+func maxMemory(state *wfs.FunctionState) int64 {
+	var maxm int64
+	for _, m := range state.Requests.Memory {
+		if m > maxm {
+			maxm = m
+		}
+	}
+	return maxm
+}
+
 /*
 	Finds appropriate function (maximum DesiredCPUQuotas)
 	request to enable.
@@ -209,58 +534,166 @@ func nextRequest(state *wfs.FunctionState) (uint64, int64) {
 /*
 	Recompute CPU quotas for each container,
 	using the formula λ*DesiredCPUQuotas
-	where λ = CPU_Cores / sum of DesiredCPUQuotas.
+	where λ = shared CPU_Cores / sum of DesiredCPUQuotas.
+	Pinned containers run on their own cores, so they
+	are left out of both the sum and the shared cores.
+	The sum is updated incrementally, unless ExactLambda
+	is set or cores are pinned. Policies other than proportional
+	scaling are recomputed over the whole registry.
 */
-func (cr *ConflictResolver) ReconfigureRegistry(quotas_new int64, quotas_old int64) {
-	var (
-		sum    int64
-		lambda float64
-	)
-	if cr.lambdaPrevious == 0 {
+func (cr *ConflictResolver) ReconfigureRegistry(ctx context.Context, quotas_new int64, quotas_old int64) {
+	var lambda float64
+	if _, ok := cr.Policy.(*ProportionalPolicy); !ok || cr.mixedClasses() {
+		cr.reallocate(ctx)
+		return
+	}
+	if cr.lambdaPrevious == 0 || cr.ExactLambda || len(cr.reserved) != 0 {
 		for _, s := range cr.Registry {
-			sum += s.DesiredQuotas
+			s.Preempted = false
 		}
-		lambda = float64(cr.Cores*CPU_PERIOD_OPENWHISK_DEFAULT) / float64(sum)
+		cr.desiredSum = cr.unpinnedDesired()
 	} else {
-		nt := float64(cr.Cores * CPU_PERIOD_OPENWHISK_DEFAULT)
-		lambda = nt / (nt/cr.lambdaPrevious + float64(quotas_new-quotas_old))
+		// Sum is kept in integer quotas, so λ carries
+		// no rounding from one update to the next
+		cr.desiredSum += quotas_new - quotas_old
+	}
+	if cr.desiredSum > 0 {
+		lambda = float64(cr.sharedCapacity()) / float64(cr.desiredSum)
 	}
 
 	if !((cr.lambdaPrevious >= 1) && (lambda >= 1)) {
-		for f, s := range cr.Registry {
+		for _, s := range cr.Registry {
+			if s.Cpuset != "" {
+				continue
+			}
 			if lambda < 1.0 {
 				s.Quotas = lowerBound(int64(lambda * float64(s.DesiredQuotas)))
 			} else {
 				s.Quotas = s.DesiredQuotas
 			}
-			if err := cr.updateContainerCPUQuota(cr.Registry[f].Container, s.Quotas); err != nil {
-				log.Println(err.Error())
-			}
+			cr.applyCPUQuota(ctx, s, s.Quotas)
 		}
 	}
 	cr.lambdaPrevious = lambda
 }
 
+/*
+	Splits shared CPU quotas among unpinned
+	registry functions using the allocation policy.
+	When more than one priority class is present,
+	classes are served highest first and
+	lower ones are preempted down to
+	CPU_QUOTAS_LOWER_BOUND.
+*/
+func (cr *ConflictResolver) reallocate(ctx context.Context) {
+	functions := make([]string, 0, len(cr.Registry))
+	demands := make([]Demand, 0, len(cr.Registry))
+	for f, s := range cr.Registry {
+		if s.Cpuset != "" {
+			continue
+		}
+		functions = append(functions, f)
+		demands = append(demands, Demand{
+			Function: f,
+			Desired:  s.DesiredQuotas,
+			Priority: s.Priority,
+			Weight:   s.Weight,
+			Slack:    s.Slack,
+		})
+	}
+	capacity := cr.sharedCapacity()
+	var (
+		quotas    []int64
+		preempted []bool
+	)
+	if cr.mixedClasses() {
+		quotas, preempted = tiered(cr.Policy, capacity, CPU_QUOTAS_LOWER_BOUND, demands)
+	} else {
+		quotas, preempted = cr.Policy.Allocate(capacity, demands), make([]bool, len(demands))
+	}
+	// Proportional policy recomputes λ from scratch next time
+	cr.lambdaPrevious = 0
+	for i, f := range functions {
+		s := cr.Registry[f]
+		if preempted[i] && !s.Preempted {
+			s.Preemptions++
+			log.Printf("Function %v preempted by a higher priority class\n", f)
+		}
+		s.Preempted = preempted[i]
+		q := lowerBound(quotas[i])
+		if q == s.Quotas {
+			continue
+		}
+		s.Quotas = q
+		cr.applyCPUQuota(ctx, s, q)
+	}
+}
+
+/*
+	Reports whether registry functions
+	belong to more than one priority class.
+*/
+func (cr *ConflictResolver) mixedClasses() bool {
+	first := true
+	var p int64
+	for _, s := range cr.Registry {
+		if first {
+			p, first = s.Priority, false
+		} else if s.Priority != p {
+			return true
+		}
+	}
+	return false
+}
+
 /*
 	Helper method for searching docker runtime
 	for an openwhisk action container.
+	Served from the container index,
+	without contacting docker daemon.
 */
 func (cr *ConflictResolver) SearchDockerRuntime(function, podType string) (*types.Container, error) {
-	containers, err := cr.DockerClient.ContainerList(context.Background(), types.ContainerListOptions{})
-	if err != nil {
-		return nil, err
-	}
-	exp := fmt.Sprintf(REG_EXP, function)
-	for _, cnt := range containers {
-		if l, ok := cnt.Labels["io.kubernetes.container.name"]; ok && l == podType {
-			if matched, err := regexp.MatchString(exp, cnt.Labels["io.kubernetes.pod.name"]); matched {
-				return &cnt, nil
-			} else if err != nil {
-				return nil, err
-			}
-		}
+	return cr.Index.Lookup(function, podType), nil
+}
+
+/*
+	Allocation state of a registry function.
+*/
+type Usage struct {
+	Function      string
+	Quotas        int64
+	DesiredQuotas int64
+	Requests      int
+	Preempted     bool
+}
+
+/*
+	Allocation state of every registry function.
+*/
+func (cr *ConflictResolver) Usage() []Usage {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+	res := make([]Usage, 0, len(cr.Registry))
+	for f, s := range cr.Registry {
+		res = append(res, Usage{
+			Function:      f,
+			Quotas:        s.Quotas,
+			DesiredQuotas: s.DesiredQuotas,
+			Requests:      len(s.Requests.Leases),
+			Preempted:     s.Preempted,
+		})
 	}
-	return nil, nil
+	return res
+}
+
+/*
+	Current λ = CPU_Cores / sum of DesiredCPUQuotas,
+	0 for an empty registry.
+*/
+func (cr *ConflictResolver) Lambda() float64 {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+	return cr.exactLambda()
 }
 
 /*
@@ -278,35 +711,24 @@ func (cr *ConflictResolver) ExportRegistry() map[string]interface{} {
 }
 
 /*
-	Helper method for updating CPU quotas
-	of specified docker container.
+	Lets the resource controller locate a
+	container ahead of its updates.
+	Must be called without the registry lock.
 */
-func (cr *ConflictResolver) updateContainerCPUQuota(containerID string, cpuQuota int64) error {
-	updateConfig := containertypes.UpdateConfig{
-		Resources: containertypes.Resources{
-			BlkioWeight:        BLKIO_WEIGHT_DEFAULT,
-			CpusetCpus:         CPUSET_CPUS_DEFAULT,
-			CpusetMems:         CPUSET_MEMS_DEFAULT,
-			CPUShares:          CPU_SHARES_DEFAULT,
-			Memory:             MEMORY_DEFAULT,
-			MemoryReservation:  MEMORY_RESERVATION_DEFAULT,
-			MemorySwap:         MEMORY_SWAP_DEFAULT,
-			KernelMemory:       KERNEL_MEMORY_DEFAULT,
-			CPUPeriod:          CPU_PERIOD_DEFAULT,
-			CPUQuota:           cpuQuota,
-			CPURealtimePeriod:  CPU_REALTIME_PERIOD_DEFAULT,
-			CPURealtimeRuntime: CPU_REALTIME_RUNTIME_DEFAULT,
-			NanoCPUs:           NANO_CPUS_DEFAULT,
-		},
-		RestartPolicy: containertypes.RestartPolicy{
-			Name:              "no",
-			MaximumRetryCount: 0,
-		},
-	}
-	if _, err := cr.DockerClient.ContainerUpdate(context.Background(), containerID, updateConfig); err != nil {
-		return err
+func (cr *ConflictResolver) resolveContainer(containerID string) {
+	if r, ok := cr.Controller.(ContainerResolver); ok {
+		if err := r.Resolve(containerID); err != nil {
+			log.Println(err.Error())
+		}
 	}
-	return nil
+}
+
+/*
+	Helper method for updating CPU quotas
+	of specified container.
+*/
+func (cr *ConflictResolver) updateContainerCPUQuota(ctx context.Context, containerID string, cpuQuota int64) error {
+	return cr.Controller.UpdateCPUQuota(ctx, containerID, cpuQuota)
 }
 
 /*
@@ -341,6 +763,18 @@ func mseconds(x int64) int64 {
 	return int64(math.Round(float64(x) * 0.000001))
 }
 
+/*
+	Apply lower bound to μ correction.
+	Used by reconfigureMemory
+*/
+func memoryLowerBound(m int64) int64 {
+	if m <= MEMORY_LOWER_BOUND {
+		return MEMORY_LOWER_BOUND
+	} else {
+		return m
+	}
+}
+
 /*
 	Apply lower bound to lambda correction.
 	Used by ReconfigureRegistry
@@ -0,0 +1,422 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package conflicts
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	wfs "github.com/john98nf/SequenceClock/watcher/internal/state"
+	wrq "github.com/john98nf/SequenceClock/watcher/pkg/request"
+)
+
+/*
+	Resource controller keeping the last
+	update of every container in memory.
+*/
+type fakeController struct {
+	mutex   sync.Mutex
+	quotas  map[string]int64
+	memory  map[string]int64
+	cpusets map[string]string
+	updates int
+}
+
+func newFakeController() *fakeController {
+	return &fakeController{
+		quotas:  map[string]int64{},
+		memory:  map[string]int64{},
+		cpusets: map[string]string{},
+	}
+}
+
+func (fc *fakeController) UpdateCPUQuota(ctx context.Context, containerID string, cpuQuota int64) error {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	fc.quotas[containerID] = cpuQuota
+	fc.updates++
+	return nil
+}
+
+func (fc *fakeController) UpdateMemory(ctx context.Context, containerID string, memory int64) error {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	fc.memory[containerID] = memory
+	return nil
+}
+
+func (fc *fakeController) UpdateCpuset(ctx context.Context, containerID string, cpus string) error {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	fc.cpusets[containerID] = cpus
+	return nil
+}
+
+func (fc *fakeController) MemoryLimit(ctx context.Context, containerID string) (int64, error) {
+	return 256 * MEGABYTE, nil
+}
+
+func (fc *fakeController) quota(containerID string) int64 {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return fc.quotas[containerID]
+}
+
+/*
+	Memory limit applied to a container,
+	and whether it was ever updated.
+*/
+func (fc *fakeController) memoryOf(containerID string) (int64, bool) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	m, ok := fc.memory[containerID]
+	return m, ok
+}
+
+func (fc *fakeController) cpuset(containerID string) string {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return fc.cpusets[containerID]
+}
+
+/*
+	Container index filled by the test.
+*/
+type staticIndex struct {
+	*ContainerIndex
+}
+
+func (idx staticIndex) Run(ctx context.Context, changed ContainerChange) {}
+
+/*
+	Resolver over a node with the given cores,
+	running one action container per function,
+	named after it, with its index synced.
+*/
+func newTestResolver(cores int64, policy AllocationPolicy, functions ...string) (*ConflictResolver, *fakeController) {
+	idx := staticIndex{NewContainerIndex()}
+	containers := []types.Container{}
+	for i, f := range functions {
+		containers = append(containers, *actionContainer(f, f, int64(i)))
+	}
+	idx.replace(containers, func(string, string, bool) {})
+	ctl := newFakeController()
+	return &ConflictResolver{
+		Registry:   map[string]*wfs.FunctionState{},
+		Index:      idx,
+		Controller: ctl,
+		Policy:     policy,
+		Cores:      cores,
+		reserved:   map[int]string{},
+	}, ctl
+}
+
+/*
+	Request whose PID output asks for
+	quotas CPU quotas.
+*/
+func requestFor(id uint64, function string, quotas int64) *wrq.Request {
+	return &wrq.Request{
+		ID:       id,
+		Function: function,
+		Metrics: &wrq.Metrics{
+			Slack: (CPU_PERIOD_OPENWHISK_DEFAULT - quotas) * 1000000 / Kp,
+		},
+	}
+}
+
+func mustUpdate(t *testing.T, cr *ConflictResolver, req *wrq.Request) *Grant {
+	t.Helper()
+	grant, err := cr.UpdateRegistry(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grant == nil {
+		t.Fatalf("no container for %v", req.Function)
+	}
+	return grant
+}
+
+func mustReset(t *testing.T, cr *ConflictResolver, id uint64, function string) {
+	t.Helper()
+	if err := cr.RemoveFromRegistry(context.Background(), *wrq.NewResetRequest(id, function)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/*
+	Sum of quotas applied to unpinned
+	registry containers.
+*/
+func sharedQuotas(cr *ConflictResolver, ctl *fakeController) int64 {
+	var sum int64
+	for _, s := range cr.Registry {
+		if s.Cpuset == "" {
+			sum += ctl.quota(s.Container)
+		}
+	}
+	return sum
+}
+
+func TestPinnedCoresLeaveSharedPool(t *testing.T) {
+	cr, ctl := newTestResolver(4, &ProportionalPolicy{}, "pinned", "a", "b")
+	pin := requestFor(1, "pinned", 200000)
+	pin.PinnedCores = 2
+	mustUpdate(t, cr, pin)
+	if got := ctl.cpuset("pinned"); got != "2,3" {
+		t.Fatalf("pinned container on cores %q, want 2,3", got)
+	}
+	if got := ctl.quota("pinned"); got != -1 {
+		t.Fatalf("pinned container throttled to %v", got)
+	}
+
+	mustUpdate(t, cr, requestFor(2, "a", 200000))
+	mustUpdate(t, cr, requestFor(3, "b", 200000))
+	shared := 2 * CPU_PERIOD_OPENWHISK_DEFAULT
+	if sum := sharedQuotas(cr, ctl); sum > shared {
+		t.Fatalf("shared containers granted %v quotas, only %v left by pinning", sum, shared)
+	}
+	for _, f := range []string{"a", "b"} {
+		if got := ctl.cpuset(f); got != "0,1" {
+			t.Fatalf("container %v on cores %q, want shared 0,1", f, got)
+		}
+	}
+
+	mustReset(t, cr, 1, "pinned")
+	if len(cr.reserved) != 0 {
+		t.Fatalf("cores %v still reserved", cr.reserved)
+	}
+	for _, f := range []string{"a", "b"} {
+		if got := ctl.quota(f); got != 200000 {
+			t.Fatalf("container %v kept %v quotas once cores were released, want 200000", f, got)
+		}
+		if got := ctl.cpuset(f); got != "0,1,2,3" {
+			t.Fatalf("container %v on cores %q after release", f, got)
+		}
+	}
+}
+
+func TestPinnedCoresWithPolicy(t *testing.T) {
+	for _, policy := range []AllocationPolicy{&MaxMinPolicy{}, &PriorityPolicy{}} {
+		t.Run(policy.Name(), func(t *testing.T) {
+			cr, ctl := newTestResolver(4, policy, "pinned", "a", "b")
+			pin := requestFor(1, "pinned", 100000)
+			pin.PinnedCores = 3
+			mustUpdate(t, cr, pin)
+			mustUpdate(t, cr, requestFor(2, "a", 100000))
+			mustUpdate(t, cr, requestFor(3, "b", 100000))
+			// A starved container is still held at the lower bound
+			if sum := sharedQuotas(cr, ctl); sum > CPU_PERIOD_OPENWHISK_DEFAULT+CPU_QUOTAS_LOWER_BOUND {
+				t.Fatalf("%v granted %v quotas on one shared core", policy.Name(), sum)
+			}
+		})
+	}
+}
+
+func TestPinningRefusesLastSharedCore(t *testing.T) {
+	cr, _ := newTestResolver(2, &ProportionalPolicy{}, "a")
+	req := requestFor(1, "a", 100000)
+	req.PinnedCores = 2
+	if _, err := cr.UpdateRegistry(context.Background(), req); err == nil {
+		t.Fatalf("pinned every core, reserved %v", cr.reserved)
+	}
+}
+
+type testCtxKey struct{}
+
+func TestObservedControllerGetsCallerContext(t *testing.T) {
+	cr, ctl := newTestResolver(4, &ProportionalPolicy{}, "a")
+	var (
+		mutex      sync.Mutex
+		operations []string
+	)
+	cr.Controller = NewObservedController(ctl, func(ctx context.Context, operation, containerID string, start time.Time, err error) {
+		if ctx.Value(testCtxKey{}) != "caller" {
+			t.Errorf("%v update of %v observed without the caller context", operation, containerID)
+		}
+		mutex.Lock()
+		operations = append(operations, operation)
+		mutex.Unlock()
+	})
+	ctx := context.WithValue(context.Background(), testCtxKey{}, "caller")
+	req := requestFor(1, "a", 100000)
+	req.PinnedCores = 1
+	if _, err := cr.UpdateRegistry(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := cr.RemoveFromRegistry(ctx, *wrq.NewResetRequest(1, "a")); err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(operations) == 0 {
+		t.Fatal("no update observed")
+	}
+}
+
+func TestRetriedRequestIsIdempotent(t *testing.T) {
+	cr, ctl := newTestResolver(2, &ProportionalPolicy{}, "a")
+	first := mustUpdate(t, cr, requestFor(1, "a", 50000))
+	mustUpdate(t, cr, requestFor(2, "a", 30000))
+	for _, id := range []uint64{1, 2} {
+		quotas := int64(50000)
+		if id == 2 {
+			quotas = 30000
+		}
+		retry := requestFor(id, "a", quotas)
+		retry.Memory = 64
+		grant := mustUpdate(t, cr, retry)
+		if grant.Requested != quotas || grant.Quotas != first.Quotas {
+			t.Fatalf("retry of request %v granted %+v", id, grant)
+		}
+	}
+	s := cr.Registry["a"]
+	if len(s.Requests.Active) != 1 || s.Requests.Current != 1 || len(s.Requests.Memory) != 0 {
+		t.Fatalf("retries changed requests to %+v", s.Requests)
+	}
+
+	mustReset(t, cr, 1, "a")
+	mustReset(t, cr, 2, "a")
+	if len(cr.Registry) != 0 {
+		t.Fatalf("registry left with %+v", cr.Registry["a"].Requests)
+	}
+	if got := ctl.quota("a"); got != -1 {
+		t.Fatalf("container left throttled to %v", got)
+	}
+	err := cr.RemoveFromRegistry(context.Background(), *wrq.NewResetRequest(2, "a"))
+	if !errors.Is(err, ErrRequestNotFound) {
+		t.Fatalf("repeated reset returned %v", err)
+	}
+}
+
+/*
+	Request of requestFor asking for
+	memory MB of memory.
+*/
+func memoryRequest(id uint64, function string, quotas, memory int64) *wrq.Request {
+	req := requestFor(id, function, quotas)
+	req.Memory = memory
+	return req
+}
+
+func TestMemoryArbitration(t *testing.T) {
+	tests := []struct {
+		name     string
+		node     int64 // Node memory in MB, 0 disables arbitration
+		requests []*wrq.Request
+		want     map[string]int64 // Applied limit in MB, 0 when untouched
+	}{
+		{
+			name:     "no demand leaves containers untouched",
+			node:     1024,
+			requests: []*wrq.Request{memoryRequest(1, "a", 50000, 0), memoryRequest(2, "b", 50000, 256)},
+			want:     map[string]int64{"a": 0, "b": 256},
+		},
+		{
+			name:     "under-subscribed node grants every demand",
+			node:     1024,
+			requests: []*wrq.Request{memoryRequest(1, "a", 50000, 256), memoryRequest(2, "b", 50000, 512)},
+			want:     map[string]int64{"a": 256, "b": 512},
+		},
+		{
+			name:     "over-subscribed node scales demands by mu",
+			node:     512,
+			requests: []*wrq.Request{memoryRequest(1, "a", 50000, 512), memoryRequest(2, "b", 50000, 512)},
+			want:     map[string]int64{"a": 256, "b": 256},
+		},
+		{
+			name:     "scaled limits keep the lower bound",
+			node:     300,
+			requests: []*wrq.Request{memoryRequest(1, "a", 50000, 1000), memoryRequest(2, "b", 50000, 200)},
+			want:     map[string]int64{"a": 250, "b": MEMORY_LOWER_BOUND / MEGABYTE},
+		},
+		{
+			name:     "zero node memory disables arbitration",
+			node:     0,
+			requests: []*wrq.Request{memoryRequest(1, "a", 50000, 2048), memoryRequest(2, "b", 50000, 2048)},
+			want:     map[string]int64{"a": 2048, "b": 2048},
+		},
+		{
+			name:     "function gets its largest demand",
+			node:     1024,
+			requests: []*wrq.Request{memoryRequest(1, "a", 50000, 384), memoryRequest(2, "a", 40000, 512)},
+			want:     map[string]int64{"a": 512},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr, ctl := newTestResolver(2, &ProportionalPolicy{}, "a", "b")
+			cr.Memory = tt.node * MEGABYTE
+			for _, req := range tt.requests {
+				mustUpdate(t, cr, req)
+			}
+			for f, want := range tt.want {
+				got, updated := ctl.memoryOf(f)
+				if want == 0 && updated {
+					t.Errorf("%v: memory set to %v, want untouched", f, got)
+				} else if want != 0 && got != want*MEGABYTE {
+					t.Errorf("%v: memory %v MB, want %v MB", f, got/MEGABYTE, want)
+				}
+				if cr.Registry[f].Memory != want*MEGABYTE {
+					t.Errorf("%v: registry memory %v, want %v MB", f, cr.Registry[f].Memory, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryReleasedOnReset(t *testing.T) {
+	cr, ctl := newTestResolver(2, &ProportionalPolicy{}, "a", "b")
+	cr.Memory = 1024 * MEGABYTE
+	expect := func(function string, mb int64) {
+		t.Helper()
+		if got, _ := ctl.memoryOf(function); got != mb*MEGABYTE {
+			t.Fatalf("%v: memory %v MB, want %v MB", function, got/MEGABYTE, mb)
+		}
+	}
+
+	mustUpdate(t, cr, memoryRequest(1, "a", 50000, 384))
+	mustUpdate(t, cr, memoryRequest(2, "a", 40000, 768))
+	mustUpdate(t, cr, memoryRequest(3, "b", 50000, 1280))
+	// μ = 1024 / (768 + 1280)
+	expect("a", 384)
+	expect("b", 640)
+
+	// Other functions grow back when demand leaves
+	mustReset(t, cr, 3, "b")
+	expect("a", 768)
+	expect("b", 256) // Initial limit of fakeController
+
+	// Largest remaining demand of the function
+	mustReset(t, cr, 2, "a")
+	expect("a", 384)
+
+	// Initial limit after the last demand
+	mustReset(t, cr, 1, "a")
+	expect("a", 256)
+	if len(cr.Registry) != 0 {
+		t.Fatalf("registry %v, want empty", cr.Registry)
+	}
+}
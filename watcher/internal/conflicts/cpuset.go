@@ -0,0 +1,259 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package conflicts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	wfs "github.com/john98nf/SequenceClock/watcher/internal/state"
+)
+
+const (
+	MIN_SHARED_CORES int64 = 1 // Cores never handed out for pinning
+)
+
+var ErrCoresUnavailable = errors.New("not enough free cores to pin")
+
+/*
+	Reserves dedicated cores for a request.
+	Function is pinned to as many cores as the largest
+	demand of its active requests. Fails without side
+	effects if free cores would drop below MIN_SHARED_CORES.
+*/
+func (cr *ConflictResolver) pinCores(ctx context.Context, function string, state *wfs.FunctionState, id uint64, n int64) error {
+	want := n
+	if m := maxPinned(state); m > want {
+		want = m
+	}
+	have := cr.coresOf(function)
+	if need := int(want) - len(have); need > 0 {
+		free := cr.freeCores()
+		if int64(len(free)-need) < MIN_SHARED_CORES {
+			return fmt.Errorf("%w: %v requested, %v free", ErrCoresUnavailable, need, int64(len(free))-MIN_SHARED_CORES)
+		}
+		// Hand out highest cores first, keeping core 0 shared.
+		for _, c := range free[len(free)-need:] {
+			cr.reserved[c] = function
+		}
+	}
+	state.Requests.Pinned[id] = n
+	cr.applyCpuset(ctx, function, state)
+	return nil
+}
+
+/*
+	Drops core demand of a request, returning cores
+	that the function no longer needs to the shared pool.
+*/
+func (cr *ConflictResolver) releaseCores(ctx context.Context, function string, state *wfs.FunctionState, id uint64) {
+	if _, ok := state.Requests.Pinned[id]; !ok {
+		return
+	}
+	delete(state.Requests.Pinned, id)
+	want := int(maxPinned(state))
+	have := cr.coresOf(function)
+	if want >= len(have) {
+		return
+	}
+	for _, c := range have[:len(have)-want] {
+		delete(cr.reserved, c)
+	}
+	cr.applyCpuset(ctx, function, state)
+}
+
+/*
+	Returns every core of a function back to the shared
+	pool. Used when its container is gone.
+*/
+func (cr *ConflictResolver) dropCores(ctx context.Context, function string, state *wfs.FunctionState) {
+	if len(state.Requests.Pinned) == 0 {
+		return
+	}
+	state.Requests.Pinned = map[uint64]int64{}
+	for _, c := range cr.coresOf(function) {
+		delete(cr.reserved, c)
+	}
+	state.Cpuset = ""
+	cr.updateSharedCpuset()
+	// Shared cores grew, λ is recomputed
+	cr.lambdaPrevious = 0
+}
+
+/*
+	Applies reserved cores to function container and
+	shrinks every other action container to the shared
+	cores. Pinned containers run without CFS quota,
+	quotas of the others follow the shared cores.
+*/
+func (cr *ConflictResolver) applyCpuset(ctx context.Context, function string, state *wfs.FunctionState) {
+	cores := cr.coresOf(function)
+	cpuset := formatCpuset(cores)
+	if cpuset == state.Cpuset {
+		return
+	}
+	if len(cores) == 0 {
+		cpuset = formatCpuset(cr.allCores())
+	}
+	cr.queueCpuset(state.Container, cpuset)
+	if len(cores) == 0 {
+		state.Cpuset = ""
+	} else {
+		state.Cpuset = cpuset
+	}
+	cr.applyCPUQuota(ctx, state, state.Quotas)
+	cr.updateSharedCpuset()
+	cr.lambdaPrevious = 0
+	if len(cr.Registry) != 0 {
+		cr.ReconfigureRegistry(ctx, 0, 0)
+	}
+}
+
+/*
+	Restricts every unpinned action container
+	of the node to cores not reserved for pinning.
+	Updates are applied once the registry lock
+	is released.
+*/
+func (cr *ConflictResolver) updateSharedCpuset() {
+	pinned := make(map[string]bool)
+	for _, s := range cr.Registry {
+		if s.Cpuset != "" {
+			pinned[s.Container] = true
+		}
+	}
+	shared := formatCpuset(cr.freeCores())
+	for _, cnt := range cr.Index.Containers("user-action") {
+		if pinned[cnt.ID] {
+			continue
+		}
+		cr.queueCpuset(cnt.ID, shared)
+	}
+}
+
+/*
+	Records the cpuset of a container, replacing
+	any update of it still queued.
+	Caller must hold the registry lock.
+*/
+func (cr *ConflictResolver) queueCpuset(containerID, cpus string) {
+	if cr.cpusets == nil {
+		cr.cpusets = make(map[string]string)
+	}
+	cr.cpusets[containerID] = cpus
+}
+
+/*
+	Applies queued cpuset updates. Must be called
+	without the registry lock; flushes run one at
+	a time, so the latest queued cpuset of a
+	container is the one applied last.
+*/
+func (cr *ConflictResolver) flushCpusets(ctx context.Context) {
+	cr.cpusetMutex.Lock()
+	defer cr.cpusetMutex.Unlock()
+	cr.mutex.Lock()
+	pending := cr.cpusets
+	cr.cpusets = nil
+	cr.mutex.Unlock()
+	for id, cpus := range pending {
+		if err := cr.Controller.UpdateCpuset(ctx, id, cpus); err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+/*
+	Updates CPU quotas of a function container.
+	Pinned containers are not throttled.
+*/
+func (cr *ConflictResolver) applyCPUQuota(ctx context.Context, state *wfs.FunctionState, cpuQuota int64) {
+	if state.Cpuset != "" {
+		cpuQuota = -1
+	}
+	if err := cr.updateContainerCPUQuota(ctx, state.Container, cpuQuota); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+/*
+	Cores reserved for a function, ascending.
+*/
+func (cr *ConflictResolver) coresOf(function string) []int {
+	res := []int{}
+	for c, f := range cr.reserved {
+		if f == function {
+			res = append(res, c)
+		}
+	}
+	sort.Ints(res)
+	return res
+}
+
+/*
+	Cores not reserved for pinning, ascending.
+*/
+func (cr *ConflictResolver) freeCores() []int {
+	res := []int{}
+	for c := 0; c < int(cr.Cores); c++ {
+		if _, ok := cr.reserved[c]; !ok {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func (cr *ConflictResolver) allCores() []int {
+	res := make([]int, cr.Cores)
+	for c := range res {
+		res[c] = c
+	}
+	return res
+}
+
+/*
+	Largest core demand among function requests.
+*/
+func maxPinned(state *wfs.FunctionState) int64 {
+	var maxn int64
+	for _, n := range state.Requests.Pinned {
+		if n > maxn {
+			maxn = n
+		}
+	}
+	return maxn
+}
+
+/*
+	Formats cores as a cpuset list, e.g. "2,3".
+*/
+func formatCpuset(cores []int) string {
+	s := make([]string, len(cores))
+	for i, c := range cores {
+		s[i] = strconv.Itoa(c)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,253 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package conflicts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"google.golang.org/grpc"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+const (
+	CRI_ENDPOINT_DEFAULT string        = "unix:///run/containerd/containerd.sock"
+	CRI_POLL_INTERVAL    time.Duration = time.Second
+	CRI_TIMEOUT          time.Duration = 2 * time.Second
+)
+
+/*
+	Connects to the CRI runtime service
+	listening on endpoint.
+*/
+func NewCRIRuntimeClient(endpoint string) (criapi.RuntimeServiceClient, error) {
+	if endpoint == "" {
+		endpoint = CRI_ENDPOINT_DEFAULT
+	}
+	conn, err := grpc.Dial(endpoint, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	return criapi.NewRuntimeServiceClient(conn), nil
+}
+
+/*
+	Container index fed by polling a CRI runtime.
+	Pod sandboxes are indexed under the POD type,
+	like dockershim pause containers.
+*/
+type CRIIndex struct {
+	*ContainerIndex
+	runtime criapi.RuntimeServiceClient
+}
+
+func NewCRIIndex(runtime criapi.RuntimeServiceClient) *CRIIndex {
+	return &CRIIndex{
+		ContainerIndex: NewContainerIndex(),
+		runtime:        runtime,
+	}
+}
+
+/*
+	Rebuilds the index every CRI_POLL_INTERVAL
+	until ctx is cancelled. changed is called for each
+	user-action container that appears or disappears.
+*/
+func (idx *CRIIndex) Run(ctx context.Context, changed ContainerChange) {
+	for {
+		if containers, err := idx.list(ctx); err != nil {
+			log.Println("Container index sync failed:", err.Error())
+		} else {
+			idx.replace(containers, changed)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(CRI_POLL_INTERVAL):
+		}
+	}
+}
+
+/*
+	Lists running containers and ready pod sandboxes
+	of CRI runtime as docker containers.
+*/
+func (idx *CRIIndex) list(ctx context.Context) ([]types.Container, error) {
+	ctx, cancel := context.WithTimeout(ctx, CRI_TIMEOUT)
+	defer cancel()
+	cnts, err := idx.runtime.ListContainers(ctx, &criapi.ListContainersRequest{
+		Filter: &criapi.ContainerFilter{
+			State: &criapi.ContainerStateValue{State: criapi.ContainerState_CONTAINER_RUNNING},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	sbs, err := idx.runtime.ListPodSandbox(ctx, &criapi.ListPodSandboxRequest{
+		Filter: &criapi.PodSandboxFilter{
+			State: &criapi.PodSandboxStateValue{State: criapi.PodSandboxState_SANDBOX_READY},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := make([]types.Container, 0, len(cnts.Containers)+len(sbs.Items))
+	for _, c := range cnts.Containers {
+		cnt := types.Container{
+			ID:      c.Id,
+			ImageID: c.ImageRef,
+			Created: c.CreatedAt / int64(time.Second),
+			Labels:  c.Labels,
+			State:   "running",
+		}
+		if c.Metadata != nil {
+			cnt.Names = []string{"/" + c.Metadata.Name}
+		}
+		if c.Image != nil {
+			cnt.Image = c.Image.Image
+		}
+		res = append(res, cnt)
+	}
+	for _, s := range sbs.Items {
+		labels := make(map[string]string, len(s.Labels)+1)
+		for k, v := range s.Labels {
+			labels[k] = v
+		}
+		labels[CONTAINER_NAME_LABEL] = "POD"
+		cnt := types.Container{
+			ID:      s.Id,
+			Created: s.CreatedAt / int64(time.Second),
+			Labels:  labels,
+			State:   "running",
+		}
+		if s.Metadata != nil {
+			cnt.Names = []string{"/" + s.Metadata.Name}
+		}
+		res = append(res, cnt)
+	}
+	return res, nil
+}
+
+/*
+	Resource controller that goes through
+	CRI UpdateContainerResources.
+*/
+type CRIController struct {
+	runtime criapi.RuntimeServiceClient
+}
+
+func NewCRIController(runtime criapi.RuntimeServiceClient) *CRIController {
+	return &CRIController{
+		runtime: runtime,
+	}
+}
+
+/*
+	Updates CPU quotas of specified container.
+	Zero valued fields are left untouched by the runtime.
+*/
+func (cc *CRIController) UpdateCPUQuota(ctx context.Context, containerID string, cpuQuota int64) error {
+	ctx, cancel := context.WithTimeout(ctx, CRI_TIMEOUT)
+	defer cancel()
+	_, err := cc.runtime.UpdateContainerResources(ctx, &criapi.UpdateContainerResourcesRequest{
+		ContainerId: containerID,
+		Linux: &criapi.LinuxContainerResources{
+			CpuPeriod: CPU_PERIOD_OPENWHISK_DEFAULT,
+			CpuQuota:  cpuQuota,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("cri update of container %v: %v", containerID, err)
+	}
+	return nil
+}
+
+/*
+	Updates memory limit (in bytes) of specified container.
+*/
+func (cc *CRIController) UpdateMemory(ctx context.Context, containerID string, memory int64) error {
+	ctx, cancel := context.WithTimeout(ctx, CRI_TIMEOUT)
+	defer cancel()
+	_, err := cc.runtime.UpdateContainerResources(ctx, &criapi.UpdateContainerResourcesRequest{
+		ContainerId: containerID,
+		Linux: &criapi.LinuxContainerResources{
+			MemoryLimitInBytes: memory,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("cri update of container %v: %v", containerID, err)
+	}
+	return nil
+}
+
+/*
+	Restricts specified container to a cpuset list.
+*/
+func (cc *CRIController) UpdateCpuset(ctx context.Context, containerID string, cpus string) error {
+	ctx, cancel := context.WithTimeout(ctx, CRI_TIMEOUT)
+	defer cancel()
+	_, err := cc.runtime.UpdateContainerResources(ctx, &criapi.UpdateContainerResourcesRequest{
+		ContainerId: containerID,
+		Linux: &criapi.LinuxContainerResources{
+			CpusetCpus: cpus,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("cri update of container %v: %v", containerID, err)
+	}
+	return nil
+}
+
+/*
+	Returns memory limit (in bytes) of specified container,
+	read from the runtime spec in verbose container status.
+	0 if unlimited.
+*/
+func (cc *CRIController) MemoryLimit(ctx context.Context, containerID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, CRI_TIMEOUT)
+	defer cancel()
+	resp, err := cc.runtime.ContainerStatus(ctx, &criapi.ContainerStatusRequest{
+		ContainerId: containerID,
+		Verbose:     true,
+	})
+	if err != nil {
+		return 0, err
+	}
+	var info struct {
+		RuntimeSpec struct {
+			Linux struct {
+				Resources struct {
+					Memory struct {
+						Limit int64 `json:"limit"`
+					} `json:"memory"`
+				} `json:"resources"`
+			} `json:"linux"`
+		} `json:"runtimeSpec"`
+	}
+	if err := json.Unmarshal([]byte(resp.Info["info"]), &info); err != nil {
+		return 0, fmt.Errorf("no runtime spec for container %v: %v", containerID, err)
+	}
+	return info.RuntimeSpec.Linux.Resources.Memory.Limit, nil
+}
@@ -0,0 +1,189 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package conflicts
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+/*
+	CRI runtime service serving a fixed container
+	list and recording resource updates.
+*/
+type fakeCRI struct {
+	criapi.UnimplementedRuntimeServiceServer
+	mutex      sync.Mutex
+	containers []*criapi.Container
+	sandboxes  []*criapi.PodSandbox
+	updates    []*criapi.UpdateContainerResourcesRequest
+	info       map[string]string
+}
+
+func (f *fakeCRI) ListContainers(ctx context.Context, req *criapi.ListContainersRequest) (*criapi.ListContainersResponse, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	res := []*criapi.Container{}
+	for _, c := range f.containers {
+		if req.Filter == nil || req.Filter.State == nil || req.Filter.State.State == c.State {
+			res = append(res, c)
+		}
+	}
+	return &criapi.ListContainersResponse{Containers: res}, nil
+}
+
+func (f *fakeCRI) ListPodSandbox(ctx context.Context, req *criapi.ListPodSandboxRequest) (*criapi.ListPodSandboxResponse, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return &criapi.ListPodSandboxResponse{Items: f.sandboxes}, nil
+}
+
+func (f *fakeCRI) UpdateContainerResources(ctx context.Context, req *criapi.UpdateContainerResourcesRequest) (*criapi.UpdateContainerResourcesResponse, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.updates = append(f.updates, req)
+	return &criapi.UpdateContainerResourcesResponse{}, nil
+}
+
+func (f *fakeCRI) ContainerStatus(ctx context.Context, req *criapi.ContainerStatusRequest) (*criapi.ContainerStatusResponse, error) {
+	return &criapi.ContainerStatusResponse{
+		Status: &criapi.ContainerStatus{Id: req.ContainerId},
+		Info:   f.info,
+	}, nil
+}
+
+/*
+	Serves fake on a unix socket and returns
+	a client connected through NewCRIRuntimeClient.
+*/
+func startFakeCRI(t *testing.T, fake *fakeCRI) criapi.RuntimeServiceClient {
+	sock := filepath.Join(t.TempDir(), "cri.sock")
+	lis, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	criapi.RegisterRuntimeServiceServer(srv, fake)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	runtime, err := NewCRIRuntimeClient("unix://" + sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runtime
+}
+
+func criContainer(id, function string, state criapi.ContainerState) *criapi.Container {
+	return &criapi.Container{
+		Id:       id,
+		Metadata: &criapi.ContainerMetadata{Name: "user-action"},
+		State:    state,
+		Labels: map[string]string{
+			CONTAINER_NAME_LABEL: "user-action",
+			POD_NAME_LABEL:       "wskowdev-invoker-00-1-guest-" + function,
+		},
+		CreatedAt: time.Now().UnixNano(),
+	}
+}
+
+func TestCRIIndex(t *testing.T) {
+	fake := &fakeCRI{
+		containers: []*criapi.Container{
+			criContainer("c1", "fn", criapi.ContainerState_CONTAINER_RUNNING),
+			criContainer("c2", "stopped", criapi.ContainerState_CONTAINER_EXITED),
+			{Id: "c3", State: criapi.ContainerState_CONTAINER_RUNNING, Labels: map[string]string{"app": "other"}},
+		},
+		sandboxes: []*criapi.PodSandbox{{
+			Id:     "s1",
+			State:  criapi.PodSandboxState_SANDBOX_READY,
+			Labels: map[string]string{POD_NAME_LABEL: "wskowdev-invoker-00-1-guest-fn"},
+		}},
+	}
+	idx := NewCRIIndex(startFakeCRI(t, fake))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan string, 4)
+	go idx.Run(ctx, func(function, id string, running bool) {
+		if running {
+			started <- function + "/" + id
+		}
+	})
+	select {
+	case <-idx.Synced():
+	case <-time.After(5 * time.Second):
+		t.Fatal("index never synced")
+	}
+	if got := <-started; got != "fn/c1" {
+		t.Fatalf("reported start %v, want fn/c1", got)
+	}
+	if cnt := idx.Lookup("fn", "user-action"); cnt == nil || cnt.ID != "c1" {
+		t.Fatalf("lookup of fn returned %v", cnt)
+	}
+	if cnt := idx.Lookup("fn", "POD"); cnt == nil || cnt.ID != "s1" {
+		t.Fatalf("lookup of fn sandbox returned %v", cnt)
+	}
+	if cnt := idx.Lookup("stopped", "user-action"); cnt != nil {
+		t.Fatalf("exited container %v indexed", cnt.ID)
+	}
+	if n := len(idx.Containers("user-action")); n != 1 {
+		t.Fatalf("indexed %v action containers, want 1", n)
+	}
+}
+
+func TestCRIController(t *testing.T) {
+	fake := &fakeCRI{
+		info: map[string]string{"info": `{"runtimeSpec":{"linux":{"resources":{"memory":{"limit":268435456}}}}}`},
+	}
+	cc := NewCRIController(startFakeCRI(t, fake))
+	ctx := context.Background()
+	if err := cc.UpdateCPUQuota(ctx, "c1", 50000); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.UpdateMemory(ctx, "c1", 512*MEGABYTE); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.UpdateCpuset(ctx, "c1", "2,3"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.updates) != 3 {
+		t.Fatalf("%v updates sent, want 3", len(fake.updates))
+	}
+	cpu, mem, cpuset := fake.updates[0], fake.updates[1], fake.updates[2]
+	if cpu.ContainerId != "c1" || cpu.Linux.CpuQuota != 50000 || cpu.Linux.CpuPeriod != CPU_PERIOD_OPENWHISK_DEFAULT {
+		t.Errorf("cpu update %+v", cpu.Linux)
+	}
+	if mem.Linux.MemoryLimitInBytes != 512*MEGABYTE || mem.Linux.CpuQuota != 0 {
+		t.Errorf("memory update %+v", mem.Linux)
+	}
+	if cpuset.Linux.CpusetCpus != "2,3" || cpuset.Linux.CpuQuota != 0 {
+		t.Errorf("cpuset update %+v", cpuset.Linux)
+	}
+	if limit, err := cc.MemoryLimit(ctx, "c1"); err != nil || limit != 256*MEGABYTE {
+		t.Fatalf("memory limit %v, %v", limit, err)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package conflicts
+
+const (
+	EVENT_CONTAINER_STARTED string = "container_started"
+	EVENT_CONTAINER_STOPPED string = "container_stopped"
+	EVENT_REGISTRY_CHANGED  string = "registry_changed" // Requests or resources of a function changed
+)
+
+/*
+	Receives container and registry events.
+	It may be called with the registry lock held,
+	so it must neither block nor call the resolver.
+*/
+type EventListener func(event, function, containerID string)
+
+func (cr *ConflictResolver) emit(event, function, containerID string) {
+	if cr.events != nil {
+		cr.events(event, function, containerID)
+	}
+}
+
+/*
+	Reports container changes of the index, dropping
+	registry entries of containers that are gone.
+*/
+func (cr *ConflictResolver) containerChanged(function, containerID string, running bool) {
+	if running {
+		cr.resolveContainer(containerID)
+		cr.emit(EVENT_CONTAINER_STARTED, function, containerID)
+		return
+	}
+	cr.emit(EVENT_CONTAINER_STOPPED, function, containerID)
+	cr.forgetContainer(function, containerID)
+}
@@ -28,5 +28,6 @@ require (
 	github.com/docker/go-connections v0.4.0 // indirect
 	github.com/john98nf/SequenceClock/watcher/internal/state v0.0.0-20210901212831-7d78eb166378
 	github.com/john98nf/SequenceClock/watcher/pkg/request v0.0.0-20210820205221-369ee2bc9c4d
-	google.golang.org/grpc v1.40.0 // indirect
+	google.golang.org/grpc v1.40.0
+	k8s.io/cri-api v0.22.1
 )
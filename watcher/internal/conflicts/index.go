@@ -0,0 +1,341 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package conflicts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+const (
+	CONTAINER_NAME_LABEL  string        = "io.kubernetes.container.name"
+	POD_NAME_LABEL        string        = "io.kubernetes.pod.name"
+	EVENTS_RETRY_INTERVAL time.Duration = time.Second
+	INDEX_SYNC_WAIT       time.Duration = 2 * time.Second // Longest wait of a request for the first sync
+)
+
+var ErrIndexNotSynced = errors.New("container index not synced yet")
+
+var podNameExp = regexp.MustCompile(fmt.Sprintf(REG_EXP, "(.+)$"))
+
+type ContainerIndexInterface interface {
+	Lookup(function, podType string) *types.Container
+	Containers(podType string) []*types.Container
+	Synced() <-chan struct{}
+	Run(ctx context.Context, changed ContainerChange)
+}
+
+/*
+	Reports a user-action container that
+	started (running) or died or disappeared.
+*/
+type ContainerChange func(function, containerID string, running bool)
+
+/*
+	Key of an indexed openwhisk action container.
+*/
+type indexKey struct {
+	function string
+	podType  string
+}
+
+/*
+	In-memory index of openwhisk action containers.
+	Runtime specific indexes embed it and
+	feed it from their runtime.
+*/
+type ContainerIndex struct {
+	mutex      sync.RWMutex
+	containers map[indexKey]map[string]*types.Container // Running containers of a key, by id
+	keys       map[string]indexKey
+	synced     chan struct{}
+	syncOnce   sync.Once
+}
+
+func NewContainerIndex() *ContainerIndex {
+	return &ContainerIndex{
+		mutex:      sync.RWMutex{},
+		containers: make(map[indexKey]map[string]*types.Container),
+		keys:       make(map[string]indexKey),
+		synced:     make(chan struct{}),
+	}
+}
+
+/*
+	Closed once the index got
+	its first full container list.
+*/
+func (idx *ContainerIndex) Synced() <-chan struct{} {
+	return idx.synced
+}
+
+/*
+	Waits up to INDEX_SYNC_WAIT for the first sync
+	of idx, so that lookups right after startup do
+	not miss running containers.
+*/
+func awaitSynced(ctx context.Context, idx ContainerIndexInterface) error {
+	select {
+	case <-idx.Synced():
+		return nil
+	default:
+	}
+	timer := time.NewTimer(INDEX_SYNC_WAIT)
+	defer timer.Stop()
+	select {
+	case <-idx.Synced():
+		return nil
+	case <-timer.C:
+		return ErrIndexNotSynced
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %v", ErrIndexNotSynced, ctx.Err())
+	}
+}
+
+/*
+	Returns the newest indexed container of
+	a function, or nil if there is none.
+*/
+func (idx *ContainerIndex) Lookup(function, podType string) *types.Container {
+	var res *types.Container
+	idx.mutex.RLock()
+	for _, cnt := range idx.containers[indexKey{function, podType}] {
+		if res == nil || cnt.Created > res.Created || (cnt.Created == res.Created && cnt.ID > res.ID) {
+			res = cnt
+		}
+	}
+	idx.mutex.RUnlock()
+	return res
+}
+
+/*
+	Returns every indexed container of a pod type.
+*/
+func (idx *ContainerIndex) Containers(podType string) []*types.Container {
+	res := []*types.Container{}
+	idx.mutex.RLock()
+	for key, set := range idx.containers {
+		if key.podType == podType {
+			for _, cnt := range set {
+				res = append(res, cnt)
+			}
+		}
+	}
+	idx.mutex.RUnlock()
+	return res
+}
+
+/*
+	Replaces index contents with a full container list.
+	Containers that appeared or vanished since the
+	previous call are reported through changed.
+*/
+func (idx *ContainerIndex) replace(containers []types.Container, changed ContainerChange) {
+	fresh := make(map[indexKey]map[string]*types.Container)
+	keys := make(map[string]indexKey)
+	for i := range containers {
+		if key, ok := keyOf(containers[i].Labels); ok {
+			if fresh[key] == nil {
+				fresh[key] = make(map[string]*types.Container)
+			}
+			fresh[key][containers[i].ID] = &containers[i]
+			keys[containers[i].ID] = key
+		}
+	}
+	idx.mutex.Lock()
+	stale := idx.keys
+	idx.containers, idx.keys = fresh, keys
+	idx.mutex.Unlock()
+	idx.syncOnce.Do(func() { close(idx.synced) })
+
+	for id, key := range stale {
+		if _, ok := keys[id]; !ok && key.podType == "user-action" {
+			changed(key.function, id, false)
+		}
+	}
+	for id, key := range keys {
+		if _, ok := stale[id]; !ok && key.podType == "user-action" {
+			changed(key.function, id, true)
+		}
+	}
+}
+
+/*
+	Indexes a container, returning its key
+	and whether it was not indexed before.
+*/
+func (idx *ContainerIndex) insert(cnt *types.Container) (indexKey, bool) {
+	key, ok := keyOf(cnt.Labels)
+	if !ok {
+		return key, false
+	}
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	_, known := idx.keys[cnt.ID]
+	if idx.containers[key] == nil {
+		idx.containers[key] = make(map[string]*types.Container)
+	}
+	idx.containers[key][cnt.ID] = cnt
+	idx.keys[cnt.ID] = key
+	return key, !known
+}
+
+/*
+	Drops a container from the index. Its key
+	is dropped with the last container of it.
+*/
+func (idx *ContainerIndex) remove(containerID string) (indexKey, bool) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	key, ok := idx.keys[containerID]
+	if !ok {
+		return key, false
+	}
+	delete(idx.keys, containerID)
+	delete(idx.containers[key], containerID)
+	if len(idx.containers[key]) == 0 {
+		delete(idx.containers, key)
+	}
+	return key, true
+}
+
+/*
+	Container index fed by the docker events api.
+*/
+type DockerIndex struct {
+	*ContainerIndex
+	dockerClient *client.Client
+}
+
+func NewDockerIndex(cli *client.Client) *DockerIndex {
+	return &DockerIndex{
+		ContainerIndex: NewContainerIndex(),
+		dockerClient:   cli,
+	}
+}
+
+/*
+	Subscribes to docker container events and keeps
+	the index in sync until ctx is cancelled.
+	On every (re)subscription the index is rebuilt from
+	the container list. changed is called for each
+	user-action container that starts, dies or disappears.
+*/
+func (idx *DockerIndex) Run(ctx context.Context, changed ContainerChange) {
+	options := types.EventsOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("type", events.ContainerEventType),
+			filters.Arg("event", "start"),
+			filters.Arg("event", "die"),
+			filters.Arg("event", "destroy"),
+		),
+	}
+	for {
+		msgs, errs := idx.dockerClient.Events(ctx, options)
+		if containers, err := idx.dockerClient.ContainerList(ctx, types.ContainerListOptions{}); err != nil {
+			log.Println("Container index sync failed:", err.Error())
+		} else {
+			idx.replace(containers, changed)
+			if err := idx.consume(ctx, msgs, errs, changed); err != nil {
+				log.Println("Docker events stream closed:", err.Error())
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(EVENTS_RETRY_INTERVAL):
+		}
+	}
+}
+
+/*
+	Handles incoming docker events until
+	the stream returns an error.
+*/
+func (idx *DockerIndex) consume(ctx context.Context, msgs <-chan events.Message, errs <-chan error, changed ContainerChange) error {
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case msg := <-msgs:
+			switch msg.Action {
+			case "start":
+				cnt, err := idx.inspect(ctx, msg.Actor.ID)
+				if err != nil {
+					log.Println(err.Error())
+				} else if cnt != nil {
+					if key, added := idx.insert(cnt); added && key.podType == "user-action" {
+						changed(key.function, cnt.ID, true)
+					}
+				}
+			case "die", "destroy":
+				if key, ok := idx.remove(msg.Actor.ID); ok && key.podType == "user-action" {
+					changed(key.function, msg.Actor.ID, false)
+				}
+			}
+		}
+	}
+}
+
+/*
+	Fetches a single container from docker runtime.
+	Returns nil if it is not an openwhisk action container.
+*/
+func (idx *DockerIndex) inspect(ctx context.Context, containerID string) (*types.Container, error) {
+	containers, err := idx.dockerClient.ContainerList(ctx, types.ContainerListOptions{
+		Filters: filters.NewArgs(filters.Arg("id", containerID)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range containers {
+		if _, ok := keyOf(containers[i].Labels); ok {
+			return &containers[i], nil
+		}
+	}
+	return nil, nil
+}
+
+/*
+	Extracts function name and pod type from
+	kubernetes labels of a container.
+*/
+func keyOf(labels map[string]string) (indexKey, bool) {
+	podType, ok := labels[CONTAINER_NAME_LABEL]
+	if !ok {
+		return indexKey{}, false
+	}
+	m := podNameExp.FindStringSubmatch(labels[POD_NAME_LABEL])
+	if m == nil {
+		return indexKey{}, false
+	}
+	return indexKey{function: m[1], podType: podType}, true
+}
@@ -0,0 +1,107 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package conflicts
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func actionContainer(id, function string, created int64) *types.Container {
+	return &types.Container{
+		ID:      id,
+		Created: created,
+		Labels: map[string]string{
+			CONTAINER_NAME_LABEL: "user-action",
+			POD_NAME_LABEL:       "wskowdev-invoker-00-1-guest-" + function,
+		},
+	}
+}
+
+func TestIndexKeepsEveryContainerOfAFunction(t *testing.T) {
+	idx := NewContainerIndex()
+	idx.insert(actionContainer("a", "fn", 1))
+	if _, added := idx.insert(actionContainer("b", "fn", 2)); !added {
+		t.Fatal("second container of fn not reported as added")
+	}
+	if cnt := idx.Lookup("fn", "user-action"); cnt == nil || cnt.ID != "b" {
+		t.Fatalf("lookup returned %v, want newest container b", cnt)
+	}
+	if n := len(idx.Containers("user-action")); n != 2 {
+		t.Fatalf("indexed %v containers, want 2", n)
+	}
+
+	idx.remove("b")
+	if cnt := idx.Lookup("fn", "user-action"); cnt == nil || cnt.ID != "a" {
+		t.Fatalf("lookup returned %v after b died, want a", cnt)
+	}
+	idx.remove("a")
+	if cnt := idx.Lookup("fn", "user-action"); cnt != nil {
+		t.Fatalf("lookup returned %v after every container died", cnt.ID)
+	}
+	if _, ok := idx.containers[indexKey{"fn", "user-action"}]; ok {
+		t.Fatal("key kept without containers")
+	}
+}
+
+func TestIndexReplaceReportsChanges(t *testing.T) {
+	idx := NewContainerIndex()
+	idx.insert(actionContainer("a", "fn", 1))
+	changes := map[string]bool{}
+	idx.replace([]types.Container{*actionContainer("a", "fn", 1), *actionContainer("b", "fn", 2)},
+		func(function, id string, running bool) { changes[id] = running })
+	if len(changes) != 1 || !changes["b"] {
+		t.Fatalf("changes %v, want b started", changes)
+	}
+	if n := len(idx.Containers("user-action")); n != 2 {
+		t.Fatalf("indexed %v containers, want 2", n)
+	}
+}
+
+func TestRequestWaitsForIndexSync(t *testing.T) {
+	cr, _ := newTestResolver(2, &ProportionalPolicy{})
+	idx := staticIndex{NewContainerIndex()}
+	cr.Index = idx
+
+	// Never synced, the caller gives up first
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := cr.UpdateRegistry(ctx, requestFor(1, "fn", 50000)); !errors.Is(err, ErrIndexNotSynced) {
+		t.Fatalf("got %v before the first sync, want ErrIndexNotSynced", err)
+	}
+	if len(cr.Registry) != 0 {
+		t.Fatal("registry changed before the first sync")
+	}
+
+	// Synced while the request waits
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		idx.replace([]types.Container{*actionContainer("fn", "fn", 1)}, func(string, string, bool) {})
+	}()
+	grant, err := cr.UpdateRegistry(context.Background(), requestFor(2, "fn", 50000))
+	if err != nil || grant == nil {
+		t.Fatalf("got grant %v, error %v after the first sync, want a grant", grant, err)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package conflicts
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+)
+
+const (
+	LAMBDA_CHECK_INTERVAL  time.Duration = 30 * time.Second
+	LAMBDA_DRIFT_TOLERANCE float64       = 1e-9 // Relative
+)
+
+/*
+	λ computed over the unpinned functions
+	of the registry. Returns 0 when there
+	are none.
+*/
+func (cr *ConflictResolver) exactLambda() float64 {
+	sum := cr.unpinnedDesired()
+	if sum == 0 {
+		return 0
+	}
+	return float64(cr.sharedCapacity()) / float64(sum)
+}
+
+/*
+	Sum of desired CPU quotas of
+	the unpinned registry functions.
+*/
+func (cr *ConflictResolver) unpinnedDesired() int64 {
+	var sum int64
+	for _, s := range cr.Registry {
+		if s.Cpuset == "" {
+			sum += s.DesiredQuotas
+		}
+	}
+	return sum
+}
+
+/*
+	CPU quotas of the cores
+	not reserved for pinning.
+*/
+func (cr *ConflictResolver) sharedCapacity() int64 {
+	return (cr.Cores - int64(len(cr.reserved))) * CPU_PERIOD_OPENWHISK_DEFAULT
+}
+
+/*
+	Relative difference between incremental
+	and exact λ. Zero when λ is not
+	maintained incrementally.
+*/
+func (cr *ConflictResolver) LambdaDrift() float64 {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+	return cr.lambdaDrift()
+}
+
+func (cr *ConflictResolver) lambdaDrift() float64 {
+	if cr.lambdaPrevious == 0 {
+		return 0
+	}
+	exact := cr.exactLambda()
+	if exact == 0 {
+		return math.Inf(1)
+	}
+	return math.Abs(cr.lambdaPrevious-exact) / exact
+}
+
+/*
+	Number of times incremental λ
+	drifted and got recomputed.
+*/
+func (cr *ConflictResolver) LambdaResyncs() uint64 {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+	return cr.lambdaResyncs
+}
+
+/*
+	Periodically compares incremental λ
+	against its exact value.
+*/
+func (cr *ConflictResolver) runLambdaCheck(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cr.CheckLambda(ctx)
+		}
+	}
+}
+
+/*
+	Recomputes λ exactly and reapplies quotas
+	when the incremental value drifted.
+	Reports whether drift was found.
+*/
+func (cr *ConflictResolver) CheckLambda(ctx context.Context) bool {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	drift := cr.lambdaDrift()
+	if drift <= LAMBDA_DRIFT_TOLERANCE {
+		return false
+	}
+	log.Printf("Incremental lambda %v drifted by %v, recomputing\n", cr.lambdaPrevious, drift)
+	cr.lambdaResyncs++
+	cr.lambdaPrevious = 0
+	if len(cr.Registry) != 0 {
+		cr.ReconfigureRegistry(ctx, 0, 0)
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package conflicts
+
+import (
+	"math/rand"
+	"testing"
+	"testing/quick"
+)
+
+/*
+	Random interleavings of requests and resets
+	over a few functions. After every step the
+	incremental λ must equal the exact one, every
+	container must hold λ times its desired quotas
+	and every function must desire the largest of
+	its outstanding requests. Once all requests are
+	reset, nothing may be left in the registry.
+*/
+func TestIncrementalLambdaProperty(t *testing.T) {
+	functions := []string{"f0", "f1", "f2", "f3", "f4"}
+	property := func(seed int64) bool {
+		rnd := rand.New(rand.NewSource(seed))
+		cr, ctl := newTestResolver(2, &ProportionalPolicy{}, functions...)
+		outstanding := map[string]map[uint64]int64{}
+		var next uint64
+		check := func(step int) bool {
+			if drift := cr.lambdaDrift(); drift != 0 {
+				t.Errorf("seed %v step %v: λ %v drifted by %v", seed, step, cr.lambdaPrevious, drift)
+				return false
+			}
+			exact := cr.exactLambda()
+			for f, s := range cr.Registry {
+				var desired int64
+				for _, q := range outstanding[f] {
+					if q > desired {
+						desired = q
+					}
+				}
+				if s.DesiredQuotas != desired {
+					t.Errorf("seed %v step %v: %v desires %v, want %v", seed, step, f, s.DesiredQuotas, desired)
+					return false
+				}
+				want := desired
+				if exact < 1 {
+					want = lowerBound(int64(exact * float64(desired)))
+				}
+				if got := ctl.quota(s.Container); got != want {
+					t.Errorf("seed %v step %v: %v holds %v quotas, want %v", seed, step, f, got, want)
+					return false
+				}
+			}
+			return true
+		}
+		for step := 0; step < 200; step++ {
+			f := functions[rnd.Intn(len(functions))]
+			if ids := outstanding[f]; len(ids) != 0 && rnd.Intn(2) == 0 {
+				for id := range ids {
+					mustReset(t, cr, id, f)
+					delete(ids, id)
+					break
+				}
+			} else {
+				next++
+				grant := mustUpdate(t, cr, requestFor(next, f, int64(5+rnd.Intn(195))*1000))
+				if outstanding[f] == nil {
+					outstanding[f] = map[uint64]int64{}
+				}
+				outstanding[f][next] = grant.Requested
+			}
+			if !check(step) {
+				return false
+			}
+		}
+		for f, ids := range outstanding {
+			for id := range ids {
+				mustReset(t, cr, id, f)
+			}
+		}
+		if len(cr.Registry) != 0 || cr.lambdaPrevious != 0 {
+			t.Errorf("seed %v: registry left with %v functions, λ %v", seed, len(cr.Registry), cr.lambdaPrevious)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 50}); err != nil {
+		t.Fatal(err)
+	}
+}
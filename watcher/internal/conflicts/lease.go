@@ -0,0 +1,140 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package conflicts
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	wfs "github.com/john98nf/SequenceClock/watcher/internal/state"
+	wrq "github.com/john98nf/SequenceClock/watcher/pkg/request"
+)
+
+const (
+	LEASE_TTL_FACTOR      int64         = 3 // Lease lasts a few profiled executions
+	LEASE_MIN_TTL         time.Duration = 5 * time.Second
+	LEASE_REAPER_INTERVAL time.Duration = time.Second
+)
+
+var ErrLeaseNotFound = errors.New("lease not found")
+
+/*
+	Lease duration of a request,
+	derived from profiled execution time
+	of the function (nanoseconds).
+*/
+func leaseTTL(req *wrq.Request) time.Duration {
+	var ttl time.Duration
+	if req.Metrics != nil {
+		ttl = time.Duration(LEASE_TTL_FACTOR * req.Metrics.ProfiledExecutionTime)
+	}
+	if ttl < LEASE_MIN_TTL {
+		return LEASE_MIN_TTL
+	}
+	return ttl
+}
+
+/*
+	Grants a lease to a request.
+*/
+func grantLease(state *wfs.FunctionState, id uint64, ttl time.Duration) {
+	state.Requests.Leases[id] = wfs.Lease{
+		Expiry: time.Now().Add(ttl),
+		TTL:    ttl,
+	}
+}
+
+/*
+	Extends lease of a request by its TTL.
+*/
+func (cr *ConflictResolver) RenewLease(rs wrq.ResetRequest) error {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	state, ok := cr.Registry[rs.Function]
+	if !ok {
+		return ErrLeaseNotFound
+	}
+	lease, ok := state.Requests.Leases[rs.ID]
+	if !ok {
+		return ErrLeaseNotFound
+	}
+	grantLease(state, rs.ID, lease.TTL)
+	return nil
+}
+
+/*
+	Number of requests reclaimed
+	because their lease expired.
+*/
+func (cr *ConflictResolver) ReapedLeases() uint64 {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+	return cr.reaped
+}
+
+/*
+	Periodically reclaims requests whose lease
+	expired, e.g. when their sequence controller
+	crashed before resetting them.
+*/
+func (cr *ConflictResolver) runReaper(ctx context.Context) {
+	ticker := time.NewTicker(LEASE_REAPER_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			cr.reapLeases(ctx, now)
+		}
+	}
+}
+
+/*
+	Removes every request whose lease
+	expired before now.
+*/
+func (cr *ConflictResolver) reapLeases(ctx context.Context, now time.Time) {
+	cr.mutex.Lock()
+	defer cr.flushCpusets(ctx)
+	defer cr.mutex.Unlock()
+	expired := []wrq.ResetRequest{}
+	for f, s := range cr.Registry {
+		for id, lease := range s.Requests.Leases {
+			if now.After(lease.Expiry) {
+				expired = append(expired, *wrq.NewResetRequest(id, f))
+			}
+		}
+	}
+	for _, rs := range expired {
+		log.Printf("Lease of request %v for function '%v' expired, reclaiming its resources\n", rs.ID, rs.Function)
+		if err := cr.removeRequest(ctx, rs); err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		cr.reaped++
+	}
+	if len(expired) != 0 {
+		cr.saveCheckpoint()
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package conflicts
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReconcileExtendsRestoredLeases(t *testing.T) {
+	cr, _ := newTestResolver(2, &ProportionalPolicy{}, "a")
+	mustUpdate(t, cr, requestFor(1, "a", 50000))
+	// Restored from a checkpoint written long before the restart
+	s := cr.Registry["a"]
+	lease := s.Requests.Leases[1]
+	lease.Expiry = time.Now().Add(-time.Hour)
+	s.Requests.Leases[1] = lease
+
+	ctx := context.Background()
+	cr.reconcile(ctx)
+	cr.reapLeases(ctx, time.Now())
+	if _, ok := cr.Registry["a"]; !ok || cr.ReapedLeases() != 0 {
+		t.Fatalf("restored request reaped before its lease was extended")
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package conflicts
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	PROPORTIONAL_POLICY string = "proportional"
+	PRIORITY_POLICY     string = "priority"
+	WEIGHTED_POLICY     string = "weighted"
+	EDF_POLICY          string = "edf"
+	MAXMIN_POLICY       string = "maxmin"
+)
+
+/*
+	CPU demand of a function,
+	as seen by allocation policies.
+*/
+type Demand struct {
+	Function string
+	Desired  int64
+	Priority int64
+	Weight   int64
+	Slack    int64
+}
+
+/*
+	Splits node capacity (in CPU quotas) among demands.
+	Returns the allocation of each demand, in order.
+	No demand may be allocated more than it desires.
+*/
+type AllocationPolicy interface {
+	Name() string
+	Allocate(capacity int64, demands []Demand) []int64
+}
+
+/*
+	Returns the allocation policy named by name.
+	Empty name defaults to proportional scaling.
+*/
+func NewAllocationPolicy(name string) (AllocationPolicy, error) {
+	switch name {
+	case "", PROPORTIONAL_POLICY:
+		return &ProportionalPolicy{}, nil
+	case PRIORITY_POLICY:
+		return &PriorityPolicy{}, nil
+	case WEIGHTED_POLICY:
+		return &WeightedFairPolicy{}, nil
+	case EDF_POLICY:
+		return &EDFPolicy{}, nil
+	case MAXMIN_POLICY:
+		return &MaxMinPolicy{}, nil
+	default:
+		return nil, fmt.Errorf("unknown allocation policy '%v'", name)
+	}
+}
+
+/*
+	Scales every demand by λ = capacity / sum of demands
+	when the node is over-subscribed.
+*/
+type ProportionalPolicy struct{}
+
+func (p *ProportionalPolicy) Name() string { return PROPORTIONAL_POLICY }
+
+func (p *ProportionalPolicy) Allocate(capacity int64, demands []Demand) []int64 {
+	var sum int64
+	for _, d := range demands {
+		sum += d.Desired
+	}
+	res := make([]int64, len(demands))
+	lambda := float64(capacity) / float64(sum)
+	for i, d := range demands {
+		if lambda < 1.0 {
+			res[i] = int64(lambda * float64(d.Desired))
+		} else {
+			res[i] = d.Desired
+		}
+	}
+	return res
+}
+
+/*
+	Serves demands in descending priority,
+	each one fully before the next.
+*/
+type PriorityPolicy struct{}
+
+func (p *PriorityPolicy) Name() string { return PRIORITY_POLICY }
+
+func (p *PriorityPolicy) Allocate(capacity int64, demands []Demand) []int64 {
+	return greedy(capacity, demands, func(a, b Demand) bool {
+		return a.Priority > b.Priority
+	})
+}
+
+/*
+	Earliest deadline first: serves demands in
+	ascending slack, so functions running furthest
+	behind their profiled time go first.
+*/
+type EDFPolicy struct{}
+
+func (p *EDFPolicy) Name() string { return EDF_POLICY }
+
+func (p *EDFPolicy) Allocate(capacity int64, demands []Demand) []int64 {
+	return greedy(capacity, demands, func(a, b Demand) bool {
+		return a.Slack < b.Slack
+	})
+}
+
+/*
+	Weighted max-min fair share. Capacity is split
+	by weight, shares above a demand are handed back
+	to the others. Weight 0 counts as 1.
+*/
+type WeightedFairPolicy struct{}
+
+func (p *WeightedFairPolicy) Name() string { return WEIGHTED_POLICY }
+
+func (p *WeightedFairPolicy) Allocate(capacity int64, demands []Demand) []int64 {
+	weights := make([]int64, len(demands))
+	for i, d := range demands {
+		weights[i] = d.Weight
+		if weights[i] <= 0 {
+			weights[i] = 1
+		}
+	}
+	return waterFill(capacity, demands, weights)
+}
+
+/*
+	Max-min fairness: weighted fair share
+	with equal weights.
+*/
+type MaxMinPolicy struct{}
+
+func (p *MaxMinPolicy) Name() string { return MAXMIN_POLICY }
+
+func (p *MaxMinPolicy) Allocate(capacity int64, demands []Demand) []int64 {
+	weights := make([]int64, len(demands))
+	for i := range weights {
+		weights[i] = 1
+	}
+	return waterFill(capacity, demands, weights)
+}
+
+/*
+	Splits capacity among priority classes, highest first.
+	Each class is capped at the capacity left once every
+	lower class keeps lowerBound, so no tier is handed
+	more than remains. Within the class where capacity
+	runs out, policy shares what is left. Demands of
+	classes below it are preempted, i.e. held at
+	lowerBound while capacity lasts.
+*/
+func tiered(policy AllocationPolicy, capacity, lowerBound int64, demands []Demand) ([]int64, []bool) {
+	res := make([]int64, len(demands))
+	preempted := make([]bool, len(demands))
+	classes := make(map[int64][]int)
+	priorities := []int64{}
+	for i, d := range demands {
+		if _, ok := classes[d.Priority]; !ok {
+			priorities = append(priorities, d.Priority)
+		}
+		classes[d.Priority] = append(classes[d.Priority], i)
+	}
+	sort.Slice(priorities, func(i, j int) bool { return priorities[i] > priorities[j] })
+	below := int64(len(demands))
+	for k, p := range priorities {
+		members := classes[p]
+		below -= int64(len(members))
+		avail := capacity - below*lowerBound
+		if avail < 0 {
+			avail = 0
+		}
+		class := make([]Demand, len(members))
+		var sum int64
+		for j, i := range members {
+			class[j] = demands[i]
+			sum += demands[i].Desired
+		}
+		if sum <= avail {
+			for j, i := range members {
+				res[i] = class[j].Desired
+			}
+			capacity -= sum
+			continue
+		}
+		if avail > 0 {
+			for j, q := range policy.Allocate(avail, class) {
+				res[members[j]] = q
+			}
+			capacity -= avail
+		}
+		for _, lower := range priorities[k+1:] {
+			for _, i := range classes[lower] {
+				res[i] = demands[i].Desired
+				if res[i] > lowerBound {
+					res[i] = lowerBound
+				}
+				if res[i] > capacity {
+					res[i] = capacity
+				}
+				capacity -= res[i]
+				preempted[i] = demands[i].Desired > lowerBound
+			}
+		}
+		break
+	}
+	return res, preempted
+}
+
+/*
+	Serves demands in the order given by less,
+	ties broken by function name.
+*/
+func greedy(capacity int64, demands []Demand, less func(a, b Demand) bool) []int64 {
+	order := make([]int, len(demands))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := demands[order[i]], demands[order[j]]
+		if less(a, b) {
+			return true
+		} else if less(b, a) {
+			return false
+		}
+		return a.Function < b.Function
+	})
+	res := make([]int64, len(demands))
+	for _, i := range order {
+		res[i] = demands[i].Desired
+		if res[i] > capacity {
+			res[i] = capacity
+		}
+		capacity -= res[i]
+	}
+	return res
+}
+
+/*
+	Progressive filling: every unsatisfied demand
+	grows with its weight until it is met or
+	capacity runs out.
+*/
+func waterFill(capacity int64, demands []Demand, weights []int64) []int64 {
+	res := make([]int64, len(demands))
+	open := make(map[int]bool)
+	for i, d := range demands {
+		if d.Desired > 0 {
+			open[i] = true
+		}
+	}
+	for len(open) > 0 && capacity > 0 {
+		var wsum int64
+		for i := range open {
+			wsum += weights[i]
+		}
+		given := int64(0)
+		for i := range open {
+			share := capacity * weights[i] / wsum
+			if share >= demands[i].Desired-res[i] {
+				share = demands[i].Desired - res[i]
+				delete(open, i)
+			}
+			res[i] += share
+			given += share
+		}
+		if given == 0 {
+			break
+		}
+		capacity -= given
+	}
+	return res
+}
@@ -0,0 +1,148 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package conflicts
+
+import (
+	"testing"
+)
+
+/*
+	Over-subscribed demands on one core:
+	a and b ask half a core each, c a quarter.
+*/
+var testDemands = []Demand{
+	{Function: "a", Desired: 50000, Priority: 2, Weight: 1, Slack: -5},
+	{Function: "b", Desired: 50000, Priority: 1, Weight: 3, Slack: -10},
+	{Function: "c", Desired: 25000, Priority: 0, Weight: 0, Slack: 0},
+}
+
+func TestPolicyAllocate(t *testing.T) {
+	cases := []struct {
+		policy AllocationPolicy
+		want   []int64
+	}{
+		{&ProportionalPolicy{}, []int64{40000, 40000, 20000}},
+		{&PriorityPolicy{}, []int64{50000, 50000, 0}},
+		{&EDFPolicy{}, []int64{50000, 50000, 0}},
+		{&WeightedFairPolicy{}, []int64{25000, 50000, 25000}},
+		{&MaxMinPolicy{}, []int64{37500, 37500, 25000}},
+	}
+	for _, c := range cases {
+		t.Run(c.policy.Name(), func(t *testing.T) {
+			got := c.policy.Allocate(CPU_PERIOD_OPENWHISK_DEFAULT, testDemands)
+			for i := range c.want {
+				if diff := got[i] - c.want[i]; diff < -1 || diff > 1 {
+					t.Fatalf("allocated %v, want %v", got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicyUnderSubscribed(t *testing.T) {
+	for _, name := range []string{PROPORTIONAL_POLICY, PRIORITY_POLICY, WEIGHTED_POLICY, EDF_POLICY, MAXMIN_POLICY} {
+		policy, err := NewAllocationPolicy(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := policy.Allocate(2*CPU_PERIOD_OPENWHISK_DEFAULT, testDemands)
+		for i, d := range testDemands {
+			if got[i] != d.Desired {
+				t.Fatalf("%v allocated %v with capacity to spare", name, got)
+			}
+		}
+	}
+}
+
+func TestUnknownPolicy(t *testing.T) {
+	if _, err := NewAllocationPolicy("lottery"); err == nil {
+		t.Fatal("unknown policy accepted")
+	}
+}
+
+/*
+	Every policy driven through the resolver,
+	checking the quotas handed to the controller.
+*/
+func TestPolicyThroughResolver(t *testing.T) {
+	cases := []struct {
+		policy AllocationPolicy
+		want   map[string]int64
+	}{
+		{&ProportionalPolicy{}, map[string]int64{"a": 40000, "b": 40000, "c": 20000}},
+		{&PriorityPolicy{}, map[string]int64{"a": 50000, "b": 50000, "c": CPU_QUOTAS_LOWER_BOUND}},
+		{&EDFPolicy{}, map[string]int64{"a": 50000, "b": 50000, "c": CPU_QUOTAS_LOWER_BOUND}},
+		{&WeightedFairPolicy{}, map[string]int64{"a": 25000, "b": 50000, "c": 25000}},
+		{&MaxMinPolicy{}, map[string]int64{"a": 37500, "b": 37500, "c": 25000}},
+	}
+	for _, c := range cases {
+		t.Run(c.policy.Name(), func(t *testing.T) {
+			cr, ctl := newTestResolver(1, c.policy, "a", "b", "c")
+			for i, d := range testDemands {
+				req := requestFor(uint64(i+1), d.Function, d.Desired)
+				req.Weight = d.Weight
+				mustUpdate(t, cr, req)
+			}
+			for f, want := range c.want {
+				if diff := ctl.quota(f) - want; diff < -1 || diff > 1 {
+					t.Fatalf("container %v throttled to %v quotas, want %v", f, ctl.quota(f), want)
+				}
+			}
+
+			mustReset(t, cr, 1, "a")
+			for _, f := range []string{"b", "c"} {
+				if got, want := ctl.quota(f), cr.Registry[f].DesiredQuotas; got != want {
+					t.Fatalf("container %v kept %v quotas once the node had room, want %v", f, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTieredCapsEveryClass(t *testing.T) {
+	policy := &ProportionalPolicy{}
+	capacity := CPU_PERIOD_OPENWHISK_DEFAULT
+	got, preempted := tiered(policy, capacity, CPU_QUOTAS_LOWER_BOUND, testDemands)
+	// a is served first, b shares what c's floor leaves
+	want := []int64{50000, 50000 - CPU_QUOTAS_LOWER_BOUND, CPU_QUOTAS_LOWER_BOUND}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("tiered allocated %v, want %v", got, want)
+		}
+	}
+	if preempted[0] || preempted[1] || !preempted[2] {
+		t.Fatalf("preempted %v, want only c", preempted)
+	}
+
+	for _, capacity := range []int64{0, CPU_QUOTAS_LOWER_BOUND, 2500, 30000} {
+		got, _ := tiered(policy, capacity, CPU_QUOTAS_LOWER_BOUND, testDemands)
+		var sum int64
+		for i, q := range got {
+			if q < 0 || q > testDemands[i].Desired {
+				t.Fatalf("capacity %v: allocated %v", capacity, got)
+			}
+			sum += q
+		}
+		if sum > capacity {
+			t.Fatalf("capacity %v: tiers handed out %v", capacity, sum)
+		}
+	}
+}
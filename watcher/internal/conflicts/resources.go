@@ -0,0 +1,231 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package conflicts
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+const (
+	DOCKER_CONTROLLER string = "docker"
+	CRI_CONTROLLER    string = "cri"
+	CGROUP_CONTROLLER string = "cgroup"
+	CGROUP_V2_ROOT    string = "/sys/fs/cgroup"
+)
+
+/*
+	Backend that applies resource limits
+	to a container.
+*/
+type ResourceController interface {
+	UpdateCPUQuota(ctx context.Context, containerID string, cpuQuota int64) error
+	UpdateMemory(ctx context.Context, containerID string, memory int64) error
+	UpdateCpuset(ctx context.Context, containerID string, cpus string) error
+	MemoryLimit(ctx context.Context, containerID string) (int64, error)
+}
+
+/*
+	Implemented by controllers that locate a
+	container before updating it. Resolve is called
+	without the registry lock when a container
+	shows up or a request arrives.
+*/
+type ContainerResolver interface {
+	Resolve(containerID string) error
+}
+
+/*
+	Returns the resource controller named by backend.
+	Empty backend defaults to the api of the container
+	runtime in use, given by either cli or runtime.
+*/
+func NewResourceController(backend string, cli *client.Client, runtime criapi.RuntimeServiceClient) (ResourceController, error) {
+	if backend == "" && runtime != nil {
+		backend = CRI_CONTROLLER
+	} else if backend == "" {
+		backend = DOCKER_CONTROLLER
+	}
+	switch backend {
+	case DOCKER_CONTROLLER:
+		if cli == nil {
+			return nil, fmt.Errorf("resource controller '%v' requires docker runtime", backend)
+		}
+		return NewDockerController(cli), nil
+	case CRI_CONTROLLER:
+		if runtime == nil {
+			return nil, fmt.Errorf("resource controller '%v' requires cri runtime", backend)
+		}
+		return NewCRIController(runtime), nil
+	case CGROUP_CONTROLLER:
+		return NewCgroupController(CGROUP_V2_ROOT), nil
+	default:
+		return nil, fmt.Errorf("unknown resource controller '%v'", backend)
+	}
+}
+
+/*
+	Resource controller that goes
+	through docker daemon api.
+*/
+type DockerController struct {
+	dockerClient *client.Client
+}
+
+func NewDockerController(cli *client.Client) *DockerController {
+	return &DockerController{
+		dockerClient: cli,
+	}
+}
+
+/*
+	Updates CPU quotas of specified docker container,
+	leaving every other resource untouched.
+*/
+func (dc *DockerController) UpdateCPUQuota(ctx context.Context, containerID string, cpuQuota int64) error {
+	updateConfig := containertypes.UpdateConfig{
+		Resources: containertypes.Resources{
+			BlkioWeight:        BLKIO_WEIGHT_DEFAULT,
+			CpusetCpus:         CPUSET_CPUS_DEFAULT,
+			CpusetMems:         CPUSET_MEMS_DEFAULT,
+			CPUShares:          CPU_SHARES_DEFAULT,
+			Memory:             MEMORY_DEFAULT,
+			MemoryReservation:  MEMORY_RESERVATION_DEFAULT,
+			MemorySwap:         MEMORY_SWAP_DEFAULT,
+			KernelMemory:       KERNEL_MEMORY_DEFAULT,
+			CPUPeriod:          CPU_PERIOD_DEFAULT,
+			CPUQuota:           cpuQuota,
+			CPURealtimePeriod:  CPU_REALTIME_PERIOD_DEFAULT,
+			CPURealtimeRuntime: CPU_REALTIME_RUNTIME_DEFAULT,
+			NanoCPUs:           NANO_CPUS_DEFAULT,
+		},
+		RestartPolicy: containertypes.RestartPolicy{
+			Name:              "no",
+			MaximumRetryCount: 0,
+		},
+	}
+	if _, err := dc.dockerClient.ContainerUpdate(ctx, containerID, updateConfig); err != nil {
+		return err
+	}
+	return nil
+}
+
+/*
+	Updates memory limit (in bytes) of specified
+	docker container. Swap limit follows memory,
+	as kubernetes runs containers without swap.
+*/
+func (dc *DockerController) UpdateMemory(ctx context.Context, containerID string, memory int64) error {
+	updateConfig := containertypes.UpdateConfig{
+		Resources: containertypes.Resources{
+			Memory:     memory,
+			MemorySwap: memory,
+		},
+	}
+	if _, err := dc.dockerClient.ContainerUpdate(ctx, containerID, updateConfig); err != nil {
+		return err
+	}
+	return nil
+}
+
+/*
+	Restricts specified docker container
+	to a cpuset list, e.g. "2,3".
+*/
+func (dc *DockerController) UpdateCpuset(ctx context.Context, containerID string, cpus string) error {
+	updateConfig := containertypes.UpdateConfig{
+		Resources: containertypes.Resources{
+			CpusetCpus: cpus,
+		},
+	}
+	if _, err := dc.dockerClient.ContainerUpdate(ctx, containerID, updateConfig); err != nil {
+		return err
+	}
+	return nil
+}
+
+/*
+	Returns memory limit (in bytes) of specified
+	docker container, 0 if unlimited.
+*/
+func (dc *DockerController) MemoryLimit(ctx context.Context, containerID string) (int64, error) {
+	cnt, err := dc.dockerClient.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return 0, err
+	}
+	return cnt.HostConfig.Memory, nil
+}
+
+/*
+	Reports every resource update of a controller
+	with the context of the request that caused it,
+	its operation, container, start time and error.
+*/
+type ControllerObserver func(ctx context.Context, operation, containerID string, start time.Time, err error)
+
+/*
+	Resource controller reporting
+	its updates to an observer.
+*/
+type ObservedController struct {
+	ResourceController
+	observe ControllerObserver
+}
+
+func NewObservedController(ctl ResourceController, observe ControllerObserver) *ObservedController {
+	return &ObservedController{
+		ResourceController: ctl,
+		observe:            observe,
+	}
+}
+
+func (oc *ObservedController) Resolve(containerID string) error {
+	if r, ok := oc.ResourceController.(ContainerResolver); ok {
+		return r.Resolve(containerID)
+	}
+	return nil
+}
+
+func (oc *ObservedController) UpdateCPUQuota(ctx context.Context, containerID string, cpuQuota int64) error {
+	start := time.Now()
+	err := oc.ResourceController.UpdateCPUQuota(ctx, containerID, cpuQuota)
+	oc.observe(ctx, "cpu", containerID, start, err)
+	return err
+}
+
+func (oc *ObservedController) UpdateMemory(ctx context.Context, containerID string, memory int64) error {
+	start := time.Now()
+	err := oc.ResourceController.UpdateMemory(ctx, containerID, memory)
+	oc.observe(ctx, "memory", containerID, start, err)
+	return err
+}
+
+func (oc *ObservedController) UpdateCpuset(ctx context.Context, containerID string, cpus string) error {
+	start := time.Now()
+	err := oc.ResourceController.UpdateCpuset(ctx, containerID, cpus)
+	oc.observe(ctx, "cpuset", containerID, start, err)
+	return err
+}
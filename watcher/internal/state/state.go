@@ -20,6 +20,8 @@
 
 package state
 
+import "time"
+
 /*
 	Struct for tracking function container/s
 	state from inside watcher.
@@ -28,12 +30,33 @@ type FunctionState struct {
 	Container     string // TO DO: Solve Openwhisk autoscaling problem
 	Quotas        int64
 	DesiredQuotas int64
+	Memory        int64 // Bytes, 0 when memory is left untouched
+	DesiredMemory int64
+	InitialMemory int64  // Container limit before first memory request
+	Cpuset        string // Dedicated cores, empty when not pinned
+	Priority      int64  // Allocation policy inputs, from latest request
+	Weight        int64
+	Slack         int64
+	Preempted     bool  // Held at lower bound by higher priority classes
+	Preemptions   int64 // Times function got preempted
 	Requests      RequestsInfo
 }
 
 type RequestsInfo struct {
 	Current uint64
 	Active  map[uint64]int64
+	Memory  map[uint64]int64 // Memory demand of every request asking for it
+	Pinned  map[uint64]int64 // Dedicated cores demanded by pinning requests
+	Leases  map[uint64]Lease // Grant of every request, reclaimed on expiry
+}
+
+/*
+	Time bounded grant of a request.
+	Renewal extends it by TTL.
+*/
+type Lease struct {
+	Expiry time.Time
+	TTL    time.Duration
 }
 
 func NewFunctionState(container string) *FunctionState {
@@ -42,6 +65,9 @@ func NewFunctionState(container string) *FunctionState {
 		Requests: RequestsInfo{
 			Current: 0,
 			Active:  map[uint64]int64{},
+			Memory:  map[uint64]int64{},
+			Pinned:  map[uint64]int64{},
+			Leases:  map[uint64]Lease{},
 		},
 	}
 }
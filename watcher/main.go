@@ -21,43 +21,117 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/john98nf/SequenceClock/watcher/internal/conflicts"
+	"github.com/john98nf/SequenceClock/watcher/pkg/auth"
+	"github.com/john98nf/SequenceClock/watcher/pkg/certs"
 	wrq "github.com/john98nf/SequenceClock/watcher/pkg/request"
+	"github.com/john98nf/SequenceClock/watcher/pkg/request/bind"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
-	hostIP           string = os.Getenv("HOST_IP")
-	conflictResolver conflicts.ConflictResolver
-	cores            int64
+	hostIP             string = os.Getenv("HOST_IP")
+	containerRuntime   string = os.Getenv("CONTAINER_RUNTIME")
+	runtimeEndpoint    string = os.Getenv("RUNTIME_ENDPOINT")
+	resourceController string = os.Getenv("RESOURCE_CONTROLLER")
+	nodeMemory         string = os.Getenv("NODE_MEMORY")
+	allocationPolicy   string = os.Getenv("ALLOCATION_POLICY")
+	allocationPolicies string = os.Getenv("ALLOCATION_POLICIES")
+	nodeName           string = os.Getenv("NODE_NAME")
+	registryCheckpoint string = os.Getenv("REGISTRY_CHECKPOINT")
+	exactLambda        string = os.Getenv("EXACT_LAMBDA")
+	lambdaCheck        string = os.Getenv("LAMBDA_CHECK_INTERVAL")
+	streamPort         string = os.Getenv("STREAM_PORT")
+	conflictResolver   *conflicts.ConflictResolver
+	guard              *auth.Guard
+	certStore          *certs.Store
+	cores              int64
+	memory             int64
 )
 
 func main() {
+	setupTracing()
+	guard = auth.MustFromEnv()
+	guard.ClientCheck = clientCertified
+	certStore = certs.FromEnv()
 	router := gin.New()
 
-	apiWatcher := router.Group("/api")
+	// GET Request http://localhost:8080/metrics
+	router.GET("/metrics", guard.Require(auth.ROLE_METRICS, auth.ROLE_ADMIN), gin.WrapH(promhttp.Handler()))
+
+	apiWatcher := router.Group("/api", traced)
 	{
 		// GET Request http://localhost:8080/api/check
 		apiWatcher.GET("/check", check)
 		// GET Request http://localhost:8080/api/function/{name}
-		apiWatcher.GET("/function/:name", getContainer)
+		apiWatcher.GET("/function/:name", guard.Require(auth.ROLE_ADMIN), getContainer)
 		// POST Request http://localhost:8080/api/function/requestResources
-		apiWatcher.POST("/function/requestResources", requestHandler)
+		apiWatcher.POST("/function/requestResources", guard.Require(auth.ROLE_SUPREME, auth.ROLE_ADMIN), requestHandler)
 		// POST ResetRequest http://localhost:8080/api/function/resetRequest
-		apiWatcher.POST("/function/resetRequest", resetHandler)
+		apiWatcher.POST("/function/resetRequest", guard.Require(auth.ROLE_SUPREME, auth.ROLE_ADMIN), resetHandler)
+		// POST RenewRequest http://localhost:8080/api/function/renewLease
+		apiWatcher.POST("/function/renewLease", guard.Require(auth.ROLE_SUPREME, auth.ROLE_ADMIN), renewHandler)
 		// GET ResetRequest http://localhost:8080/api/registry
-		apiWatcher.GET("/registry", getRegistry)
+		apiWatcher.GET("/registry", guard.Require(auth.ROLE_SUPREME, auth.ROLE_ADMIN), getRegistry)
 	}
 	cores = findNodeCores()
 	log.Printf("Number of available cores: %d\n", cores)
-	conflictResolver = conflicts.NewConflictResolver(cores)
-	router.Run(":8080")
+	memory = findNodeMemory()
+	log.Printf("Memory available to actions: %d MB\n", memory/conflicts.MEGABYTE)
+	policy := findAllocationPolicy()
+	log.Printf("Allocation policy: %s\n", policy)
+	conflictResolver = conflicts.NewConflictResolver(conflicts.Config{
+		Cores:              cores,
+		Memory:             memory,
+		Runtime:            containerRuntime,
+		RuntimeEndpoint:    runtimeEndpoint,
+		ResourceController: resourceController,
+		Policy:             policy,
+		Checkpoint:         registryCheckpoint,
+		ExactLambda:        exactLambda == "true",
+		LambdaCheck:        findLambdaCheckInterval(),
+		Observer:           onResourceUpdate,
+		Events:             events.publish,
+	})
+	registerMetrics(prometheus.DefaultRegisterer, conflictResolver)
+	serveStream()
+	if err := certStore.ListenAndServe(":8080", router); err != nil {
+		panic(err)
+	}
+}
+
+/*
+	Reports container resource updates
+	to both metrics and traces.
+*/
+func onResourceUpdate(ctx context.Context, operation, containerID string, start time.Time, err error) {
+	observeUpdate(operation, time.Since(start), err)
+	traceUpdate(ctx, operation, containerID, start, err)
+}
+
+/*
+	Under mutual TLS, rejects calls
+	without client certificate.
+*/
+func clientCertified(r *http.Request) error {
+	if certStore.MutualTLS() && !certs.ClientCertified(r) {
+		return certs.ErrNoClientCertificate
+	}
+	return nil
 }
 
 /*
@@ -74,35 +148,96 @@ func check(c *gin.Context) {
 */
 func resetHandler(c *gin.Context) {
 	var rs wrq.ResetRequest
-	if err := c.ShouldBind(&rs); err != nil {
+	if err := bind.Message(c, &rs); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := conflictResolver.RemoveFromRegistry(rs); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if status, err := reset(c.Request.Context(), rs); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "ok"})
+}
+
+func reset(ctx context.Context, rs wrq.ResetRequest) (int, error) {
+	tagRequest(ctx, rs.Function, rs.ID)
+	if err := conflictResolver.RemoveFromRegistry(detach(ctx), rs); errors.Is(err, conflicts.ErrRequestNotFound) {
+		return http.StatusNotFound, err
+	} else if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+/*
+	Extends lease of request with specified id.
+	Unrenewed requests are reset once
+	their lease expires.
+*/
+func renewHandler(c *gin.Context) {
+	var rs wrq.ResetRequest
+	if err := bind.Message(c, &rs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if status, err := renew(rs); status == http.StatusNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Not Found"})
+		return
+	} else if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "ok"})
 }
 
+func renew(rs wrq.ResetRequest) (int, error) {
+	if err := conflictResolver.RenewLease(rs); errors.Is(err, conflicts.ErrLeaseNotFound) {
+		return http.StatusNotFound, nil
+	} else if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
 /*
 	Provides or Removes resources from openwhisk function
 	docker container resources.
 */
 func requestHandler(c *gin.Context) {
 	var req wrq.Request
-	if err := c.ShouldBind(&req); err != nil {
+	if err := bind.Message(c, &req); err != nil {
 		c.String(http.StatusBadRequest, err.Error())
 		return
 	}
-	if found, err := conflictResolver.UpdateRegistry(&req); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	} else if !found {
+	if status, grant, err := allocate(c.Request.Context(), &req); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+	} else if grant == nil {
 		c.JSON(http.StatusNotFound, gin.H{"message": "Not Found"})
-		return
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "ok", "grant": grant})
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "ok"})
+}
+
+/*
+	Core of requestHandler, shared with the
+	stream channel. A nil grant without error
+	means the function has no container.
+*/
+func allocate(ctx context.Context, req *wrq.Request) (int, *conflicts.Grant, error) {
+	tagRequest(ctx, req.Function, req.ID)
+	grant, err := conflictResolver.UpdateRegistry(detach(ctx), req)
+	if errors.Is(err, conflicts.ErrCoresUnavailable) {
+		return http.StatusConflict, nil, err
+	} else if errors.Is(err, conflicts.ErrIndexNotSynced) {
+		// Retryable, containers are listed shortly after startup
+		return http.StatusServiceUnavailable, nil, err
+	} else if err != nil {
+		return http.StatusInternalServerError, nil, err
+	} else if grant == nil {
+		return http.StatusNotFound, nil, nil
+	}
+	grant.Node = hostIP
+	return http.StatusOK, grant, nil
 }
 
 /*
@@ -111,7 +246,12 @@ func requestHandler(c *gin.Context) {
 */
 func getRegistry(c *gin.Context) {
 	reg := conflictResolver.ExportRegistry()
-	c.JSON(http.StatusOK, gin.H{"registry": reg})
+	c.JSON(http.StatusOK, gin.H{
+		"registry":      reg,
+		"policy":        conflictResolver.Policy.Name(),
+		"reapedLeases":  conflictResolver.ReapedLeases(),
+		"lambdaResyncs": conflictResolver.LambdaResyncs(),
+	})
 }
 
 /*
@@ -153,3 +293,70 @@ func findNodeCores() int64 {
 	}
 	return n
 }
+
+/*
+	Memory (in bytes) arbitrated among action containers.
+	Taken from NODE_MEMORY (in MB) or else
+	from total memory of the host.
+*/
+func findNodeMemory() int64 {
+	if nodeMemory != "" {
+		mb, err := strconv.ParseInt(nodeMemory, 10, 64)
+		if err != nil {
+			panic(fmt.Errorf("invalid NODE_MEMORY '%s': %v", nodeMemory, err))
+		}
+		return mb * conflicts.MEGABYTE
+	}
+	dat, err := ioutil.ReadFile("/proc/meminfo")
+	if err != nil {
+		panic(err)
+	}
+	for _, line := range strings.Split(string(dat), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				panic(err)
+			}
+			return kb * 1024
+		}
+	}
+	panic(fmt.Errorf("MemTotal missing from /proc/meminfo"))
+}
+
+/*
+	Allocation policy of this node, from its entry in
+	ALLOCATION_POLICIES (node=policy,... with nodes
+	given by name or host ip), else ALLOCATION_POLICY.
+*/
+func findAllocationPolicy() string {
+	for _, entry := range strings.Split(allocationPolicies, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 {
+			panic(fmt.Errorf("invalid ALLOCATION_POLICIES entry '%s'", entry))
+		}
+		node := strings.TrimSpace(kv[0])
+		if node != "" && (node == nodeName || node == hostIP) {
+			return strings.TrimSpace(kv[1])
+		}
+	}
+	return allocationPolicy
+}
+
+/*
+	Period of incremental λ self-check.
+	Zero selects the resolver default.
+*/
+func findLambdaCheckInterval() time.Duration {
+	if lambdaCheck == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(lambdaCheck)
+	if err != nil {
+		panic(fmt.Errorf("invalid LAMBDA_CHECK_INTERVAL '%s': %v", lambdaCheck, err))
+	}
+	return d
+}
@@ -0,0 +1,142 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"time"
+
+	"github.com/john98nf/SequenceClock/watcher/internal/conflicts"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const METRICS_NAMESPACE string = "sequence_clock_watcher"
+
+var (
+	updateDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "resource_update_duration_seconds",
+		Help:      "Duration of container resource updates.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12),
+	}, []string{"operation"})
+	updateErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "resource_update_errors_total",
+		Help:      "Failed container resource updates.",
+	}, []string{"operation"})
+	openChannels = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "stream_channels",
+		Help:      "Open stream channels of watcher supreme.",
+	})
+	streamCommands = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "stream_commands_total",
+		Help:      "Commands received over stream channels.",
+	}, []string{"command", "status"})
+	droppedEvents = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "stream_dropped_events_total",
+		Help:      "Events not pushed because a channel fell behind.",
+	})
+	grantedQuotaDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(METRICS_NAMESPACE, "", "granted_cpu_quota"),
+		"CPU quotas applied to function container.",
+		[]string{"function"}, nil)
+	desiredQuotaDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(METRICS_NAMESPACE, "", "desired_cpu_quota"),
+		"CPU quotas desired by function requests.",
+		[]string{"function"}, nil)
+	activeRequestsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(METRICS_NAMESPACE, "", "active_requests"),
+		"Requests granted to function.",
+		[]string{"function"}, nil)
+	preemptedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(METRICS_NAMESPACE, "", "preempted"),
+		"Whether function is held at lower bound by higher priority classes.",
+		[]string{"function"}, nil)
+	lambdaDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(METRICS_NAMESPACE, "", "lambda"),
+		"Node CPU capacity over sum of desired quotas.",
+		nil, nil)
+	reapedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(METRICS_NAMESPACE, "", "reaped_leases_total"),
+		"Requests reclaimed because their lease expired.",
+		nil, nil)
+	resyncsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(METRICS_NAMESPACE, "", "lambda_resyncs_total"),
+		"Incremental lambda drifts corrected.",
+		nil, nil)
+)
+
+/*
+	Exposes conflict resolver state
+	at scrape time.
+*/
+type resolverCollector struct {
+	cr *conflicts.ConflictResolver
+}
+
+func (rc resolverCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- grantedQuotaDesc
+	ch <- desiredQuotaDesc
+	ch <- activeRequestsDesc
+	ch <- preemptedDesc
+	ch <- lambdaDesc
+	ch <- reapedDesc
+	ch <- resyncsDesc
+}
+
+func (rc resolverCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, u := range rc.cr.Usage() {
+		var preempted float64
+		if u.Preempted {
+			preempted = 1
+		}
+		ch <- prometheus.MustNewConstMetric(grantedQuotaDesc, prometheus.GaugeValue, float64(u.Quotas), u.Function)
+		ch <- prometheus.MustNewConstMetric(desiredQuotaDesc, prometheus.GaugeValue, float64(u.DesiredQuotas), u.Function)
+		ch <- prometheus.MustNewConstMetric(activeRequestsDesc, prometheus.GaugeValue, float64(u.Requests), u.Function)
+		ch <- prometheus.MustNewConstMetric(preemptedDesc, prometheus.GaugeValue, preempted, u.Function)
+	}
+	ch <- prometheus.MustNewConstMetric(lambdaDesc, prometheus.GaugeValue, rc.cr.Lambda())
+	ch <- prometheus.MustNewConstMetric(reapedDesc, prometheus.CounterValue, float64(rc.cr.ReapedLeases()))
+	ch <- prometheus.MustNewConstMetric(resyncsDesc, prometheus.CounterValue, float64(rc.cr.LambdaResyncs()))
+}
+
+/*
+	Records a container resource update.
+	Passed to conflict resolver as observer.
+*/
+func observeUpdate(operation string, elapsed time.Duration, err error) {
+	updateDuration.WithLabelValues(operation).Observe(elapsed.Seconds())
+	if err != nil {
+		updateErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+/*
+	Registers watcher metrics
+	with reg, the default registry
+	outside of tests.
+*/
+func registerMetrics(reg prometheus.Registerer, cr *conflicts.ConflictResolver) {
+	reg.MustRegister(updateDuration, updateErrors, openChannels, streamCommands, droppedEvents, resolverCollector{cr})
+}
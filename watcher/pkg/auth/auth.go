@@ -0,0 +1,171 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	ROLE_ADMIN           string = "admin"      // Sequence admins, deploy sequences and read every api
+	ROLE_CONTROLLER      string = "controller" // Sequence controllers, request resources and report executions
+	ROLE_SUPREME         string = "supreme"    // Watcher supreme, drives watchers
+	ROLE_METRICS         string = "metrics"    // Metrics scrapers, read /metrics only
+	AUTHORIZATION_HEADER string = "Authorization"
+	BEARER_SCHEME        string = "Bearer "
+	ADMIN_TOKEN_ENV      string = "AUTH_ADMIN_TOKEN"
+	CONTROLLER_TOKEN_ENV string = "AUTH_CONTROLLER_TOKEN"
+	SUPREME_TOKEN_ENV    string = "AUTH_SUPREME_TOKEN"
+	METRICS_TOKEN_ENV    string = "AUTH_METRICS_TOKEN"
+	TOKEN_REVIEW_ENV     string = "AUTH_TOKEN_REVIEW" // role=serviceaccount,... reviewed by kubernetes
+)
+
+var (
+	ErrUnauthenticated = errors.New("missing or invalid token")
+	ErrForbidden       = errors.New("call not allowed")
+)
+
+/*
+	Maps a bearer token to a role.
+	Unknown tokens give an empty role.
+*/
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (string, error)
+}
+
+/*
+	Authorizes calls by the role of their bearer token.
+	A nil or empty guard lets every call through.
+*/
+type Guard struct {
+	authenticators []Authenticator
+	ClientCheck    func(r *http.Request) error // Run before the token, failures answer 401
+}
+
+func NewGuard(authenticators ...Authenticator) *Guard {
+	return &Guard{authenticators: authenticators}
+}
+
+/*
+	Guard of the static tokens of each role,
+	followed by kubernetes token review when
+	AUTH_TOKEN_REVIEW maps service accounts to roles.
+	Without either, auth is disabled.
+*/
+func FromEnv() (*Guard, error) {
+	g := &Guard{}
+	tokens := StaticTokens{}
+	for env, role := range map[string]string{
+		ADMIN_TOKEN_ENV:      ROLE_ADMIN,
+		CONTROLLER_TOKEN_ENV: ROLE_CONTROLLER,
+		SUPREME_TOKEN_ENV:    ROLE_SUPREME,
+		METRICS_TOKEN_ENV:    ROLE_METRICS,
+	} {
+		if t := os.Getenv(env); t != "" {
+			tokens[t] = role
+		}
+	}
+	if len(tokens) > 0 {
+		g.authenticators = append(g.authenticators, tokens)
+	}
+	if review := os.Getenv(TOKEN_REVIEW_ENV); review != "" {
+		roles, err := ParseRoles(review)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v: %v", TOKEN_REVIEW_ENV, err)
+		}
+		r, err := NewTokenReviewer(roles)
+		if err != nil {
+			return nil, err
+		}
+		g.authenticators = append(g.authenticators, r)
+	}
+	return g, nil
+}
+
+func (g *Guard) Enabled() bool {
+	return g != nil && len(g.authenticators) > 0
+}
+
+/*
+	Returns role of the token carried by header,
+	a value of the Authorization header, if the
+	role is one of roles.
+*/
+func (g *Guard) Authorize(ctx context.Context, header string, roles ...string) (string, error) {
+	if !g.Enabled() {
+		return "", nil
+	}
+	token := BearerToken(header)
+	if token == "" {
+		return "", ErrUnauthenticated
+	}
+	for _, a := range g.authenticators {
+		role, err := a.Authenticate(ctx, token)
+		if err != nil {
+			return "", err
+		} else if role == "" {
+			continue
+		}
+		for _, r := range roles {
+			if r == role {
+				return role, nil
+			}
+		}
+		return role, fmt.Errorf("%w for role %v", ErrForbidden, role)
+	}
+	return "", ErrUnauthenticated
+}
+
+/*
+	Token of an Authorization header value,
+	empty unless it uses the bearer scheme.
+*/
+func BearerToken(header string) string {
+	if !strings.HasPrefix(header, BEARER_SCHEME) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(BEARER_SCHEME):])
+}
+
+func Bearer(token string) string {
+	return BEARER_SCHEME + token
+}
+
+/*
+	HTTP status of an Authorize error.
+	Other errors come from an unreachable
+	token reviewer.
+*/
+func StatusOf(err error) int {
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	default:
+		return http.StatusServiceUnavailable
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errReviewDown = errors.New("token review unreachable")
+
+/*
+	Authenticator failing for token "down".
+*/
+type failing struct{}
+
+func (failing) Authenticate(ctx context.Context, token string) (string, error) {
+	if token == "down" {
+		return "", errReviewDown
+	}
+	return "", nil
+}
+
+func TestAuthorize(t *testing.T) {
+	g := NewGuard(
+		StaticTokens{"admin-token": ROLE_ADMIN, "supreme-token": ROLE_SUPREME},
+		failing{},
+		StaticTokens{"metrics-token": ROLE_METRICS},
+	)
+	tests := []struct {
+		name   string
+		header string
+		roles  []string
+		role   string
+		status int // Of the error, 0 when authorized
+	}{
+		{"allowed role", "Bearer admin-token", []string{ROLE_ADMIN}, ROLE_ADMIN, 0},
+		{"one of roles", "Bearer supreme-token", []string{ROLE_SUPREME, ROLE_ADMIN}, ROLE_SUPREME, 0},
+		{"later authenticator", "Bearer metrics-token", []string{ROLE_METRICS}, ROLE_METRICS, 0},
+		{"other role", "Bearer supreme-token", []string{ROLE_ADMIN}, ROLE_SUPREME, http.StatusForbidden},
+		{"no roles", "Bearer admin-token", nil, ROLE_ADMIN, http.StatusForbidden},
+		{"unknown token", "Bearer guess", []string{ROLE_ADMIN}, "", http.StatusUnauthorized},
+		{"missing header", "", []string{ROLE_ADMIN}, "", http.StatusUnauthorized},
+		{"other scheme", "Basic admin-token", []string{ROLE_ADMIN}, "", http.StatusUnauthorized},
+		{"empty token", "Bearer ", []string{ROLE_ADMIN}, "", http.StatusUnauthorized},
+		{"authenticator failure", "Bearer down", []string{ROLE_ADMIN}, "", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role, err := g.Authorize(context.Background(), tt.header, tt.roles...)
+			if role != tt.role {
+				t.Fatalf("role %q, want %q", role, tt.role)
+			}
+			if tt.status == 0 && err != nil {
+				t.Fatal(err)
+			} else if tt.status != 0 && (err == nil || StatusOf(err) != tt.status) {
+				t.Fatalf("error %v, want status %v", err, tt.status)
+			}
+		})
+	}
+
+	for _, g := range []*Guard{nil, NewGuard()} {
+		if role, err := g.Authorize(context.Background(), "", ROLE_ADMIN); g.Enabled() || role != "" || err != nil {
+			t.Fatalf("disabled guard: %q, %v", role, err)
+		}
+	}
+}
+
+func TestStaticTokens(t *testing.T) {
+	tokens := StaticTokens{"admin-token": ROLE_ADMIN}
+	for token, want := range map[string]string{
+		"admin-token":  ROLE_ADMIN,
+		"admin-token2": "",
+		"admin":        "",
+		"":             "",
+	} {
+		if role, err := tokens.Authenticate(context.Background(), token); role != want || err != nil {
+			t.Errorf("token %q: %q, %v, want %q", token, role, err, want)
+		}
+	}
+}
+
+func setenv(t *testing.T, env map[string]string) {
+	for _, k := range []string{ADMIN_TOKEN_ENV, CONTROLLER_TOKEN_ENV, SUPREME_TOKEN_ENV, METRICS_TOKEN_ENV, TOKEN_REVIEW_ENV} {
+		prev, ok := os.LookupEnv(k)
+		if v, set := env[k]; set {
+			os.Setenv(k, v)
+		} else {
+			os.Unsetenv(k)
+		}
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(k, prev)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	setenv(t, nil)
+	if g, err := FromEnv(); err != nil || g.Enabled() {
+		t.Fatalf("guard without tokens enabled: %v", err)
+	}
+
+	setenv(t, map[string]string{
+		ADMIN_TOKEN_ENV:      "a",
+		CONTROLLER_TOKEN_ENV: "c",
+		SUPREME_TOKEN_ENV:    "s",
+		METRICS_TOKEN_ENV:    "m",
+	})
+	g, err := FromEnv()
+	if err != nil || !g.Enabled() {
+		t.Fatalf("guard with tokens disabled: %v", err)
+	}
+	for token, role := range map[string]string{"a": ROLE_ADMIN, "c": ROLE_CONTROLLER, "s": ROLE_SUPREME, "m": ROLE_METRICS} {
+		if got, err := g.Authorize(context.Background(), Bearer(token), role); got != role || err != nil {
+			t.Errorf("token %v: %q, %v, want %v", token, got, err, role)
+		}
+	}
+
+	setenv(t, map[string]string{TOKEN_REVIEW_ENV: "root=kube-system:default"})
+	if _, err := FromEnv(); err == nil {
+		t.Fatal("unknown role accepted")
+	}
+}
+
+func TestParseRoles(t *testing.T) {
+	roles, err := ParseRoles("controller=openwhisk:controller, metrics=monitoring:prometheus")
+	want := map[string]string{
+		"system:serviceaccount:openwhisk:controller":  ROLE_CONTROLLER,
+		"system:serviceaccount:monitoring:prometheus": ROLE_METRICS,
+	}
+	if err != nil || !reflect.DeepEqual(roles, want) {
+		t.Fatalf("roles %v, %v", roles, err)
+	}
+	for _, s := range []string{"", "admin", "admin=default", "admin=a:b:c", "root=a:b", "admin=a:b,"} {
+		if _, err := ParseRoles(s); err == nil {
+			t.Errorf("%q accepted", s)
+		}
+	}
+}
+
+/*
+	Kubernetes api reviewing tokens "admin", a service
+	account with a role, "other", a service account
+	without role, and "expired", which it rejects.
+	Token "down" makes it fail.
+*/
+func reviewServer(t *testing.T, reviews *int) *TokenReviewer {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != TOKEN_REVIEWS_PATH || r.Header.Get(AUTHORIZATION_HEADER) != Bearer("reviewer") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		*reviews++
+		var tr tokenReview
+		json.NewDecoder(r.Body).Decode(&tr)
+		switch tr.Spec.Token {
+		case "down":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		case "admin":
+			tr.Status.Authenticated = true
+			tr.Status.User.Username = SERVICE_ACCOUNT + "default:scctl"
+		case "other":
+			tr.Status.Authenticated = true
+			tr.Status.User.Username = SERVICE_ACCOUNT + "default:other"
+		default:
+			tr.Status.Error = "token expired"
+		}
+		json.NewEncoder(w).Encode(tr)
+	}))
+	t.Cleanup(srv.Close)
+	return &TokenReviewer{
+		client: srv.Client(),
+		host:   srv.URL,
+		token:  "reviewer",
+		roles:  map[string]string{SERVICE_ACCOUNT + "default:scctl": ROLE_ADMIN},
+		cache:  make(map[[sha256.Size]byte]review),
+	}
+}
+
+func TestTokenReviewer(t *testing.T) {
+	var reviews int
+	r := reviewServer(t, &reviews)
+	tests := []struct {
+		token string
+		role  string
+		err   bool
+	}{
+		{"admin", ROLE_ADMIN, false},
+		{"other", "", false},
+		{"expired", "", false},
+		{"down", "", true},
+	}
+	for _, tt := range tests {
+		role, err := r.Authenticate(context.Background(), tt.token)
+		if role != tt.role || (err != nil) != tt.err {
+			t.Errorf("token %v: %q, %v, want %q", tt.token, role, err, tt.role)
+		}
+	}
+	if reviews != 4 {
+		t.Fatalf("%v reviews, want 4", reviews)
+	}
+
+	// Answers are cached, failures are not
+	for _, tt := range tests {
+		r.Authenticate(context.Background(), tt.token)
+	}
+	if reviews != 5 {
+		t.Fatalf("%v reviews, want only the failed one repeated", reviews)
+	}
+
+	g := NewGuard(r)
+	if _, err := g.Authorize(context.Background(), Bearer("expired"), ROLE_ADMIN); StatusOf(err) != http.StatusUnauthorized {
+		t.Fatalf("rejected token: %v", err)
+	}
+	if _, err := g.Authorize(context.Background(), Bearer("down"), ROLE_ADMIN); StatusOf(err) != http.StatusServiceUnavailable {
+		t.Fatalf("failed review: %v", err)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	g := NewGuard(StaticTokens{"admin-token": ROLE_ADMIN, "metrics-token": ROLE_METRICS}, failing{})
+	uncertified := errors.New("no client certificate")
+	tests := []struct {
+		name      string
+		header    string
+		certified bool
+		status    int
+	}{
+		{"allowed", "Bearer admin-token", true, http.StatusOK},
+		{"forbidden", "Bearer metrics-token", true, http.StatusForbidden},
+		{"unauthenticated", "", true, http.StatusUnauthorized},
+		{"review down", "Bearer down", true, http.StatusServiceUnavailable},
+		{"uncertified", "Bearer admin-token", false, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g.ClientCheck = func(r *http.Request) error {
+				if !tt.certified {
+					return uncertified
+				}
+				return nil
+			}
+			router := gin.New()
+			router.GET("/", g.Require(ROLE_ADMIN), func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(AUTHORIZATION_HEADER, tt.header)
+			router.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status %v, want %v", w.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				var res struct{ Error string }
+				if json.Unmarshal(w.Body.Bytes(), &res) != nil || res.Error == "" {
+					t.Fatalf("answer %q without error", w.Body)
+				}
+			}
+			if !tt.certified && !strings.Contains(w.Body.String(), uncertified.Error()) {
+				t.Fatalf("answer %q, want the client check error", w.Body)
+			}
+		})
+	}
+
+	var disabled *Guard
+	router := gin.New()
+	router.GET("/", disabled.Require(ROLE_ADMIN), func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("disabled guard answered %v", w.Code)
+	}
+}
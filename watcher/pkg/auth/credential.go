@@ -0,0 +1,94 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package auth
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const CREDENTIAL_REFRESH time.Duration = time.Minute
+
+/*
+	Token a client presents. Taken from an env
+	variable, or from the file named by the same
+	variable suffixed with _FILE, such as a projected
+	service account token, which is re-read as it rotates.
+*/
+type Credential struct {
+	file  string
+	mu    sync.Mutex
+	token string
+	read  time.Time
+}
+
+/*
+	Returns nil, presenting no token,
+	when neither variable is set.
+*/
+func CredentialFromEnv(name string) *Credential {
+	if file := os.Getenv(name + "_FILE"); file != "" {
+		return &Credential{file: file}
+	}
+	if token := os.Getenv(name); token != "" {
+		return &Credential{token: token}
+	}
+	return nil
+}
+
+func NewCredential(token string) *Credential {
+	if token == "" {
+		return nil
+	}
+	return &Credential{token: token}
+}
+
+func (c *Credential) Token() string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file != "" && time.Since(c.read) > CREDENTIAL_REFRESH {
+		if dat, err := ioutil.ReadFile(c.file); err != nil {
+			log.Println("Credential not refreshed:", err)
+		} else {
+			c.token = string(bytes.TrimSpace(dat))
+		}
+		c.read = time.Now()
+	}
+	return c.token
+}
+
+/*
+	Authorization header value of the
+	credential, empty without token.
+*/
+func (c *Credential) Header() string {
+	if t := c.Token(); t != "" {
+		return Bearer(t)
+	}
+	return ""
+}
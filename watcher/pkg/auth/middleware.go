@@ -0,0 +1,61 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package auth
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Guard of an api, configured by AUTH_*
+	variables, panicking when they are invalid.
+	Disabled when none is set.
+*/
+func MustFromEnv() *Guard {
+	g, err := FromEnv()
+	if err != nil {
+		panic(err)
+	}
+	if !g.Enabled() {
+		log.Println("Authentication disabled, no AUTH_* token configured")
+	}
+	return g
+}
+
+/*
+	Gin middleware rejecting calls
+	without a token of one of roles.
+*/
+func (g *Guard) Require(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if g != nil && g.ClientCheck != nil {
+			if err := g.ClientCheck(c.Request); err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if _, err := g.Authorize(c.Request.Context(), c.GetHeader(AUTHORIZATION_HEADER), roles...); err != nil {
+			c.AbortWithStatusJSON(StatusOf(err), gin.H{"error": err.Error()})
+		}
+	}
+}
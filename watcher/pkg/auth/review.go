@@ -0,0 +1,203 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SERVICE_ACCOUNT_DIR string        = "/var/run/secrets/kubernetes.io/serviceaccount"
+	TOKEN_REVIEWS_PATH  string        = "/apis/authentication.k8s.io/v1/tokenreviews"
+	SERVICE_ACCOUNT     string        = "system:serviceaccount:"
+	REVIEW_TIMEOUT      time.Duration = 5 * time.Second
+	REVIEW_CACHE_TTL    time.Duration = time.Minute
+	REVIEW_CACHE_SIZE   int           = 1024
+)
+
+/*
+	TokenReview object of authentication.k8s.io/v1
+	api, limited to fields used by the reviewer.
+*/
+type tokenReview struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Token string `json:"token"`
+	} `json:"spec"`
+	Status struct {
+		Authenticated bool `json:"authenticated"`
+		User          struct {
+			Username string `json:"username"`
+		} `json:"user"`
+		Error string `json:"error,omitempty"`
+	} `json:"status"`
+}
+
+type review struct {
+	role    string
+	expires time.Time
+}
+
+/*
+	Authenticates kubernetes service account tokens
+	through the TokenReview api, with the pod service
+	account, which needs system:auth-delegator.
+	Reviews are cached, keyed by token hash.
+*/
+type TokenReviewer struct {
+	client *http.Client
+	host   string
+	token  string
+	roles  map[string]string // By username
+	mu     sync.Mutex
+	cache  map[[sha256.Size]byte]review
+}
+
+/*
+	Parses "role=namespace:serviceaccount,..." into roles
+	by service account username.
+*/
+func ParseRoles(s string) (map[string]string, error) {
+	roles := map[string]string{}
+	for _, item := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 || strings.Count(kv[1], ":") != 1 {
+			return nil, fmt.Errorf("'%v' is not role=namespace:serviceaccount", item)
+		}
+		switch kv[0] {
+		case ROLE_ADMIN, ROLE_CONTROLLER, ROLE_SUPREME, ROLE_METRICS:
+		default:
+			return nil, fmt.Errorf("unknown role '%v'", kv[0])
+		}
+		roles[SERVICE_ACCOUNT+kv[1]] = kv[0]
+	}
+	return roles, nil
+}
+
+/*
+	Creates a token reviewer from in-cluster
+	service account configuration.
+*/
+func NewTokenReviewer(roles map[string]string) (*TokenReviewer, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("token review needs to run inside kubernetes")
+	}
+	token, err := ioutil.ReadFile(SERVICE_ACCOUNT_DIR + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(SERVICE_ACCOUNT_DIR + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid service account ca")
+	}
+	return &TokenReviewer{
+		client: &http.Client{
+			Timeout:   REVIEW_TIMEOUT,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		host:  "https://" + net.JoinHostPort(host, port),
+		token: string(bytes.TrimSpace(token)),
+		roles: roles,
+		cache: make(map[[sha256.Size]byte]review),
+	}, nil
+}
+
+func (r *TokenReviewer) Authenticate(ctx context.Context, token string) (string, error) {
+	key := sha256.Sum256([]byte(token))
+	r.mu.Lock()
+	cached, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.role, nil
+	}
+	username, err := r.review(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	role := r.roles[username]
+	r.mu.Lock()
+	if len(r.cache) >= REVIEW_CACHE_SIZE {
+		r.cache = make(map[[sha256.Size]byte]review)
+	}
+	r.cache[key] = review{role: role, expires: time.Now().Add(REVIEW_CACHE_TTL)}
+	r.mu.Unlock()
+	return role, nil
+}
+
+/*
+	Returns username of token,
+	empty if kubernetes rejects it.
+*/
+func (r *TokenReviewer) review(ctx context.Context, token string) (string, error) {
+	tr := tokenReview{APIVersion: "authentication.k8s.io/v1", Kind: "TokenReview"}
+	tr.Spec.Token = token
+	body, err := json.Marshal(tr)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.host+TOKEN_REVIEWS_PATH, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(AUTHORIZATION_HEADER, Bearer(r.token))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("token review api returned %v: %s", resp.StatusCode, data)
+	}
+	res := tokenReview{}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return "", err
+	}
+	if !res.Status.Authenticated {
+		return "", nil
+	}
+	return res.Status.User.Username, nil
+}
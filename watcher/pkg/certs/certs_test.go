@@ -0,0 +1,296 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key := newKey(t)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sequence-clock-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &testCA{cert: cert, key: key, dir: t.TempDir()}
+	writePEM(t, ca.path("ca.crt"), "CERTIFICATE", der)
+	return ca
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func writePEM(t *testing.T, path, kind string, der []byte) {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (ca *testCA) path(name string) string {
+	return filepath.Join(ca.dir, name)
+}
+
+/*
+	Issues a certificate for 127.0.0.1 and dnsName
+	with the given usage, returning the Files of
+	it and the CA.
+*/
+func (ca *testCA) issue(t *testing.T, name, dnsName string, usage x509.ExtKeyUsage) Files {
+	key := newKey(t)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     []string{dnsName},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := Files{Cert: ca.path(name + ".crt"), Key: ca.path(name + ".key"), CA: ca.path("ca.crt")}
+	writePEM(t, files.Cert, "CERTIFICATE", der)
+	writePEM(t, files.Key, "PRIVATE KEY", keyDer)
+	return files
+}
+
+func newTestStore(t *testing.T, files Files) *Store {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s, err := NewStore(ctx, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+/*
+	Serves over cfg on a local port, answering
+	whether the client presented a certificate.
+*/
+func serve(t *testing.T, cfg *tls.Config) string {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, ClientCertified(r))
+		}),
+		// Refused handshakes are expected
+		ErrorLog: log.New(ioutil.Discard, "", 0),
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return "https://" + ln.Addr().String()
+}
+
+func get(client *http.Client, url string) (string, error) {
+	client.Timeout = 5 * time.Second
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	server := newTestStore(t, ca.issue(t, "server", "watcher.local", x509.ExtKeyUsageServerAuth))
+	client := newTestStore(t, ca.issue(t, "client", "supreme.local", x509.ExtKeyUsageClientAuth))
+	caOnly := newTestStore(t, Files{CA: ca.path("ca.crt")})
+	if !server.MutualTLS() || caOnly.MutualTLS() {
+		t.Fatal("mutual TLS needs both a certificate and a CA")
+	}
+	url := serve(t, server.ServerConfig(server.ClientAuth()))
+
+	if got, err := get(client.HTTPClient(false), url); err != nil || got != "true" {
+		t.Fatalf("client with certificate: got %q, %v", got, err)
+	}
+	// Probes without certificate still reach the server,
+	// routes refuse them through ClientCertified.
+	if got, err := get(caOnly.HTTPClient(false), url); err != nil || got != "false" {
+		t.Fatalf("client without certificate: got %q, %v", got, err)
+	}
+
+	other := newTestCA(t)
+	stranger := newTestStore(t, Files{
+		Cert: other.issue(t, "client", "supreme.local", x509.ExtKeyUsageClientAuth).Cert,
+		Key:  other.path("client.key"),
+		CA:   ca.path("ca.crt"),
+	})
+	if _, err := get(stranger.HTTPClient(false), url); err == nil {
+		t.Fatal("client certificate of another CA accepted")
+	}
+	// A server certificate does not authenticate clients
+	if _, err := get(newTestStore(t, ca.issue(t, "peer", "watcher.local", x509.ExtKeyUsageServerAuth)).HTTPClient(false), url); err == nil {
+		t.Fatal("server certificate accepted as client certificate")
+	}
+}
+
+func TestRequiredClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	server := newTestStore(t, ca.issue(t, "server", "watcher.local", x509.ExtKeyUsageServerAuth))
+	client := newTestStore(t, ca.issue(t, "client", "supreme.local", x509.ExtKeyUsageClientAuth))
+	url := serve(t, server.ServerConfig(tls.RequireAndVerifyClientCert))
+
+	if got, err := get(client.HTTPClient(false), url); err != nil || got != "true" {
+		t.Fatalf("client with certificate: got %q, %v", got, err)
+	}
+	if _, err := get(newTestStore(t, Files{CA: ca.path("ca.crt")}).HTTPClient(false), url); err == nil {
+		t.Fatal("client without certificate accepted")
+	}
+}
+
+func TestServerVerification(t *testing.T) {
+	ca := newTestCA(t)
+	server := newTestStore(t, ca.issue(t, "server", "watcher.local", x509.ExtKeyUsageServerAuth))
+	url := serve(t, server.ServerConfig(tls.NoClientCert))
+	client := newTestStore(t, Files{CA: ca.path("ca.crt")})
+
+	if _, err := get(client.HTTPClient(true), url); err != nil {
+		t.Fatal("server certificate naming 127.0.0.1 refused:", err)
+	}
+	named := client.HTTPClient(true)
+	named.Transport.(*http.Transport).TLSClientConfig.ServerName = "supreme.local"
+	if _, err := get(named, url); err == nil {
+		t.Fatal("server certificate accepted for another name")
+	}
+	// Peers addressed by pod IP skip the name check
+	unnamed := client.HTTPClient(false)
+	unnamed.Transport.(*http.Transport).TLSClientConfig.ServerName = "supreme.local"
+	if _, err := get(unnamed, url); err != nil {
+		t.Fatal("server certificate refused without name check:", err)
+	}
+
+	other := newTestCA(t)
+	if _, err := get(newTestStore(t, Files{CA: other.path("ca.crt")}).HTTPClient(false), url); err == nil {
+		t.Fatal("server certificate of another CA accepted")
+	}
+	if _, err := get(http.DefaultClient, url); err == nil {
+		t.Fatal("server certificate accepted against system roots")
+	}
+}
+
+func TestRotation(t *testing.T) {
+	ca := newTestCA(t)
+	files := ca.issue(t, "server", "watcher.local", x509.ExtKeyUsageServerAuth)
+	server := newTestStore(t, files)
+	url := serve(t, server.ServerConfig(tls.NoClientCert))
+	client := newTestStore(t, Files{CA: ca.path("ca.crt")})
+	if _, err := get(client.HTTPClient(false), url); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate the CA, and the server certificate with it
+	rotated := newTestCA(t)
+	rotatedFiles := rotated.issue(t, "server", "watcher.local", x509.ExtKeyUsageServerAuth)
+	for from, to := range map[string]string{
+		rotatedFiles.Cert: files.Cert,
+		rotatedFiles.Key:  files.Key,
+		rotatedFiles.CA:   files.CA,
+	} {
+		dat, err := ioutil.ReadFile(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(to, dat, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, s := range []*Store{server, client} {
+		if err := s.load(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// New connections, not the kept one, see the rotation
+	if _, err := get(client.HTTPClient(false), url); err != nil {
+		t.Fatal("rotated certificates refused:", err)
+	}
+	if _, err := get(newTestStore(t, Files{CA: rotated.path("ca.crt")}).HTTPClient(false), url); err != nil {
+		t.Fatal("server still presents the old certificate:", err)
+	}
+}
+
+func TestInvalidFiles(t *testing.T) {
+	ca := newTestCA(t)
+	files := ca.issue(t, "server", "watcher.local", x509.ExtKeyUsageServerAuth)
+	if s, err := NewStore(context.Background(), Files{}); s != nil || err != nil {
+		t.Fatal("no files should mean plain connections")
+	}
+	for name, f := range map[string]Files{
+		"cert without key":  {Cert: files.Cert},
+		"key of cert as CA": {CA: files.Key},
+		"missing CA":        {CA: ca.path("missing.crt")},
+	} {
+		if _, err := NewStore(context.Background(), f); err == nil {
+			t.Errorf("%v: store created", name)
+		}
+	}
+}
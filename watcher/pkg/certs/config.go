@@ -0,0 +1,191 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+)
+
+/*
+	Server side configuration. With clientAuth
+	VerifyClientCertIfGiven or RequireAndVerifyClientCert,
+	client certificates are verified against the
+	current CA pool, so a rotated CA applies
+	without restart.
+*/
+func (s *Store) ServerConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := s.current()
+			if cert == nil {
+				return nil, errors.New("no server certificate configured")
+			}
+			return cert, nil
+		},
+	}
+	switch clientAuth {
+	case tls.VerifyClientCertIfGiven:
+		cfg.ClientAuth = tls.RequestClientCert
+		cfg.VerifyPeerCertificate = s.verifyClient(false)
+	case tls.RequireAndVerifyClientCert:
+		cfg.ClientAuth = tls.RequireAnyClientCert
+		cfg.VerifyPeerCertificate = s.verifyClient(true)
+	}
+	return cfg
+}
+
+func (s *Store) verifyClient(required bool) func([][]byte, [][]*x509.Certificate) error {
+	return func(raw [][]byte, _ [][]*x509.Certificate) error {
+		if len(raw) == 0 {
+			if required {
+				return ErrNoClientCertificate
+			}
+			return nil
+		}
+		_, pool := s.current()
+		return verify(raw, x509.VerifyOptions{
+			Roots:     pool,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+	}
+}
+
+/*
+	Client side configuration, presenting the
+	certificate of the store, if any, and verifying
+	servers against the current CA pool.
+	Without verifyName, any server certificate of the
+	CA is accepted, for peers addressed by pod IP.
+*/
+func (s *Store) ClientConfig(verifyName bool) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert, _ := s.current(); cert != nil {
+				return cert, nil
+			}
+			return &tls.Certificate{}, nil
+		},
+		// Verified by VerifyConnection against the reloaded pool
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			opts := x509.VerifyOptions{KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+			_, opts.Roots = s.current()
+			if verifyName {
+				opts.DNSName = cs.ServerName
+			}
+			raw := make([][]byte, len(cs.PeerCertificates))
+			for i, c := range cs.PeerCertificates {
+				raw[i] = c.Raw
+			}
+			return verify(raw, opts)
+		},
+	}
+}
+
+func verify(raw [][]byte, opts x509.VerifyOptions) error {
+	if len(raw) == 0 {
+		return errors.New("no peer certificate")
+	}
+	certs := make([]*x509.Certificate, len(raw))
+	for i, r := range raw {
+		c, err := x509.ParseCertificate(r)
+		if err != nil {
+			return err
+		}
+		certs[i] = c
+	}
+	opts.Intermediates = x509.NewCertPool()
+	for _, c := range certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+/*
+	Client with the client configuration of the store,
+	or the default client on a nil store.
+*/
+func (s *Store) HTTPClient(verifyName bool) *http.Client {
+	if s == nil {
+		return http.DefaultClient
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = s.ClientConfig(verifyName)
+	return &http.Client{Transport: transport}
+}
+
+/*
+	URL scheme of connections made with the store.
+*/
+func (s *Store) Scheme() string {
+	if s == nil {
+		return "http"
+	}
+	return "https"
+}
+
+/*
+	Whether servers of the store verify client
+	certificates, having both their own and a CA.
+*/
+func (s *Store) MutualTLS() bool {
+	return s != nil && s.files.Cert != "" && s.files.CA != ""
+}
+
+/*
+	Client authentication of servers of the store.
+	Clients without certificate still reach the
+	server, for probes, and are refused by routes
+	checking ClientCertified.
+*/
+func (s *Store) ClientAuth() tls.ClientAuthType {
+	if s.MutualTLS() {
+		return tls.VerifyClientCertIfGiven
+	}
+	return tls.NoClientCert
+}
+
+/*
+	Whether the client of r presented a certificate,
+	verified during the handshake by ServerConfig.
+*/
+func ClientCertified(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.PeerCertificates) > 0
+}
+
+/*
+	Serves handler on addr, over TLS
+	unless the store is nil.
+*/
+func (s *Store) ListenAndServe(addr string, handler http.Handler) error {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	if s == nil {
+		return srv.ListenAndServe()
+	}
+	srv.TLSConfig = s.ServerConfig(s.ClientAuth())
+	return srv.ListenAndServeTLS("", "")
+}
@@ -0,0 +1,183 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	CERT_FILE_ENV   string        = "TLS_CERT_FILE"
+	KEY_FILE_ENV    string        = "TLS_KEY_FILE"
+	CA_FILE_ENV     string        = "TLS_CA_FILE" // Verifies servers, and clients of mTLS servers
+	RELOAD_INTERVAL time.Duration = 30 * time.Second
+)
+
+var ErrNoClientCertificate = errors.New("client certificate required")
+
+/*
+	PEM files of a component. Cert and Key
+	come together, CA alone suits clients.
+*/
+type Files struct {
+	Cert string
+	Key  string
+	CA   string
+}
+
+func FilesFromEnv() Files {
+	return Files{
+		Cert: os.Getenv(CERT_FILE_ENV),
+		Key:  os.Getenv(KEY_FILE_ENV),
+		CA:   os.Getenv(CA_FILE_ENV),
+	}
+}
+
+/*
+	Keeps the certificate and CA pool of Files,
+	reloading them when the files change, as
+	mounted kubernetes secrets do on rotation.
+	Connections opened after a reload use the
+	new certificates, open ones are kept.
+*/
+type Store struct {
+	files    Files
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool // nil verifies against system roots
+	modified time.Time
+}
+
+/*
+	Loads files, reloading them every RELOAD_INTERVAL
+	until ctx is done. Returns nil, meaning plain
+	connections, when no file is given.
+*/
+func NewStore(ctx context.Context, files Files) (*Store, error) {
+	if files == (Files{}) {
+		return nil, nil
+	}
+	if (files.Cert == "") != (files.Key == "") {
+		return nil, fmt.Errorf("%v and %v go together", CERT_FILE_ENV, KEY_FILE_ENV)
+	}
+	s := &Store{files: files}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	go s.watch(ctx)
+	return s, nil
+}
+
+/*
+	Store of the TLS_* files, panicking on
+	invalid ones as other settings do.
+*/
+func FromEnv() *Store {
+	s, err := NewStore(context.Background(), FilesFromEnv())
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (s *Store) load() error {
+	var (
+		cert *tls.Certificate
+		pool *x509.CertPool
+	)
+	if s.files.Cert != "" {
+		c, err := tls.LoadX509KeyPair(s.files.Cert, s.files.Key)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+	if s.files.CA != "" {
+		dat, err := ioutil.ReadFile(s.files.CA)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(dat) {
+			return fmt.Errorf("no certificate found in %v", s.files.CA)
+		}
+	}
+	s.mu.Lock()
+	s.cert, s.pool = cert, pool
+	s.mu.Unlock()
+	return nil
+}
+
+/*
+	Latest modification time among files.
+*/
+func (s *Store) lastModified() time.Time {
+	var latest time.Time
+	for _, f := range []string{s.files.Cert, s.files.Key, s.files.CA} {
+		if f == "" {
+			continue
+		}
+		if info, err := os.Stat(f); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func (s *Store) watch(ctx context.Context) {
+	s.modified = s.lastModified()
+	ticker := time.NewTicker(RELOAD_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		modified := s.lastModified()
+		if !modified.After(s.modified) {
+			continue
+		}
+		// A rotation may be half written,
+		// so failures are retried next tick.
+		if err := s.load(); err != nil {
+			log.Println("Certificates not reloaded:", err)
+			continue
+		}
+		s.modified = modified
+		log.Println("Certificates reloaded")
+	}
+}
+
+func (s *Store) current() (*tls.Certificate, *x509.CertPool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert, s.pool
+}
@@ -0,0 +1,46 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package bind
+
+import (
+	wrq "github.com/john98nf/SequenceClock/watcher/pkg/request"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+/*
+	Binds a protocol message by content type:
+	versioned json, or the legacy form of
+	version 1 sent by older controllers.
+*/
+func Message(c *gin.Context, msg wrq.Message) error {
+	if c.ContentType() != wrq.CONTENT_TYPE_JSON {
+		return c.ShouldBind(msg)
+	}
+	body, err := c.GetRawData()
+	if err != nil {
+		return err
+	}
+	if err := wrq.Unmarshal(body, msg); err != nil {
+		return err
+	}
+	return binding.Validator.ValidateStruct(msg)
+}
@@ -0,0 +1,190 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package bind
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	wrq "github.com/john98nf/SequenceClock/watcher/pkg/request"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Sets every exported field of v, nested
+	structs included, to a distinct non-zero value.
+*/
+func fill(v reflect.Value, next *int64) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if f.Kind() == reflect.Ptr {
+			f.Set(reflect.New(f.Type().Elem()))
+			f = f.Elem()
+		}
+		*next++
+		switch f.Kind() {
+		case reflect.Struct:
+			fill(f, next)
+		case reflect.Int, reflect.Int64:
+			f.SetInt(*next)
+		case reflect.Uint64:
+			f.SetUint(uint64(*next))
+		case reflect.String:
+			f.SetString("f" + strconv.FormatInt(*next, 10))
+		default:
+			panic(fmt.Sprintf("fill: field %v of kind %v", v.Type().Field(i).Name, f.Kind()))
+		}
+	}
+}
+
+/*
+	Legacy form of a message, as sent by controllers
+	predating the json protocol: form tags, with
+	nested structs flattened.
+*/
+func legacyForm(v reflect.Value, params url.Values) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("form"), ",")[0]
+		f := reflect.Indirect(v.Field(i))
+		if name == "-" {
+			continue
+		} else if f.Kind() == reflect.Struct {
+			legacyForm(f, params)
+		} else {
+			params.Set(name, fmt.Sprint(f.Interface()))
+		}
+	}
+}
+
+func bind(contentType string, body []byte, msg wrq.Message) error {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	if contentType != "" {
+		c.Request.Header.Set("Content-Type", contentType)
+	}
+	return Message(c, msg)
+}
+
+func bindRequest(t *testing.T, contentType string, body []byte, msg wrq.Message) {
+	t.Helper()
+	if err := bind(contentType, body, msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/*
+	Every field of every message survives
+	both wire forms the watcher accepts.
+*/
+func TestMessageRoundTrip(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, sent := range []wrq.Message{&wrq.Request{}, &wrq.ResetRequest{}} {
+		var next int64
+		fill(reflect.ValueOf(sent).Elem(), &next)
+		name := reflect.TypeOf(sent).Elem().Name()
+
+		t.Run(name+"/json", func(t *testing.T) {
+			data, err := wrq.Marshal(sent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := reflect.New(reflect.TypeOf(sent).Elem()).Interface().(wrq.Message)
+			bindRequest(t, wrq.CONTENT_TYPE_JSON, data, got)
+			if !reflect.DeepEqual(got, sent) {
+				t.Fatalf("bound %+v, sent %+v", got, sent)
+			}
+		})
+
+		t.Run(name+"/form", func(t *testing.T) {
+			params := url.Values{}
+			legacyForm(reflect.ValueOf(sent).Elem(), params)
+			got := reflect.New(reflect.TypeOf(sent).Elem()).Interface().(wrq.Message)
+			bindRequest(t, wrq.CONTENT_TYPE_FORM, []byte(params.Encode()), got)
+			// Forms carry no version
+			want := reflect.New(reflect.TypeOf(sent).Elem())
+			want.Elem().Set(reflect.ValueOf(sent).Elem())
+			want.Elem().FieldByName("Header").Set(reflect.ValueOf(wrq.Header{}))
+			if !reflect.DeepEqual(got, want.Interface()) {
+				t.Fatalf("bound %+v, sent %+v", got, sent)
+			}
+		})
+	}
+}
+
+func TestJSONRejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name    string
+		body    string
+		version bool // Rejected for its version
+	}{
+		{"missing version", `{"id":3,"function":"f"}`, true},
+		{"version 0", `{"version":0,"id":3,"function":"f"}`, true},
+		{"unknown version", `{"version":2,"id":3,"function":"f"}`, true},
+		{"unknown version and fields", `{"version":2,"function":"f","deadline":5}`, true},
+		{"unknown field", `{"version":1,"id":3,"function":"f","deadline":5}`, false},
+		{"missing function", `{"version":1,"id":3}`, false},
+		{"malformed", `{"version":1,`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rs wrq.ResetRequest
+			err := bind(wrq.CONTENT_TYPE_JSON, []byte(tt.body), &rs)
+			if err == nil {
+				t.Fatalf("bound %+v", rs)
+			}
+			if errors.Is(err, wrq.ErrUnsupportedVersion) != tt.version {
+				t.Fatalf("error %v, version rejected %v", err, tt.version)
+			}
+		})
+	}
+}
+
+/*
+	Forms carry no version and
+	are read as version 1.
+*/
+func TestFormFallback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var rs wrq.ResetRequest
+	if err := bind(wrq.CONTENT_TYPE_FORM, []byte("id=3&function=f&version=2"), &rs); err != nil {
+		t.Fatal(err)
+	}
+	if rs != (wrq.ResetRequest{ID: 3, Function: "f"}) {
+		t.Fatalf("bound %+v", rs)
+	}
+
+	rs = wrq.ResetRequest{}
+	if err := bind(wrq.CONTENT_TYPE_FORM, []byte("id=3"), &rs); err == nil {
+		t.Fatalf("form without function bound %+v", rs)
+	}
+}
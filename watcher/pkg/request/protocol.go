@@ -0,0 +1,88 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	VERSION           int    = 1 // Protocol version sent and accepted
+	CONTENT_TYPE_JSON string = "application/json"
+	CONTENT_TYPE_FORM string = "application/x-www-form-urlencoded" // Legacy, carries no version and is read as version 1
+)
+
+var ErrUnsupportedVersion = errors.New("unsupported protocol version")
+
+/*
+	Protocol header, embedded in every message.
+*/
+type Header struct {
+	Version int `json:"version" form:"-"`
+}
+
+func (h *Header) ProtocolVersion() int {
+	return h.Version
+}
+
+func (h *Header) stamp() {
+	h.Version = VERSION
+}
+
+/*
+	Message exchanged between sequence controller,
+	watcher supreme and watchers.
+*/
+type Message interface {
+	ProtocolVersion() int
+	stamp()
+}
+
+/*
+	Encodes msg as json of the current version.
+*/
+func Marshal(msg Message) ([]byte, error) {
+	msg.stamp()
+	return json.Marshal(msg)
+}
+
+/*
+	Decodes a json message, rejecting versions other
+	than the current one before looking at the rest
+	of the message, and fields unknown to this version.
+*/
+func Unmarshal(data []byte, msg Message) error {
+	var h Header
+	if err := json.Unmarshal(data, &h); err != nil {
+		return err
+	}
+	if h.Version == 0 {
+		return fmt.Errorf("%w: missing version, expected %v", ErrUnsupportedVersion, VERSION)
+	} else if h.Version != VERSION {
+		return fmt.Errorf("%w %v, expected %v", ErrUnsupportedVersion, h.Version, VERSION)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(msg)
+}
@@ -25,9 +25,14 @@ package request
 	and passed to watcher supreme.
 */
 type Request struct {
-	ID       uint64   `form:"id" binding:"omitempty" schema:"-"`
-	Function string   `form:"function" binding:"required" schema:"function"`
-	Metrics  *Metrics `form:"metrics" binding:"required" schema:"metrics"`
+	Header
+	ID          uint64   `form:"id" binding:"omitempty" json:"id"`
+	Function    string   `form:"function" binding:"required" json:"function"`
+	Metrics     *Metrics `form:"metrics" binding:"required" json:"metrics"`
+	Memory      int64    `form:"memory" binding:"omitempty" json:"memory"`           // Desired memory limit in MB, 0 keeps container limit
+	PinnedCores int64    `form:"pinnedCores" binding:"omitempty" json:"pinnedCores"` // Dedicated cores for the request, 0 disables pinning
+	Priority    int64    `form:"priority" binding:"omitempty" json:"priority"`       // Higher served first under priority policy
+	Weight      int64    `form:"weight" binding:"omitempty" json:"weight"`           // Relative share under weighted policy, 0 counts as 1
 }
 
 /*
@@ -35,8 +40,9 @@ type Request struct {
 	by the watcher supreme.
 */
 type ResetRequest struct {
-	ID       uint64 `form:"id" schema:"id"`
-	Function string `form:"function" binding:"required" schema:"function"`
+	Header
+	ID       uint64 `form:"id" json:"id"`
+	Function string `form:"function" binding:"required" json:"function"`
 }
 
 /*
@@ -44,10 +50,10 @@ type ResetRequest struct {
 	to watchers.
 */
 type Metrics struct {
-	Slack                 int64 `form:"slack" schema:"slack"`                // Used by P controller
-	SumOfSlack            int64 `form:"sumOfSlack" schema:"sumOfSlack"`      // Used by I controller
-	PreviousSlack         int64 `form:"previousSlack"schema:"previousSlack"` // Used by D controller
-	ProfiledExecutionTime int64 `form:"profiledExecutionTime" schema:"profiledExecutionTime"`
+	Slack                 int64 `form:"slack" json:"slack"`                 // Used by P controller
+	SumOfSlack            int64 `form:"sumOfSlack" json:"sumOfSlack"`       // Used by I controller
+	PreviousSlack         int64 `form:"previousSlack" json:"previousSlack"` // Used by D controller
+	ProfiledExecutionTime int64 `form:"profiledExecutionTime" json:"profiledExecutionTime"`
 }
 
 /*
@@ -55,6 +61,7 @@ type Metrics struct {
 */
 func NewResetRequest(id uint64, function string) *ResetRequest {
 	return &ResetRequest{
+		Header:   Header{Version: VERSION},
 		ID:       id,
 		Function: function,
 	}
@@ -65,6 +72,7 @@ func NewResetRequest(id uint64, function string) *ResetRequest {
 */
 func NewRequest(f string, m *Metrics) *Request {
 	return &Request{
+		Header:   Header{Version: VERSION},
 		Function: f,
 		Metrics:  m,
 	}
@@ -0,0 +1,130 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	wrq "github.com/john98nf/SequenceClock/watcher/pkg/request"
+)
+
+const (
+	EVENT_CONTAINER_STARTED string = "container_started"
+	EVENT_CONTAINER_STOPPED string = "container_stopped"
+	EVENT_REGISTRY_CHANGED  string = "registry_changed"
+)
+
+/*
+	Command sent by watcher supreme over the
+	channel. Exactly one of Allocate, Reset
+	and Renew is set.
+*/
+type Command struct {
+	Seq      uint64            `json:"seq"` // Echoed by the Ack of the command
+	Allocate *wrq.Request      `json:"allocate,omitempty"`
+	Reset    *wrq.ResetRequest `json:"reset,omitempty"`
+	Renew    *wrq.ResetRequest `json:"renew,omitempty"`
+	Trace    Trace             `json:"trace,omitempty"`
+}
+
+/*
+	W3C trace context and baggage of a command,
+	usable as an opentelemetry TextMapCarrier.
+*/
+type Trace map[string]string
+
+func (t Trace) Get(key string) string {
+	return t[key]
+}
+
+func (t Trace) Set(key, value string) {
+	t[key] = value
+}
+
+func (t Trace) Keys() []string {
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+/*
+	Name of the command, for logs, spans and metrics.
+*/
+func (c *Command) Name() string {
+	switch {
+	case c.Allocate != nil:
+		return "allocate"
+	case c.Reset != nil:
+		return "reset"
+	case c.Renew != nil:
+		return "renew"
+	default:
+		return "unknown"
+	}
+}
+
+func (c *Command) Validate() error {
+	n := 0
+	for _, set := range []bool{c.Allocate != nil, c.Reset != nil, c.Renew != nil} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("command %v carries %v requests, expected one", c.Seq, n)
+	}
+	return nil
+}
+
+/*
+	Outcome of a command. Status is the HTTP
+	status the same call gets over HTTP.
+*/
+type Ack struct {
+	Seq    uint64          `json:"seq"`
+	Status int             `json:"status"`
+	Error  string          `json:"error,omitempty"`
+	Grant  json.RawMessage `json:"grant,omitempty"` // Grant of an allocation
+}
+
+/*
+	Container or registry change
+	pushed by a watcher.
+*/
+type Event struct {
+	Type      string    `json:"type"`
+	Function  string    `json:"function"`
+	Container string    `json:"container,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+/*
+	Message sent by a watcher over the
+	channel, either an Ack or an Event.
+*/
+type Update struct {
+	Ack   *Ack   `json:"ack,omitempty"`
+	Event *Event `json:"event,omitempty"`
+}
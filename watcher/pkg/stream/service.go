@@ -0,0 +1,177 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package stream
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	wrq "github.com/john98nf/SequenceClock/watcher/pkg/request"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	SERVICE_NAME           string        = "sequenceclock.watcher.v1.Watcher"
+	CHANNEL_METHOD         string        = "/" + SERVICE_NAME + "/Channel"
+	VERSION_METADATA       string        = "sequenceclock-protocol-version"
+	AUTHORIZATION_METADATA string        = "authorization" // Bearer token, as the http header
+	KEEPALIVE_INTERVAL     time.Duration = 10 * time.Second
+	KEEPALIVE_TIMEOUT      time.Duration = 5 * time.Second
+)
+
+/*
+	Watcher side of the channel.
+*/
+type WatcherServer interface {
+	Channel(ChannelServer) error
+}
+
+type ChannelServer interface {
+	Send(*Update) error
+	Recv() (*Command, error)
+	grpc.ServerStream
+}
+
+/*
+	Watcher supreme side of the channel.
+*/
+type ChannelClient interface {
+	Send(*Command) error
+	Recv() (*Update, error)
+	grpc.ClientStream
+}
+
+type channelServer struct {
+	grpc.ServerStream
+}
+
+func (s *channelServer) Send(u *Update) error {
+	return s.ServerStream.SendMsg(u)
+}
+
+func (s *channelServer) Recv() (*Command, error) {
+	c := &Command{}
+	if err := s.ServerStream.RecvMsg(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type channelClient struct {
+	grpc.ClientStream
+}
+
+func (c *channelClient) Send(cmd *Command) error {
+	return c.ClientStream.SendMsg(cmd)
+}
+
+func (c *channelClient) Recv() (*Update, error) {
+	u := &Update{}
+	if err := c.ClientStream.RecvMsg(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+/*
+	Rejects channels of other protocol versions.
+*/
+func channelHandler(srv interface{}, stream grpc.ServerStream) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	if v := md.Get(VERSION_METADATA); len(v) != 1 || v[0] != strconv.Itoa(wrq.VERSION) {
+		return status.Errorf(codes.FailedPrecondition, "%v %v, expected %v", wrq.ErrUnsupportedVersion, v, wrq.VERSION)
+	}
+	return srv.(WatcherServer).Channel(&channelServer{stream})
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: SERVICE_NAME,
+	HandlerType: (*WatcherServer)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Channel",
+		Handler:       channelHandler,
+		ServerStreams: true,
+		ClientStreams: true,
+	}},
+}
+
+/*
+	Returns a gRPC server serving srv,
+	accepting keepalive pings of Dial.
+	Nil creds serve plaintext.
+*/
+func NewServer(srv WatcherServer, creds credentials.TransportCredentials) *grpc.Server {
+	opts := []grpc.ServerOption{grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+		MinTime:             KEEPALIVE_INTERVAL / 2,
+		PermitWithoutStream: true,
+	})}
+	if creds != nil {
+		opts = append(opts, grpc.Creds(creds))
+	}
+	s := grpc.NewServer(opts...)
+	s.RegisterService(&serviceDesc, srv)
+	return s
+}
+
+/*
+	Connects to the channel server of a watcher.
+	Connection happens in the background,
+	pings detect dead watchers.
+	Nil creds connect in plaintext.
+*/
+func Dial(target string, creds credentials.TransportCredentials) (*grpc.ClientConn, error) {
+	security := grpc.WithInsecure()
+	if creds != nil {
+		security = grpc.WithTransportCredentials(creds)
+	}
+	return grpc.Dial(target,
+		security,
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                KEEPALIVE_INTERVAL,
+			Timeout:             KEEPALIVE_TIMEOUT,
+			PermitWithoutStream: true,
+		}),
+	)
+}
+
+/*
+	Opens a channel on conn, lasting until ctx is done,
+	authorized by the Authorization header value
+	authorization, if not empty.
+*/
+func OpenChannel(ctx context.Context, conn *grpc.ClientConn, authorization string) (ChannelClient, error) {
+	ctx = metadata.AppendToOutgoingContext(ctx, VERSION_METADATA, strconv.Itoa(wrq.VERSION))
+	if authorization != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, AUTHORIZATION_METADATA, authorization)
+	}
+	s, err := conn.NewStream(ctx, &serviceDesc.Streams[0], CHANNEL_METHOD, grpc.CallContentSubtype(CODEC_NAME))
+	if err != nil {
+		return nil, err
+	}
+	return &channelClient{s}, nil
+}
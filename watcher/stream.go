@@ -0,0 +1,257 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/john98nf/SequenceClock/watcher/internal/conflicts"
+	"github.com/john98nf/SequenceClock/watcher/pkg/auth"
+	"github.com/john98nf/SequenceClock/watcher/pkg/stream"
+
+	"github.com/gin-gonic/gin/binding"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const EVENT_BUFFER int = 256 // Events queued per channel before dropping
+
+var (
+	events       = &eventHub{subscribers: make(map[chan *stream.Event]struct{})}
+	streamEvents = map[string]string{
+		conflicts.EVENT_CONTAINER_STARTED: stream.EVENT_CONTAINER_STARTED,
+		conflicts.EVENT_CONTAINER_STOPPED: stream.EVENT_CONTAINER_STOPPED,
+		conflicts.EVENT_REGISTRY_CHANGED:  stream.EVENT_REGISTRY_CHANGED,
+	}
+)
+
+/*
+	Fans resolver events out to open channels.
+	Publishing never blocks the resolver,
+	events of slow channels are dropped.
+*/
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan *stream.Event]struct{}
+}
+
+func (h *eventHub) publish(event, function, containerID string) {
+	e := &stream.Event{
+		Type:      streamEvents[event],
+		Function:  function,
+		Container: containerID,
+		Time:      time.Now(),
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+			droppedEvents.Inc()
+		}
+	}
+}
+
+func (h *eventHub) subscribe() chan *stream.Event {
+	ch := make(chan *stream.Event, EVENT_BUFFER)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan *stream.Event) {
+	h.mu.Lock()
+	delete(h.subscribers, ch)
+	h.mu.Unlock()
+}
+
+/*
+	Serves the channel of watcher supreme
+	on STREAM_PORT, next to the http api.
+*/
+func serveStream() {
+	if streamPort == "" {
+		return
+	}
+	lis, err := net.Listen("tcp", ":"+streamPort)
+	if err != nil {
+		panic(err)
+	}
+	var creds credentials.TransportCredentials
+	if certStore != nil {
+		// Channels come from watcher supreme only,
+		// no probe needs to connect without certificate.
+		clientAuth := tls.NoClientCert
+		if certStore.MutualTLS() {
+			clientAuth = tls.RequireAndVerifyClientCert
+		}
+		creds = credentials.NewTLS(certStore.ServerConfig(clientAuth))
+	}
+	srv := stream.NewServer(channelService{}, creds)
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			log.Println(err)
+		}
+	}()
+	log.Printf("Serving stream channel on port %s\n", streamPort)
+}
+
+type channelService struct{}
+
+/*
+	Pushes events and runs commands of a channel
+	until watcher supreme closes it. Commands run
+	concurrently, as http calls do.
+*/
+func (channelService) Channel(ch stream.ChannelServer) error {
+	if err := authorizeChannel(ch.Context()); err != nil {
+		return err
+	}
+	openChannels.Inc()
+	defer openChannels.Dec()
+	sub := events.subscribe()
+	defer events.unsubscribe(sub)
+	var mu sync.Mutex
+	send := func(u *stream.Update) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return ch.Send(u)
+	}
+	ctx := ch.Context()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-sub:
+				if err := send(&stream.Update{Event: e}); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	for {
+		cmd, err := ch.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		go func() {
+			if err := send(&stream.Update{Ack: runCommand(cmd)}); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+}
+
+/*
+	Authorizes the token carried as channel
+	metadata, as the http api does.
+*/
+func authorizeChannel(ctx context.Context) error {
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(stream.AUTHORIZATION_METADATA); len(v) > 0 {
+			header = v[0]
+		}
+	}
+	_, err := guard.Authorize(ctx, header, auth.ROLE_SUPREME, auth.ROLE_ADMIN)
+	switch auth.StatusOf(err) {
+	case http.StatusUnauthorized:
+		return status.Error(grpccodes.Unauthenticated, err.Error())
+	case http.StatusForbidden:
+		return status.Error(grpccodes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		return status.Error(grpccodes.Unavailable, err.Error())
+	}
+	return nil
+}
+
+/*
+	Runs a command as the matching http call,
+	in a server span continuing its trace.
+	Commands outlive a broken channel,
+	like http calls outlive their client.
+*/
+func runCommand(cmd *stream.Command) *stream.Ack {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), cmd.Trace)
+	ctx, span := tracer.Start(ctx, "stream "+cmd.Name(), trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	if activation := baggage.FromContext(ctx).Member(ACTIVATION_ID_KEY).Value(); activation != "" {
+		span.SetAttributes(attribute.String(ACTIVATION_ID_KEY, activation))
+	}
+	ack := &stream.Ack{Seq: cmd.Seq}
+	var err error
+	if err = cmd.Validate(); err != nil {
+		ack.Status = http.StatusBadRequest
+	} else if cmd.Allocate != nil {
+		if err = binding.Validator.ValidateStruct(cmd.Allocate); err != nil {
+			ack.Status = http.StatusBadRequest
+		} else {
+			var grant *conflicts.Grant
+			ack.Status, grant, err = allocate(ctx, cmd.Allocate)
+			if grant != nil {
+				ack.Grant, err = json.Marshal(grant)
+			}
+		}
+	} else if cmd.Reset != nil {
+		if err = binding.Validator.ValidateStruct(cmd.Reset); err != nil {
+			ack.Status = http.StatusBadRequest
+		} else {
+			ack.Status, err = reset(ctx, *cmd.Reset)
+		}
+	} else {
+		if err = binding.Validator.ValidateStruct(cmd.Renew); err != nil {
+			ack.Status = http.StatusBadRequest
+		} else {
+			ack.Status, err = renew(*cmd.Renew)
+		}
+	}
+	if err != nil {
+		ack.Error = err.Error()
+	} else if ack.Status == http.StatusNotFound {
+		ack.Error = "Not Found"
+	}
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(ack.Status))
+	span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(ack.Status))
+	streamCommands.WithLabelValues(cmd.Name(), strconv.Itoa(ack.Status)).Inc()
+	return ack
+}
@@ -24,8 +24,30 @@ go 1.15
 
 replace github.com/john98nf/SequenceClock/watcherSupreme/pkg/watcherClient => ./pkg/watcherClient
 
+replace github.com/john98nf/SequenceClock/watcherSupreme/pkg/election => ./pkg/election
+
+replace github.com/john98nf/SequenceClock/watcher/pkg/request => ../watcher/pkg/request
+
+replace github.com/john98nf/SequenceClock/watcher/pkg/request/bind => ../watcher/pkg/request/bind
+
+replace github.com/john98nf/SequenceClock/watcher/pkg/stream => ../watcher/pkg/stream
+
+replace github.com/john98nf/SequenceClock/watcher/pkg/auth => ../watcher/pkg/auth
+
+replace github.com/john98nf/SequenceClock/watcher/pkg/certs => ../watcher/pkg/certs
+
 require (
 	github.com/gin-gonic/gin v1.7.4
-	github.com/john98nf/SequenceClock/watcher/pkg/request v0.0.0-20210910091808-7dd4271b2430
+	github.com/john98nf/SequenceClock/watcher/pkg/auth v0.0.0-00010101000000-000000000000
+	github.com/john98nf/SequenceClock/watcher/pkg/certs v0.0.0-00010101000000-000000000000
+	github.com/john98nf/SequenceClock/watcher/pkg/request v0.0.0-00010101000000-000000000000
+	github.com/john98nf/SequenceClock/watcher/pkg/request/bind v0.0.0-00010101000000-000000000000
+	github.com/john98nf/SequenceClock/watcher/pkg/stream v0.0.0-00010101000000-000000000000
+	github.com/john98nf/SequenceClock/watcherSupreme/pkg/election v0.0.0-00010101000000-000000000000
 	github.com/john98nf/SequenceClock/watcherSupreme/pkg/watcherClient v0.0.0-00010101000000-000000000000
+	github.com/prometheus/client_golang v1.11.0
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
 )
@@ -21,43 +21,173 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
 	"sync"
 	"time"
 
+	"github.com/john98nf/SequenceClock/watcher/pkg/auth"
+	"github.com/john98nf/SequenceClock/watcher/pkg/certs"
 	wrq "github.com/john98nf/SequenceClock/watcher/pkg/request"
+	"github.com/john98nf/SequenceClock/watcher/pkg/request/bind"
+	"github.com/john98nf/SequenceClock/watcher/pkg/stream"
+	"github.com/john98nf/SequenceClock/watcherSupreme/pkg/election"
 	wrc "github.com/john98nf/SequenceClock/watcherSupreme/pkg/watcherClient"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	RESET_WAIT_TIMEOUT time.Duration = 30 * time.Second
+	CATALOG_SWEEP      time.Duration = time.Minute // Expiry of requests whose reset never came
+	ID_EPOCH_SHIFT     uint          = 32          // Request ids carry start time above the counter
+	PORT               string        = "8080"
 )
 
 var (
-	clients         []*wrc.WatcherClient
-	counterID       uint64
-	mutex           = sync.RWMutex{}
-	requestCatalog  = map[uint64]*wrc.WatcherClient{}
-	functionCatalog = map[string]*wrc.WatcherClient{}
+	leaderElection   string = os.Getenv("LEADER_ELECTION")
+	leaseName        string = os.Getenv("LEASE_NAME")
+	leaseDuration    string = os.Getenv("LEASE_DURATION")
+	podNamespace     string = os.Getenv("POD_NAMESPACE")
+	podIP            string = os.Getenv("POD_IP")
+	streamPort       string = os.Getenv("WATCHER_STREAM_PORT")
+	elector          *election.Elector
+	guard            *auth.Guard
+	certStore        *certs.Store
+	clients          []wrc.WatcherInterface
+	counterID        uint64 = requestEpoch() // Restarts continue above ids handed out before
+	orphanedRequests uint64                  // Resets dropped without a granted request
+	mutex            = sync.RWMutex{}
+	requestCatalog   = map[uint64]*pendingRequest{}
+	functionCatalog  = map[string]wrc.WatcherInterface{}
 )
 
+/*
+	Allocation state of a request.
+	done is closed once allocation completes,
+	client stays nil if it failed.
+	completed is set along done.
+*/
+type pendingRequest struct {
+	done      chan struct{}
+	client    wrc.WatcherInterface
+	completed time.Time
+}
+
+/*
+	Returns watcher node of a completed request,
+	nil while pending or if allocation failed.
+*/
+func (p *pendingRequest) watcher() wrc.WatcherInterface {
+	select {
+	case <-p.done:
+		return p.client
+	default:
+		return nil
+	}
+}
+
 func main() {
+	setupTracing()
+	guard = auth.MustFromEnv()
+	certStore = certs.FromEnv()
 	router := gin.New()
 
-	apiWatcher := router.Group("/api")
+	// GET Request http://localhost:8080/metrics
+	router.GET("/metrics", guard.Require(auth.ROLE_METRICS, auth.ROLE_ADMIN), gin.WrapH(promhttp.Handler()))
+
+	apiWatcher := router.Group("/api", traced)
 	{
 		// GET Request http://localhost:8080/api/check
 		apiWatcher.GET("/check", check)
 		// POST Request http://localhost:8080/api/function/requestResources
-		apiWatcher.POST("/function/requestResources", requestHandler)
+		apiWatcher.POST("/function/requestResources", guard.Require(auth.ROLE_CONTROLLER, auth.ROLE_ADMIN), leaderOnly, requestHandler)
 		// POST Request http://localhost:8080/api/function/resetResources
-		apiWatcher.POST("/function/resetResources", resetHandler)
+		apiWatcher.POST("/function/resetResources", guard.Require(auth.ROLE_CONTROLLER, auth.ROLE_ADMIN), leaderOnly, resetHandler)
+		// POST Request http://localhost:8080/api/function/renewResources
+		apiWatcher.POST("/function/renewResources", guard.Require(auth.ROLE_CONTROLLER, auth.ROLE_ADMIN), leaderOnly, renewHandler)
 		// GET Request http://localhost:8080/api/catalogs
-		apiWatcher.GET("/catalogs", getCatalogs)
+		apiWatcher.GET("/catalogs", guard.Require(auth.ROLE_ADMIN), leaderOnly, getCatalogs)
 	}
 
 	clients = connectWatchers()
-	router.Run(":8080")
+	registerMetrics(prometheus.DefaultRegisterer)
+	elector = newElector()
+	// Calls are served once catalogs are rebuilt
+	elector.OnStartedLeading = func(ctx context.Context) {
+		rebuildCatalogs()
+		go sweepCatalogs(ctx)
+	}
+	go elector.Run(context.Background())
+	if err := certStore.ListenAndServe(":"+PORT, router); err != nil {
+		panic(err)
+	}
+}
+
+/*
+	Creates the leader elector of this replica.
+	Without LEADER_ELECTION=true, replica competes
+	alone on an in-memory lease.
+*/
+func newElector() *election.Elector {
+	var duration time.Duration
+	if leaseDuration != "" {
+		d, err := time.ParseDuration(leaseDuration)
+		if err != nil {
+			panic(fmt.Errorf("invalid LEASE_DURATION '%s': %v", leaseDuration, err))
+		}
+		duration = d
+	}
+	identity := podIP
+	if identity == "" {
+		identity = "localhost"
+	}
+	if leaderElection != "true" {
+		return election.NewElector(election.NewMemoryLeaseClient(), identity, duration)
+	}
+	if podIP == "" || leaseName == "" {
+		panic(fmt.Errorf("leader election needs POD_IP and LEASE_NAME"))
+	}
+	client, err := election.NewKubeLeaseClient(podNamespace, leaseName)
+	if err != nil {
+		panic(err)
+	}
+	return election.NewElector(client, identity, duration)
+}
+
+/*
+	Serves catalog calls on the leader only.
+	Followers check the token, then proxy
+	them to the leader, or answer 503 while
+	none is known or a new leader still
+	rebuilds its catalogs.
+*/
+func leaderOnly(c *gin.Context) {
+	if elector.IsLeader() {
+		c.Next()
+		return
+	}
+	leader := elector.Leader()
+	if leader == "" {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "no leader elected"})
+		return
+	}
+	target := &url.URL{Scheme: certStore.Scheme(), Host: net.JoinHostPort(leader, PORT)}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	// Leader is addressed by pod IP, so any certificate of the CA is accepted
+	proxy.Transport = certStore.HTTPClient(false).Transport
+	proxy.ServeHTTP(c.Writer, c.Request)
+	c.Abort()
 }
 
 /*
@@ -67,25 +197,86 @@ func check(c *gin.Context) {
 	c.String(http.StatusOK, "Hello from watcher supreme!")
 }
 
+/*
+	Answer of a synchronous request, telling the
+	sequence controller what was applied.
+*/
+type allocation struct {
+	wrq.ResetRequest
+	Found    bool       `json:"found"`
+	TimedOut bool       `json:"timedOut,omitempty"` // Wait expired before any watcher granted it
+	Grant    *wrc.Grant `json:"grant,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
 /*
 	SpeedUp/Normal/SlowDown Request for certain function.
 	Watcher supreme wil inform watchers, which will
 	search their docker runtime for the actual docker container.
+	With a wait query parameter (e.g. ?wait=500ms),
+	it waits up to that long for the allocation
+	and returns the applied grant. When the wait
+	expires, it answers found=false with timedOut set,
+	so the controller may go on without a grant.
 */
 func requestHandler(c *gin.Context) {
-	var req wrq.Request
-	if err := c.ShouldBind(&req); err != nil {
+	var (
+		req  wrq.Request
+		wait time.Duration
+		err  error
+	)
+	if err := bind.Message(c, &req); err != nil {
 		c.String(http.StatusBadRequest, err.Error())
 		return
 	}
+	if w := c.Query("wait"); w != "" {
+		if wait, err = time.ParseDuration(w); err != nil || wait <= 0 {
+			c.String(http.StatusBadRequest, "invalid wait '%v'", w)
+			return
+		}
+	}
 	mutex.Lock()
 	reset := wrq.NewResetRequest(counterID, req.Function)
 	req.ID = reset.ID
 	counterID++
+	requestCatalog[req.ID] = &pendingRequest{done: make(chan struct{})}
 	mutex.Unlock()
-	go requestResourceAllocationFromWatchers(req)
+	tagRequest(c.Request.Context(), req.Function, req.ID)
+	if wait == 0 {
+		go requestResourceAllocationFromWatchers(detach(c.Request.Context()), req)
+		c.JSON(http.StatusOK, *reset)
+		return
+	}
 
-	c.JSON(http.StatusOK, *reset)
+	// Only the wait is bounded. Allocation goes on after it
+	// expires, so that the watcher which applies the grant
+	// is recorded and the reset of the request reaches it.
+	done := make(chan allocation, 1)
+	go func() {
+		grant, err := requestResourceAllocationFromWatchers(detach(c.Request.Context()), req)
+		res := allocation{ResetRequest: *reset, Found: grant != nil, Grant: grant}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		done <- res
+	}()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		if res.Error != "" {
+			c.JSON(http.StatusGatewayTimeout, res)
+			return
+		}
+		c.JSON(http.StatusOK, res)
+	case <-timer.C:
+		c.JSON(http.StatusOK, allocation{
+			ResetRequest: *reset,
+			TimedOut:     true,
+			Error:        fmt.Sprintf("allocation still pending after %v", wait),
+		})
+	case <-c.Request.Context().Done():
+	}
 }
 
 /*
@@ -95,45 +286,121 @@ func requestHandler(c *gin.Context) {
 */
 func resetHandler(c *gin.Context) {
 	var rs wrq.ResetRequest
-	if err := c.ShouldBind(&rs); err != nil {
+	if err := bind.Message(c, &rs); err != nil {
 		c.String(http.StatusBadRequest, err.Error())
 		return
 	}
-	go resetRequestToWatchers(rs)
+	tagRequest(c.Request.Context(), rs.Function, rs.ID)
+	go resetRequestToWatchers(detach(c.Request.Context()), rs)
 
 	c.String(http.StatusOK, "ok")
 }
 
+/*
+	Renew Request
+	Extends lease of the chosen request
+	on the involved watcher node.
+*/
+func renewHandler(c *gin.Context) {
+	var rs wrq.ResetRequest
+	if err := bind.Message(c, &rs); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	tagRequest(c.Request.Context(), rs.Function, rs.ID)
+	mutex.RLock()
+	p, ok := requestCatalog[rs.ID]
+	mutex.RUnlock()
+	var client wrc.WatcherInterface
+	if ok {
+		client = p.watcher()
+	}
+	if client == nil {
+		renewals.WithLabelValues("not_found").Inc()
+		c.String(http.StatusNotFound, "request %v not found", rs.ID)
+		return
+	}
+	if res, err := client.SendRenewRequest(c.Request.Context(), &rs); err != nil {
+		renewals.WithLabelValues("error").Inc()
+		c.String(http.StatusBadGateway, err.Error())
+		return
+	} else if !res {
+		renewals.WithLabelValues("not_found").Inc()
+		c.String(http.StatusNotFound, "lease of request %v not found", rs.ID)
+		return
+	}
+	renewals.WithLabelValues("ok").Inc()
+	c.String(http.StatusOK, "ok")
+}
+
 /*
 	Iterate through all nodes.
 	Request for resource allocation in every runtime
 	that the certain docker container is found.
 */
-func requestResourceAllocationFromWatchers(req wrq.Request) {
+func requestResourceAllocationFromWatchers(ctx context.Context, req wrq.Request) (*wrc.Grant, error) {
+	var granted wrc.WatcherInterface
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "allocate", trace.WithAttributes(
+		attribute.String("function", req.Function),
+		attribute.Int64("request.id", int64(req.ID)),
+	))
+	defer func() {
+		completeRequest(detach(ctx), req, granted)
+		observeAllocation(time.Since(start), granted != nil, ctx.Err())
+		if granted != nil {
+			span.SetAttributes(attribute.String("watcher.node", granted.Address()))
+		}
+		span.End()
+	}()
 	mutex.RLock()
 	c, ok := functionCatalog[req.Function]
 	mutex.RUnlock()
 	if ok {
-		found, err := c.SendRequest(&req)
-		if err != nil && err != fmt.Errorf("{\"message\":\"Not Found\"}") {
+		grant, err := c.RequestGrant(ctx, &req)
+		if err != nil {
 			log.Println(err)
-		} else if found {
-			mutex.Lock()
-			requestCatalog[req.ID] = c
-			mutex.Unlock()
-			return
+		} else if grant != nil {
+			granted = c
+			return grant, nil
 		}
 	}
 	for _, c := range clients {
-		if res, err := c.SendRequest(&req); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if grant, err := c.RequestGrant(ctx, &req); err != nil {
 			log.Println(err)
 			continue
-		} else if res {
+		} else if grant != nil {
+			granted = c
 			mutex.Lock()
-			requestCatalog[req.ID] = c
 			functionCatalog[req.Function] = c
 			mutex.Unlock()
-			return
+			return grant, nil
+		}
+	}
+	return nil, ctx.Err()
+}
+
+/*
+	Records the watcher node which granted a request,
+	nil if allocation failed, and wakes up a waiting reset.
+	If the reset already gave up on the request,
+	its grant is released right away.
+*/
+func completeRequest(ctx context.Context, req wrq.Request, c wrc.WatcherInterface) {
+	mutex.Lock()
+	p, ok := requestCatalog[req.ID]
+	if ok {
+		p.client, p.completed = c, time.Now()
+		close(p.done)
+	}
+	mutex.Unlock()
+	if !ok && c != nil {
+		log.Printf("Request %v granted after its reset was dropped, releasing it\n", req.ID)
+		if _, err := c.SendResetRequest(ctx, wrq.NewResetRequest(req.ID, req.Function)); err != nil {
+			log.Println("Problem with watcher:", err.Error())
 		}
 	}
 }
@@ -142,30 +409,59 @@ func requestResourceAllocationFromWatchers(req wrq.Request) {
 	Reach only the neccessary cluster node and
 	send a reset request for a specific serverless function.
 */
-func resetRequestToWatchers(rs wrq.ResetRequest) {
-	var (
-		ok     bool
-		client *wrc.WatcherClient
-	)
-	for {
-		mutex.RLock()
-		client, ok = requestCatalog[rs.ID]
-		mutex.RUnlock()
-		if ok {
-			break
-		}
-		time.Sleep(5 * time.Millisecond)
+func resetRequestToWatchers(ctx context.Context, rs wrq.ResetRequest) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "reset", trace.WithAttributes(
+		attribute.String("function", rs.Function),
+		attribute.Int64("request.id", int64(rs.ID)),
+	))
+	defer span.End()
+	mutex.RLock()
+	p, ok := requestCatalog[rs.ID]
+	mutex.RUnlock()
+	if !ok {
+		dropOrphan(rs, "unknown request")
+		observeReset(time.Since(start), "orphaned")
+		return
 	}
-	if res, err := client.SendResetRequest(&rs); err != nil {
+	select {
+	case <-p.done:
+	case <-time.After(RESET_WAIT_TIMEOUT):
+		dropOrphan(rs, "allocation still pending")
+		observeReset(time.Since(start), "orphaned")
+		return
+	}
+	if p.client == nil {
+		dropOrphan(rs, "allocation failed")
+		observeReset(time.Since(start), "orphaned")
+		return
+	}
+	if res, err := p.client.SendResetRequest(ctx, &rs); err != nil {
 		log.Println("Problem with watcher:", err.Error())
+		observeReset(time.Since(start), "error")
 	} else if !res {
-		log.Println("Problem with watcher:", client.Node)
+		log.Println("Problem with watcher:", p.client.Address())
+		observeReset(time.Since(start), "error")
+	} else {
+		observeReset(time.Since(start), "ok")
 	}
 	mutex.Lock()
 	delete(requestCatalog, rs.ID)
 	mutex.Unlock()
 }
 
+/*
+	Drops a reset request which no
+	watcher can serve and counts it.
+*/
+func dropOrphan(rs wrq.ResetRequest, reason string) {
+	log.Printf("Dropping reset of request %v for '%v': %v\n", rs.ID, rs.Function, reason)
+	mutex.Lock()
+	delete(requestCatalog, rs.ID)
+	orphanedRequests++
+	mutex.Unlock()
+}
+
 /*
 	Get information for catalog data structures.
 	Development oriented api call.
@@ -174,21 +470,141 @@ func getCatalogs(c *gin.Context) {
 	req := make(map[uint64]interface{})
 	fc := make(gin.H)
 	mutex.RLock()
-	for k, c := range requestCatalog {
-		req[k] = c.Node
+	for k, p := range requestCatalog {
+		if c := p.watcher(); c != nil {
+			req[k] = c.Address()
+		} else {
+			req[k] = nil
+		}
 	}
 	for k, c := range functionCatalog {
-		fc[k] = c.Node
+		fc[k] = c.Address()
 	}
+	orphaned := orphanedRequests
 	mutex.RUnlock()
-	c.JSON(http.StatusOK, gin.H{"requests": req, "functions": fc})
+	c.JSON(http.StatusOK, gin.H{"requests": req, "functions": fc, "orphaned": orphaned})
+}
+
+/*
+	First request id of the current second. Ids
+	handed out by an earlier process are below it.
+*/
+func requestEpoch() uint64 {
+	return uint64(time.Now().Unix()) << ID_EPOCH_SHIFT
+}
+
+/*
+	Leadership handshake. Adds to the catalogs the requests
+	that watchers still grant, so resets issued before
+	a restart or failover reach their nodes. Entries made
+	meanwhile are kept, stale ones expire in the sweep.
+	Request ids continue after the largest one found.
+*/
+func rebuildCatalogs() {
+	requests := map[uint64]*pendingRequest{}
+	functions := map[string]wrc.WatcherInterface{}
+	counter := requestEpoch()
+	for _, c := range clients {
+		registry, err := c.Registry()
+		if err != nil {
+			log.Println("Registry of watcher", c.Address(), "not fetched:", err.Error())
+			continue
+		}
+		for f, entry := range registry {
+			functions[f] = c
+			for _, id := range entry.RequestIDs() {
+				p := &pendingRequest{done: make(chan struct{}), client: c, completed: time.Now()}
+				close(p.done)
+				requests[id] = p
+				if id >= counter {
+					counter = id + 1
+				}
+			}
+		}
+		log.Printf("Watcher %v holds %v functions\n", c.Address(), len(registry))
+	}
+	mutex.Lock()
+	for id, p := range requests {
+		if _, ok := requestCatalog[id]; !ok {
+			requestCatalog[id] = p
+		}
+	}
+	for f, c := range functions {
+		if _, ok := functionCatalog[f]; !ok {
+			functionCatalog[f] = c
+		}
+	}
+	if counter > counterID {
+		counterID = counter
+	}
+	mutex.Unlock()
+}
+
+/*
+	Periodically drops requests whose reset never came,
+	e.g. when their controller crashed, until ctx ends
+	with leadership. Granted requests go once their
+	watcher no longer holds them, i.e. their lease expired
+	there. Failed ones go after RESET_WAIT_TIMEOUT.
+*/
+func sweepCatalogs(ctx context.Context) {
+	ticker := time.NewTicker(CATALOG_SWEEP)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expireRequests()
+		}
+	}
+}
+
+func expireRequests() {
+	start := time.Now()
+	held := map[wrc.WatcherInterface]map[uint64]bool{}
+	for _, c := range clients {
+		registry, err := c.Registry()
+		if err != nil {
+			log.Println("Registry of watcher", c.Address(), "not fetched:", err.Error())
+			continue
+		}
+		ids := map[uint64]bool{}
+		for _, entry := range registry {
+			for _, id := range entry.RequestIDs() {
+				ids[id] = true
+			}
+		}
+		held[c] = ids
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	for id, p := range requestCatalog {
+		select {
+		case <-p.done:
+		default:
+			continue
+		}
+		if p.client == nil {
+			if start.Sub(p.completed) < RESET_WAIT_TIMEOUT {
+				continue
+			}
+		} else if ids, ok := held[p.client]; !ok || ids[id] || !p.completed.Before(start) {
+			// Watcher unreachable, still holding it,
+			// or granted after its registry was read
+			continue
+		}
+		log.Printf("Request %v expired without a reset\n", id)
+		delete(requestCatalog, id)
+		expiredRequests.Inc()
+	}
 }
 
 /*
 	Initiation function. Creates watcher clients
 	for cluster nodes.
 */
-func connectWatchers() []*wrc.WatcherClient {
+func connectWatchers() []wrc.WatcherInterface {
 	// TODO: Call kubernetes api for automatic
 	// node discovery.
 	var nodes []string = []string{
@@ -196,9 +612,40 @@ func connectWatchers() []*wrc.WatcherClient {
 		"192.168.1.245",
 		"192.168.1.246",
 	}
-	res := make([]*wrc.WatcherClient, len(nodes))
+	credential := auth.CredentialFromEnv(auth.SUPREME_TOKEN_ENV)
+	res := make([]wrc.WatcherInterface, len(nodes))
 	for i, n := range nodes {
-		res[i] = wrc.NewWatcherClient(n)
+		c := wrc.NewWatcherClient(n)
+		c.Credential = credential
+		c.UseTLS(certStore)
+		res[i] = c
+		if streamPort == "" {
+			continue
+		}
+		onEvent := func(node string, e *stream.Event) { onWatcherEvent(c, e) }
+		if err := c.EnableStream(streamPort, onEvent); err != nil {
+			panic(err)
+		}
 	}
 	return res
 }
+
+/*
+	Keeps function catalog in line with containers
+	appearing and disappearing on watcher nodes.
+*/
+func onWatcherEvent(c wrc.WatcherInterface, e *stream.Event) {
+	watcherEvents.WithLabelValues(e.Type).Inc()
+	mutex.Lock()
+	defer mutex.Unlock()
+	switch e.Type {
+	case stream.EVENT_CONTAINER_STARTED:
+		if _, ok := functionCatalog[e.Function]; !ok {
+			functionCatalog[e.Function] = c
+		}
+	case stream.EVENT_CONTAINER_STOPPED:
+		if functionCatalog[e.Function] == c {
+			delete(functionCatalog, e.Function)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	wrq "github.com/john98nf/SequenceClock/watcher/pkg/request"
+	"github.com/john98nf/SequenceClock/watcherSupreme/pkg/election"
+	wrc "github.com/john98nf/SequenceClock/watcherSupreme/pkg/watcherClient"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+	Watcher running the functions it was
+	given, holding the requests it granted
+	until they are reset.
+*/
+type fakeWatcher struct {
+	node      string
+	functions map[string]bool
+	mutex     sync.Mutex
+	held      map[uint64]string // Function of granted requests
+	resets    []uint64
+	hold      chan struct{} // When set, grants wait for it to close
+	down      bool          // Registry unreachable
+}
+
+func newFakeWatcher(node string, functions ...string) *fakeWatcher {
+	w := &fakeWatcher{node: node, functions: map[string]bool{}, held: map[uint64]string{}}
+	for _, f := range functions {
+		w.functions[f] = true
+	}
+	return w
+}
+
+func (w *fakeWatcher) Address() string {
+	return w.node
+}
+
+func (w *fakeWatcher) RequestGrant(ctx context.Context, r *wrq.Request) (*wrc.Grant, error) {
+	if w.hold != nil {
+		select {
+		case <-w.hold:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if !w.functions[r.Function] {
+		return nil, nil
+	}
+	w.mutex.Lock()
+	w.held[r.ID] = r.Function
+	w.mutex.Unlock()
+	return &wrc.Grant{Node: w.node, Container: r.Function, Quotas: 50000, Requested: 50000}, nil
+}
+
+func (w *fakeWatcher) SendResetRequest(ctx context.Context, r *wrq.ResetRequest) (bool, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.resets = append(w.resets, r.ID)
+	if _, ok := w.held[r.ID]; !ok {
+		return false, nil
+	}
+	delete(w.held, r.ID)
+	return true, nil
+}
+
+func (w *fakeWatcher) SendRenewRequest(ctx context.Context, r *wrq.ResetRequest) (bool, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, ok := w.held[r.ID]
+	return ok, nil
+}
+
+func (w *fakeWatcher) Registry() (map[string]wrc.RegistryEntry, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.down {
+		return nil, errors.New("watcher down")
+	}
+	res := map[string]wrc.RegistryEntry{}
+	for id, f := range w.held {
+		entry := res[f]
+		if entry.Requests.Active == nil {
+			entry.Requests.Active = map[uint64]int64{}
+		}
+		entry.Requests.Active[id] = 50000
+		res[f] = entry
+	}
+	return res, nil
+}
+
+func (w *fakeWatcher) StreamConnected() bool {
+	return false
+}
+
+func (w *fakeWatcher) resetIDs() []uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return append([]uint64{}, w.resets...)
+}
+
+func (w *fakeWatcher) holds(id uint64) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, ok := w.held[id]
+	return ok
+}
+
+/*
+	Starts over with empty catalogs
+	and the given watchers.
+*/
+func useWatchers(watchers ...*fakeWatcher) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	clients = nil
+	for _, w := range watchers {
+		clients = append(clients, w)
+	}
+	requestCatalog = map[uint64]*pendingRequest{}
+	functionCatalog = map[string]wrc.WatcherInterface{}
+	counterID, orphanedRequests = 0, 0
+}
+
+func testRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/requestResources", requestHandler)
+	return router
+}
+
+func postRequest(t *testing.T, router *gin.Engine, query string, req *wrq.Request) (int, allocation) {
+	t.Helper()
+	body, err := wrq.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/requestResources"+query, bytes.NewReader(body))
+	r.Header.Set("Content-Type", wrq.CONTENT_TYPE_JSON)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	var res allocation
+	if w.Code == http.StatusBadRequest {
+		return w.Code, res
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("answer %q: %v", w.Body.String(), err)
+	}
+	return w.Code, res
+}
+
+/*
+	Waits for the allocation of id to complete.
+*/
+func awaitAllocation(t *testing.T, id uint64) *pendingRequest {
+	t.Helper()
+	mutex.RLock()
+	p, ok := requestCatalog[id]
+	mutex.RUnlock()
+	if !ok {
+		t.Fatalf("request %v not in catalog", id)
+	}
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("allocation of request %v never completed", id)
+	}
+	return p
+}
+
+func TestSynchronousAllocation(t *testing.T) {
+	w := newFakeWatcher("node-b", "fn")
+	useWatchers(newFakeWatcher("node-a"), w)
+	router := testRouter()
+
+	status, res := postRequest(t, router, "?wait=5s", &wrq.Request{Function: "fn", Metrics: &wrq.Metrics{}})
+	if status != http.StatusOK || !res.Found || res.TimedOut || res.Grant == nil || res.Grant.Node != "node-b" {
+		t.Fatalf("got %v %+v, want a grant of node-b", status, res)
+	}
+	if !w.holds(res.ID) {
+		t.Fatalf("watcher does not hold request %v", res.ID)
+	}
+	if p := awaitAllocation(t, res.ID); p.watcher() != w {
+		t.Fatal("catalog does not point to the granting watcher")
+	}
+
+	status, res = postRequest(t, router, "?wait=5s", &wrq.Request{Function: "missing", Metrics: &wrq.Metrics{}})
+	if status != http.StatusOK || res.Found || res.TimedOut {
+		t.Fatalf("got %v %+v for a function without container, want found=false", status, res)
+	}
+
+	if status, _ := postRequest(t, router, "?wait=never", &wrq.Request{Function: "fn", Metrics: &wrq.Metrics{}}); status != http.StatusBadRequest {
+		t.Fatalf("got %v for an invalid wait, want 400", status)
+	}
+}
+
+func TestAllocationOutlivesWait(t *testing.T) {
+	w := newFakeWatcher("node-a", "fn")
+	w.hold = make(chan struct{})
+	useWatchers(w)
+
+	status, res := postRequest(t, testRouter(), "?wait=50ms", &wrq.Request{Function: "fn", Metrics: &wrq.Metrics{}})
+	if status != http.StatusOK || !res.TimedOut || res.Found || res.Grant != nil {
+		t.Fatalf("got %v %+v, want timedOut without grant", status, res)
+	}
+
+	// The watcher applies the grant after the wait expired,
+	// the reset of the controller must still reach it.
+	close(w.hold)
+	if p := awaitAllocation(t, res.ID); p.watcher() != w {
+		t.Fatal("late grant not recorded in catalog")
+	}
+	resetRequestToWatchers(context.Background(), res.ResetRequest)
+	if w.holds(res.ID) {
+		t.Fatal("late grant never reset")
+	}
+	if orphanedRequests != 0 {
+		t.Fatalf("%v resets dropped as orphans", orphanedRequests)
+	}
+}
+
+func TestResetCorrelation(t *testing.T) {
+	tests := []struct {
+		name        string
+		function    string
+		hold        bool // Grant waits until the reset was sent
+		unknownID   bool // Reset carries an id never handed out
+		wantReset   bool // Reset reached the watcher
+		wantOrphans uint64
+	}{
+		{name: "reset after grant", function: "fn", wantReset: true},
+		{name: "reset before grant", function: "fn", hold: true, wantReset: true},
+		{name: "reset without pending request", function: "fn", unknownID: true, wantOrphans: 1},
+		{name: "reset of failed allocation", function: "missing", wantOrphans: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newFakeWatcher("node-a", "fn")
+			if tt.hold {
+				w.hold = make(chan struct{})
+			}
+			useWatchers(w)
+			status, res := postRequest(t, testRouter(), "", &wrq.Request{Function: tt.function, Metrics: &wrq.Metrics{}})
+			if status != http.StatusOK {
+				t.Fatalf("request answered %v", status)
+			}
+			rs := res.ResetRequest
+			if tt.unknownID {
+				awaitAllocation(t, rs.ID)
+				rs.ID += 1000
+			}
+
+			done := make(chan struct{})
+			go func() {
+				resetRequestToWatchers(context.Background(), rs)
+				close(done)
+			}()
+			if tt.hold {
+				// Let the reset find the request pending
+				time.Sleep(20 * time.Millisecond)
+				close(w.hold)
+			}
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("reset never completed")
+			}
+
+			gotReset := len(w.resetIDs()) == 1 && w.resetIDs()[0] == rs.ID
+			if gotReset != tt.wantReset {
+				t.Errorf("watcher resets %v, want reset of %v: %v", w.resetIDs(), rs.ID, tt.wantReset)
+			}
+			if w.holds(rs.ID) {
+				t.Errorf("watcher still holds request %v", rs.ID)
+			}
+			mutex.RLock()
+			defer mutex.RUnlock()
+			if orphanedRequests != tt.wantOrphans {
+				t.Errorf("%v orphaned resets, want %v", orphanedRequests, tt.wantOrphans)
+			}
+			if _, ok := requestCatalog[rs.ID]; ok {
+				t.Errorf("request %v kept in catalog after its reset", rs.ID)
+			}
+		})
+	}
+}
+
+func TestGrantAfterDroppedReset(t *testing.T) {
+	w := newFakeWatcher("node-a", "fn")
+	w.hold = make(chan struct{})
+	useWatchers(w)
+	_, res := postRequest(t, testRouter(), "", &wrq.Request{Function: "fn", Metrics: &wrq.Metrics{}})
+
+	// Reset gave up waiting, the late grant is released
+	dropOrphan(res.ResetRequest, "allocation still pending")
+	close(w.hold)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(w.resetIDs()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("late grant never released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if w.holds(res.ID) {
+		t.Fatalf("watcher still holds request %v", res.ID)
+	}
+}
+
+func TestCatalogSweep(t *testing.T) {
+	held := newFakeWatcher("node-a", "fn")
+	down := newFakeWatcher("node-b", "fn")
+	down.down = true
+	useWatchers(held, down)
+	held.held[1] = "fn"
+
+	completed := func(c wrc.WatcherInterface, age time.Duration) *pendingRequest {
+		p := &pendingRequest{done: make(chan struct{}), client: c, completed: time.Now().Add(-age)}
+		close(p.done)
+		return p
+	}
+	tests := []struct {
+		name    string
+		request *pendingRequest
+		expired bool
+	}{
+		{"granted and still held", completed(held, time.Hour), false},
+		{"granted and released by lease expiry", completed(held, time.Hour), true},
+		{"granted by an unreachable watcher", completed(down, time.Hour), false},
+		{"granted after the registry was read", completed(held, -time.Hour), false},
+		{"failed recently", completed(nil, time.Second), false},
+		{"failed long ago", completed(nil, 2*RESET_WAIT_TIMEOUT), true},
+		{"still pending", &pendingRequest{done: make(chan struct{})}, false},
+	}
+	mutex.Lock()
+	for i, tt := range tests {
+		requestCatalog[uint64(i+1)] = tt.request
+	}
+	mutex.Unlock()
+
+	expireRequests()
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for i, tt := range tests {
+		if _, kept := requestCatalog[uint64(i+1)]; kept == tt.expired {
+			t.Errorf("%v: kept %v, want expired %v", tt.name, kept, tt.expired)
+		}
+	}
+}
+
+func TestRestart(t *testing.T) {
+	w := newFakeWatcher("node-a", "fn", "slow")
+	useWatchers(w)
+	router := testRouter()
+	// Previous process, started a minute ago
+	counterID = requestEpoch() - 60<<ID_EPOCH_SHIFT
+	_, before := postRequest(t, router, "?wait=5s", &wrq.Request{Function: "fn", Metrics: &wrq.Metrics{}})
+	if !before.Found {
+		t.Fatalf("got %+v, want a grant", before)
+	}
+
+	// Restart, requests are served before catalogs are rebuilt
+	useWatchers(w)
+	counterID = requestEpoch()
+	_, during := postRequest(t, router, "?wait=5s", &wrq.Request{Function: "fn", Metrics: &wrq.Metrics{}})
+	if during.ID <= before.ID {
+		t.Fatalf("request id %v after restart, want above %v", during.ID, before.ID)
+	}
+	w.hold = make(chan struct{})
+	_, pending := postRequest(t, router, "", &wrq.Request{Function: "slow", Metrics: &wrq.Metrics{}})
+
+	rebuildCatalogs()
+	close(w.hold)
+	if counterID <= pending.ID {
+		t.Fatalf("counter %v after rebuild, want above %v", counterID, pending.ID)
+	}
+	for _, rs := range []wrq.ResetRequest{before.ResetRequest, during.ResetRequest, pending.ResetRequest} {
+		resetRequestToWatchers(context.Background(), rs)
+		if w.holds(rs.ID) {
+			t.Errorf("request %v not reset", rs.ID)
+		}
+	}
+	if orphanedRequests != 0 {
+		t.Fatalf("%v resets dropped as orphans", orphanedRequests)
+	}
+}
+
+func TestNewLeaderWaitsForCatalogs(t *testing.T) {
+	useWatchers(newFakeWatcher("node-a", "fn"))
+	lease := election.NewMemoryLeaseClient()
+	elector = election.NewElector(lease, "localhost", time.Second)
+	defer func() { elector = nil }()
+	rebuilt := make(chan struct{})
+	elector.OnStartedLeading = func(ctx context.Context) {
+		<-rebuilt
+		rebuildCatalogs()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go elector.Run(ctx)
+
+	router := gin.New()
+	router.POST("/requestResources", leaderOnly, requestHandler)
+	req := &wrq.Request{Function: "fn", Metrics: &wrq.Metrics{}}
+	deadline := time.Now().Add(5 * time.Second)
+	for record, err := lease.Get(ctx); err != nil || record.HolderIdentity != "localhost"; record, err = lease.Get(ctx) {
+		if time.Now().After(deadline) {
+			t.Fatal("lease never acquired")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status, _ := postRequest(t, router, "", req); status != http.StatusServiceUnavailable {
+		t.Fatalf("got %v before catalogs were rebuilt, want 503", status)
+	}
+
+	close(rebuilt)
+	for status, _ := postRequest(t, router, "", req); status != http.StatusOK; status, _ = postRequest(t, router, "", req) {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v after catalogs were rebuilt, want 200", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const METRICS_NAMESPACE string = "sequence_clock_watcher_supreme"
+
+var (
+	allocations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "requests_total",
+		Help:      "Resource requests by outcome (granted, not_found, timeout).",
+	}, []string{"result"})
+	allocationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "request_duration_seconds",
+		Help:      "Time until a resource request is granted or given up.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	})
+	resets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "resets_total",
+		Help:      "Reset requests by outcome (ok, error, orphaned).",
+	}, []string{"result"})
+	resetDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "reset_duration_seconds",
+		Help:      "Time until a reset request reaches its watcher.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	})
+	renewals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "renewals_total",
+		Help:      "Lease renewals by outcome (ok, error, not_found).",
+	}, []string{"result"})
+	pendingRequests = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "catalog_requests",
+		Help:      "Requests tracked in request catalog.",
+	}, func() float64 {
+		mutex.RLock()
+		defer mutex.RUnlock()
+		return float64(len(requestCatalog))
+	})
+	expiredRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "catalog_expired_total",
+		Help:      "Requests dropped from request catalog without a reset.",
+	})
+	watcherEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "watcher_events_total",
+		Help:      "Events pushed by watchers over stream channels.",
+	}, []string{"type"})
+	streamChannels = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "stream_channels",
+		Help:      "Watchers reached over an open stream channel.",
+	}, func() float64 {
+		var n float64
+		for _, c := range clients {
+			if c.StreamConnected() {
+				n++
+			}
+		}
+		return n
+	})
+	leader = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "leader",
+		Help:      "Whether this replica is the elected leader.",
+	}, func() float64 {
+		if elector != nil && elector.IsLeader() {
+			return 1
+		}
+		return 0
+	})
+)
+
+/*
+	Records outcome of a resource request.
+*/
+func observeAllocation(elapsed time.Duration, granted bool, err error) {
+	allocationDuration.Observe(elapsed.Seconds())
+	switch {
+	case granted:
+		allocations.WithLabelValues("granted").Inc()
+	case err != nil:
+		allocations.WithLabelValues("timeout").Inc()
+	default:
+		allocations.WithLabelValues("not_found").Inc()
+	}
+}
+
+/*
+	Records outcome of a reset request.
+*/
+func observeReset(elapsed time.Duration, result string) {
+	resetDuration.Observe(elapsed.Seconds())
+	resets.WithLabelValues(result).Inc()
+}
+
+/*
+	Registers watcher supreme metrics
+	with reg, the default registry
+	outside of tests.
+*/
+func registerMetrics(reg prometheus.Registerer) {
+	reg.MustRegister(allocations, allocationDuration, resets, resetDuration, renewals, pendingRequests, expiredRequests, watcherEvents, streamChannels, leader)
+}
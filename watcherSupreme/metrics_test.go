@@ -0,0 +1,89 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+/*
+	Label names of every metric family
+	gathered from reg, comma separated.
+*/
+func gatheredLabels(t *testing.T, reg *prometheus.Registry) map[string]string {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := map[string]string{}
+	for _, f := range families {
+		names := []string{}
+		for _, l := range f.GetMetric()[0].GetLabel() {
+			names = append(names, l.GetName())
+		}
+		sort.Strings(names)
+		res[f.GetName()] = strings.Join(names, ",")
+	}
+	return res
+}
+
+func TestMetricsRegistered(t *testing.T) {
+	useWatchers(newFakeWatcher("node-a"))
+	reg := prometheus.NewPedanticRegistry()
+	registerMetrics(reg)
+	observeAllocation(time.Millisecond, true, nil)
+	observeReset(time.Millisecond, "ok")
+	renewals.WithLabelValues("ok").Inc()
+	watcherEvents.WithLabelValues("container_started").Inc()
+
+	want := map[string]string{
+		"sequence_clock_watcher_supreme_requests_total":           "result",
+		"sequence_clock_watcher_supreme_request_duration_seconds": "",
+		"sequence_clock_watcher_supreme_resets_total":             "result",
+		"sequence_clock_watcher_supreme_reset_duration_seconds":   "",
+		"sequence_clock_watcher_supreme_renewals_total":           "result",
+		"sequence_clock_watcher_supreme_catalog_requests":         "",
+		"sequence_clock_watcher_supreme_catalog_expired_total":    "",
+		"sequence_clock_watcher_supreme_watcher_events_total":     "type",
+		"sequence_clock_watcher_supreme_stream_channels":          "",
+		"sequence_clock_watcher_supreme_leader":                   "",
+	}
+	got := gatheredLabels(t, reg)
+	for name, labels := range want {
+		if l, ok := got[name]; !ok {
+			t.Errorf("%v not registered", name)
+		} else if l != labels {
+			t.Errorf("%v labels %q, want %q", name, l, labels)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("gathered %v metrics, want %v", len(got), len(want))
+	}
+	if n := testutil.CollectAndCount(allocations, "sequence_clock_watcher_supreme_requests_total"); n < 1 {
+		t.Errorf("%v allocation outcomes, want at least one", n)
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package election
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	LEASE_DURATION_DEFAULT time.Duration = 15 * time.Second
+	RETRY_PERIOD_DEFAULT   time.Duration = 2 * time.Second
+)
+
+var (
+	ErrLeaseNotFound = errors.New("lease not found")
+	ErrConflict      = errors.New("lease modified concurrently")
+)
+
+/*
+	Holder information of a coordination Lease.
+	Version is opaque to the elector and is used
+	by lease clients for optimistic concurrency.
+*/
+type LeaseRecord struct {
+	HolderIdentity string
+	LeaseDuration  time.Duration
+	AcquireTime    time.Time
+	RenewTime      time.Time
+	Transitions    int32
+	Version        string
+}
+
+/*
+	Access to a single coordination Lease.
+	Update fails with ErrConflict if the lease
+	changed since record was read.
+*/
+type LeaseClient interface {
+	Get(ctx context.Context) (*LeaseRecord, error)
+	Create(ctx context.Context, record *LeaseRecord) error
+	Update(ctx context.Context, record *LeaseRecord) error
+}
+
+/*
+	Lease based leader elector.
+	Identity is advertised as lease holder,
+	so followers can reach the leader through it.
+*/
+type Elector struct {
+	mutex            sync.RWMutex
+	client           LeaseClient
+	identity         string
+	leaseDuration    time.Duration
+	retryPeriod      time.Duration
+	leader           string
+	elected          bool // Holds the lease, OnStartedLeading may still run
+	leading          bool // Elected and OnStartedLeading returned
+	lastRenew        time.Time
+	cancel           context.CancelFunc        // Ends context given to OnStartedLeading
+	OnStartedLeading func(ctx context.Context) // Prepares leadership, ctx ends with it
+	OnStoppedLeading func()
+}
+
+func NewElector(client LeaseClient, identity string, leaseDuration time.Duration) *Elector {
+	if leaseDuration <= 0 {
+		leaseDuration = LEASE_DURATION_DEFAULT
+	}
+	retry := RETRY_PERIOD_DEFAULT
+	if retry > leaseDuration/3 {
+		retry = leaseDuration / 3
+	}
+	return &Elector{
+		mutex:         sync.RWMutex{},
+		client:        client,
+		identity:      identity,
+		leaseDuration: leaseDuration,
+		retryPeriod:   retry,
+	}
+}
+
+/*
+	Reports whether this replica holds the lease
+	and OnStartedLeading returned.
+*/
+func (e *Elector) IsLeader() bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.leading
+}
+
+/*
+	Identity of current lease holder,
+	empty while it is unknown, or while this
+	replica holds it without leading yet.
+*/
+func (e *Elector) Leader() string {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	if e.leader == e.identity && !e.leading {
+		return ""
+	}
+	return e.leader
+}
+
+func (e *Elector) isElected() bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.elected
+}
+
+/*
+	Takes part in the election until ctx is cancelled.
+	Leadership is given up when the lease
+	is not renewed within its duration.
+*/
+func (e *Elector) Run(ctx context.Context) {
+	defer func() {
+		if e.isElected() {
+			e.stopLeading()
+		}
+	}()
+	ticker := time.NewTicker(e.retryPeriod)
+	defer ticker.Stop()
+	for {
+		acquired := e.tryAcquireOrRenew(ctx, time.Now())
+		switch {
+		case acquired && !e.isElected():
+			e.startLeading(ctx)
+		case !acquired && e.isElected() && time.Since(e.lastRenew) > e.leaseDuration:
+			e.stopLeading()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+/*
+	Single election round. Returns true if
+	this replica holds the lease afterwards.
+*/
+func (e *Elector) tryAcquireOrRenew(ctx context.Context, now time.Time) bool {
+	record, err := e.client.Get(ctx)
+	if errors.Is(err, ErrLeaseNotFound) {
+		record = &LeaseRecord{
+			HolderIdentity: e.identity,
+			LeaseDuration:  e.leaseDuration,
+			AcquireTime:    now,
+			RenewTime:      now,
+		}
+		if err := e.client.Create(ctx, record); err != nil {
+			log.Println("Lease creation failed:", err.Error())
+			return false
+		}
+		e.renewed(now)
+		return true
+	} else if err != nil {
+		log.Println("Lease read failed:", err.Error())
+		return false
+	}
+
+	if record.HolderIdentity != e.identity &&
+		record.HolderIdentity != "" &&
+		now.Before(record.RenewTime.Add(record.LeaseDuration)) {
+		e.mutex.Lock()
+		e.leader = record.HolderIdentity
+		e.mutex.Unlock()
+		return false
+	}
+	if record.HolderIdentity != e.identity {
+		record.HolderIdentity = e.identity
+		record.AcquireTime = now
+		record.Transitions++
+	}
+	record.LeaseDuration = e.leaseDuration
+	record.RenewTime = now
+	if err := e.client.Update(ctx, record); err != nil {
+		if !errors.Is(err, ErrConflict) {
+			log.Println("Lease update failed:", err.Error())
+		}
+		return false
+	}
+	e.renewed(now)
+	return true
+}
+
+func (e *Elector) renewed(now time.Time) {
+	e.mutex.Lock()
+	e.leader = e.identity
+	e.lastRenew = now
+	e.mutex.Unlock()
+}
+
+/*
+	Starts a term, reporting leadership once
+	OnStartedLeading returned, so that no call is
+	served before the replica is ready to lead.
+	Lease renewals go on meanwhile.
+*/
+func (e *Elector) startLeading(parent context.Context) {
+	log.Println("Acquired lease as", e.identity)
+	ctx, cancel := context.WithCancel(parent)
+	e.mutex.Lock()
+	e.elected = true
+	e.cancel = cancel
+	e.mutex.Unlock()
+	go func() {
+		if e.OnStartedLeading != nil {
+			e.OnStartedLeading(ctx)
+		}
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+		if ctx.Err() == nil {
+			e.leading = true
+			log.Println("Started leading as", e.identity)
+		}
+	}()
+}
+
+func (e *Elector) stopLeading() {
+	log.Println("Stopped leading as", e.identity)
+	e.mutex.Lock()
+	e.cancel()
+	e.elected, e.leading = false, false
+	if e.leader == e.identity {
+		e.leader = ""
+	}
+	e.mutex.Unlock()
+	if e.OnStoppedLeading != nil {
+		e.OnStoppedLeading()
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package election
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestElectorFailover(t *testing.T) {
+	lease := NewMemoryLeaseClient()
+	a := NewElector(lease, "a", 10*time.Second)
+	b := NewElector(lease, "b", 10*time.Second)
+	ctx := context.Background()
+	now := time.Now()
+
+	if !a.tryAcquireOrRenew(ctx, now) {
+		t.Fatal("a did not acquire a free lease")
+	}
+	if b.tryAcquireOrRenew(ctx, now.Add(time.Second)) {
+		t.Fatal("b acquired a lease held by a")
+	}
+	if b.Leader() != "a" {
+		t.Fatalf("b sees leader %q, want a", b.Leader())
+	}
+	if !a.tryAcquireOrRenew(ctx, now.Add(5*time.Second)) {
+		t.Fatal("a could not renew its lease")
+	}
+	if b.tryAcquireOrRenew(ctx, now.Add(14*time.Second)) {
+		t.Fatal("b acquired a renewed lease")
+	}
+
+	// a stops renewing
+	if !b.tryAcquireOrRenew(ctx, now.Add(16*time.Second)) {
+		t.Fatal("b did not take over an expired lease")
+	}
+	record, err := lease.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.HolderIdentity != "b" || record.Transitions != 1 {
+		t.Fatalf("lease held by %q after %v transitions", record.HolderIdentity, record.Transitions)
+	}
+	if a.tryAcquireOrRenew(ctx, now.Add(17*time.Second)) {
+		t.Fatal("a renewed a lease taken over by b")
+	}
+}
+
+/*
+	Lease client answering Get with a record
+	that changes before Update arrives.
+*/
+type racingLeaseClient struct {
+	*MemoryLeaseClient
+	rival *LeaseRecord
+}
+
+func (r *racingLeaseClient) Get(ctx context.Context) (*LeaseRecord, error) {
+	record, err := r.MemoryLeaseClient.Get(ctx)
+	if err == nil && r.rival != nil {
+		rival := *r.rival
+		rival.Version = record.Version
+		r.MemoryLeaseClient.Update(ctx, &rival)
+		r.rival = nil
+	}
+	return record, err
+}
+
+func TestElectorLosesConcurrentUpdate(t *testing.T) {
+	lease := NewMemoryLeaseClient()
+	ctx := context.Background()
+	past := time.Now().Add(-time.Hour)
+	lease.Create(ctx, &LeaseRecord{HolderIdentity: "old", LeaseDuration: time.Second, RenewTime: past})
+	now := time.Now()
+	e := NewElector(&racingLeaseClient{
+		MemoryLeaseClient: lease,
+		rival:             &LeaseRecord{HolderIdentity: "rival", LeaseDuration: time.Minute, RenewTime: now},
+	}, "e", 10*time.Second)
+
+	if e.tryAcquireOrRenew(ctx, now) {
+		t.Fatal("elector overwrote a lease taken concurrently")
+	}
+	if record, _ := lease.Get(ctx); record.HolderIdentity != "rival" {
+		t.Fatalf("lease held by %q, want rival", record.HolderIdentity)
+	}
+}
+
+func TestElectorRun(t *testing.T) {
+	lease := NewMemoryLeaseClient()
+	e := NewElector(lease, "a", 300*time.Millisecond)
+	started, stopped := make(chan context.Context, 1), make(chan struct{}, 1)
+	e.OnStartedLeading = func(ctx context.Context) { started <- ctx }
+	e.OnStoppedLeading = func() { stopped <- struct{}{} }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	var leading context.Context
+	select {
+	case leading = <-started:
+	case <-time.After(time.Second):
+		t.Fatal("elector did not start leading")
+	}
+	eventually(t, func() bool { return e.IsLeader() && e.Leader() == "a" }, "elector leading as a")
+
+	cancel()
+	<-done
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("elector did not stop leading")
+	}
+	if leading.Err() == nil {
+		t.Fatal("leadership context still alive")
+	}
+	if e.IsLeader() {
+		t.Fatal("elector still leading after Run returned")
+	}
+}
+
+func eventually(t *testing.T, cond func() bool, what string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestElectorLeadsAfterStart(t *testing.T) {
+	lease := NewMemoryLeaseClient()
+	e := NewElector(lease, "a", 300*time.Millisecond)
+	ready := make(chan struct{})
+	e.OnStartedLeading = func(ctx context.Context) { <-ready }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+
+	eventually(t, e.isElected, "lease acquired")
+	// Longer than the lease, renewals go on while preparing
+	time.Sleep(400 * time.Millisecond)
+	if record, _ := lease.Get(ctx); record.HolderIdentity != "a" || time.Since(record.RenewTime) > 300*time.Millisecond {
+		t.Fatalf("lease %+v not renewed while preparing", record)
+	}
+	if e.IsLeader() || e.Leader() != "" {
+		t.Fatalf("leader %q, leading %v before OnStartedLeading returned", e.Leader(), e.IsLeader())
+	}
+
+	close(ready)
+	eventually(t, func() bool { return e.IsLeader() && e.Leader() == "a" }, "elector leading as a")
+}
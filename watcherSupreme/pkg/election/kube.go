@@ -0,0 +1,210 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package election
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	SERVICE_ACCOUNT_DIR string        = "/var/run/secrets/kubernetes.io/serviceaccount"
+	LEASES_PATH         string        = "/apis/coordination.k8s.io/v1/namespaces/%v/leases"
+	MICRO_TIME_FORMAT   string        = "2006-01-02T15:04:05.000000Z07:00"
+	API_TIMEOUT         time.Duration = 5 * time.Second
+)
+
+/*
+	Lease object of coordination.k8s.io/v1 api,
+	limited to fields used by the elector.
+*/
+type kubeLease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string `json:"holderIdentity,omitempty"`
+		LeaseDurationSeconds int32  `json:"leaseDurationSeconds,omitempty"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+		LeaseTransitions     int32  `json:"leaseTransitions,omitempty"`
+	} `json:"spec"`
+}
+
+/*
+	Coordination api client of a kubernetes
+	Lease, authenticated with the pod service account.
+*/
+type KubeLeaseClient struct {
+	client    *http.Client
+	host      string
+	token     string
+	namespace string
+	name      string
+}
+
+/*
+	Creates a lease client from in-cluster
+	service account configuration.
+*/
+func NewKubeLeaseClient(namespace, name string) (*KubeLeaseClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running inside kubernetes")
+	}
+	token, err := ioutil.ReadFile(SERVICE_ACCOUNT_DIR + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(SERVICE_ACCOUNT_DIR + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid service account ca")
+	}
+	if namespace == "" {
+		ns, err := ioutil.ReadFile(SERVICE_ACCOUNT_DIR + "/namespace")
+		if err != nil {
+			return nil, err
+		}
+		namespace = string(ns)
+	}
+	return &KubeLeaseClient{
+		client: &http.Client{
+			Timeout:   API_TIMEOUT,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		host:      "https://" + net.JoinHostPort(host, port),
+		token:     string(bytes.TrimSpace(token)),
+		namespace: namespace,
+		name:      name,
+	}, nil
+}
+
+func (k *KubeLeaseClient) Get(ctx context.Context) (*LeaseRecord, error) {
+	var lease kubeLease
+	if err := k.do(ctx, http.MethodGet, "/"+k.name, nil, &lease); err != nil {
+		return nil, err
+	}
+	return recordOf(&lease)
+}
+
+func (k *KubeLeaseClient) Create(ctx context.Context, record *LeaseRecord) error {
+	return k.do(ctx, http.MethodPost, "", k.leaseOf(record), nil)
+}
+
+func (k *KubeLeaseClient) Update(ctx context.Context, record *LeaseRecord) error {
+	return k.do(ctx, http.MethodPut, "/"+k.name, k.leaseOf(record), nil)
+}
+
+/*
+	Sends a request to leases endpoint, decoding
+	response into out when it is not nil.
+*/
+func (k *KubeLeaseClient) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = data
+	}
+	url := k.host + fmt.Sprintf(LEASES_PATH, k.namespace) + path
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+k.token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrLeaseNotFound
+	case resp.StatusCode == http.StatusConflict:
+		return ErrConflict
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("lease api returned %v: %s", resp.StatusCode, data)
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+func (k *KubeLeaseClient) leaseOf(record *LeaseRecord) *kubeLease {
+	lease := &kubeLease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"}
+	lease.Metadata.Name = k.name
+	lease.Metadata.Namespace = k.namespace
+	lease.Metadata.ResourceVersion = record.Version
+	lease.Spec.HolderIdentity = record.HolderIdentity
+	lease.Spec.LeaseDurationSeconds = int32(record.LeaseDuration / time.Second)
+	lease.Spec.AcquireTime = record.AcquireTime.UTC().Format(MICRO_TIME_FORMAT)
+	lease.Spec.RenewTime = record.RenewTime.UTC().Format(MICRO_TIME_FORMAT)
+	lease.Spec.LeaseTransitions = record.Transitions
+	return lease
+}
+
+func recordOf(lease *kubeLease) (*LeaseRecord, error) {
+	record := &LeaseRecord{
+		HolderIdentity: lease.Spec.HolderIdentity,
+		LeaseDuration:  time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second,
+		Transitions:    lease.Spec.LeaseTransitions,
+		Version:        lease.Metadata.ResourceVersion,
+	}
+	var err error
+	if lease.Spec.AcquireTime != "" {
+		if record.AcquireTime, err = time.Parse(MICRO_TIME_FORMAT, lease.Spec.AcquireTime); err != nil {
+			return nil, err
+		}
+	}
+	if lease.Spec.RenewTime != "" {
+		if record.RenewTime, err = time.Parse(MICRO_TIME_FORMAT, lease.Spec.RenewTime); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
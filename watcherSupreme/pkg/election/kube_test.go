@@ -0,0 +1,179 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	TEST_NAMESPACE string = "sequence-clock"
+	TEST_LEASE     string = "watcher-supreme"
+	TEST_TOKEN     string = "service-account-token"
+)
+
+/*
+	Leases endpoint of the coordination api,
+	holding a single lease with resource versions.
+*/
+type fakeCoordinationAPI struct {
+	mutex   sync.Mutex
+	lease   *kubeLease
+	version int
+}
+
+func (f *fakeCoordinationAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+TEST_TOKEN {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	collection := fmt.Sprintf(LEASES_PATH, TEST_NAMESPACE)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == collection+"/"+TEST_LEASE:
+		if f.lease == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(f.lease)
+	case r.Method == http.MethodPost && r.URL.Path == collection:
+		if f.lease != nil {
+			http.Error(w, "already exists", http.StatusConflict)
+			return
+		}
+		f.store(w, r)
+	case r.Method == http.MethodPut && r.URL.Path == collection+"/"+TEST_LEASE:
+		if f.lease == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		f.store(w, r)
+	default:
+		http.Error(w, "unexpected "+r.Method+" "+r.URL.Path, http.StatusBadRequest)
+	}
+}
+
+func (f *fakeCoordinationAPI) store(w http.ResponseWriter, r *http.Request) {
+	var lease kubeLease
+	if err := json.NewDecoder(r.Body).Decode(&lease); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.lease != nil && lease.Metadata.ResourceVersion != f.lease.Metadata.ResourceVersion {
+		http.Error(w, "object has been modified", http.StatusConflict)
+		return
+	}
+	f.version++
+	lease.Metadata.ResourceVersion = strconv.Itoa(f.version)
+	f.lease = &lease
+	json.NewEncoder(w).Encode(f.lease)
+}
+
+func newTestKubeClient(t *testing.T, api http.Handler) *KubeLeaseClient {
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	return &KubeLeaseClient{
+		client:    srv.Client(),
+		host:      srv.URL,
+		token:     TEST_TOKEN,
+		namespace: TEST_NAMESPACE,
+		name:      TEST_LEASE,
+	}
+}
+
+func TestKubeLeaseClient(t *testing.T) {
+	k := newTestKubeClient(t, &fakeCoordinationAPI{})
+	ctx := context.Background()
+	if _, err := k.Get(ctx); !errors.Is(err, ErrLeaseNotFound) {
+		t.Fatalf("missing lease read as %v", err)
+	}
+
+	now := time.Now().Truncate(time.Microsecond)
+	created := &LeaseRecord{
+		HolderIdentity: "a",
+		LeaseDuration:  15 * time.Second,
+		AcquireTime:    now,
+		RenewTime:      now,
+		Transitions:    2,
+	}
+	if err := k.Create(ctx, created); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Create(ctx, created); !errors.Is(err, ErrConflict) {
+		t.Fatalf("second create returned %v", err)
+	}
+	record, err := k.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.HolderIdentity != "a" || record.LeaseDuration != 15*time.Second || record.Transitions != 2 ||
+		!record.AcquireTime.Equal(now) || !record.RenewTime.Equal(now) || record.Version == "" {
+		t.Fatalf("read back %+v", record)
+	}
+
+	stale := *record
+	record.RenewTime = now.Add(time.Second)
+	if err := k.Update(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Update(ctx, &stale); !errors.Is(err, ErrConflict) {
+		t.Fatalf("stale update returned %v", err)
+	}
+}
+
+func TestElectorOverCoordinationAPI(t *testing.T) {
+	api := &fakeCoordinationAPI{}
+	a := NewElector(newTestKubeClient(t, api), "a", 10*time.Second)
+	b := NewElector(newTestKubeClient(t, api), "b", 10*time.Second)
+	ctx := context.Background()
+	now := time.Now()
+
+	if !a.tryAcquireOrRenew(ctx, now) {
+		t.Fatal("a did not create the lease")
+	}
+	if b.tryAcquireOrRenew(ctx, now.Add(time.Second)) || b.Leader() != "a" {
+		t.Fatalf("b took a held lease, sees leader %q", b.Leader())
+	}
+	if !b.tryAcquireOrRenew(ctx, now.Add(11*time.Second)) {
+		t.Fatal("b did not take over an expired lease")
+	}
+	if api.lease.Spec.HolderIdentity != "b" || api.lease.Spec.LeaseTransitions != 1 {
+		t.Fatalf("lease spec %+v", api.lease.Spec)
+	}
+}
+
+func TestKubeLeaseClientUnauthorized(t *testing.T) {
+	k := newTestKubeClient(t, &fakeCoordinationAPI{})
+	k.token = "stolen"
+	if _, err := k.Get(context.Background()); err == nil || errors.Is(err, ErrLeaseNotFound) {
+		t.Fatalf("unauthorized read returned %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package election
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+/*
+	In-memory coordination api, holding a single lease.
+	Serves as fake in tests and as the lease
+	of a watcher supreme running without kubernetes.
+	Share one instance among electors competing for it.
+*/
+type MemoryLeaseClient struct {
+	mutex   sync.Mutex
+	record  *LeaseRecord
+	version int
+}
+
+func NewMemoryLeaseClient() *MemoryLeaseClient {
+	return &MemoryLeaseClient{mutex: sync.Mutex{}}
+}
+
+func (m *MemoryLeaseClient) Get(ctx context.Context) (*LeaseRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.record == nil {
+		return nil, ErrLeaseNotFound
+	}
+	record := *m.record
+	return &record, nil
+}
+
+func (m *MemoryLeaseClient) Create(ctx context.Context, record *LeaseRecord) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.record != nil {
+		return ErrConflict
+	}
+	m.store(record)
+	return nil
+}
+
+func (m *MemoryLeaseClient) Update(ctx context.Context, record *LeaseRecord) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.record == nil {
+		return ErrLeaseNotFound
+	} else if m.record.Version != record.Version {
+		return ErrConflict
+	}
+	m.store(record)
+	return nil
+}
+
+func (m *MemoryLeaseClient) store(record *LeaseRecord) {
+	m.version++
+	record.Version = strconv.Itoa(m.version)
+	stored := *record
+	m.record = &stored
+}
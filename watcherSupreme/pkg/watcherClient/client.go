@@ -21,48 +21,227 @@
 package client
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"net/url"
+	"path"
+	"time"
 
+	"github.com/john98nf/SequenceClock/watcher/pkg/auth"
+	"github.com/john98nf/SequenceClock/watcher/pkg/certs"
 	wrq "github.com/john98nf/SequenceClock/watcher/pkg/request"
+	"github.com/john98nf/SequenceClock/watcher/pkg/stream"
 
-	"github.com/iris-contrib/schema"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type WatcherInterface interface {
-	SendRequest(r *wrq.Request) (bool, error)
-	SendResetRequest(r wrq.ResetRequest) (bool, error)
+	Address() string
+	RequestGrant(ctx context.Context, r *wrq.Request) (*Grant, error)
+	SendResetRequest(ctx context.Context, r *wrq.ResetRequest) (bool, error)
+	SendRenewRequest(ctx context.Context, r *wrq.ResetRequest) (bool, error)
+	Registry() (map[string]RegistryEntry, error)
+	StreamConnected() bool
+}
+
+const (
+	REGISTRY_TIMEOUT time.Duration = 5 * time.Second
+	TRACER_NAME      string        = "github.com/john98nf/SequenceClock/watcherSupreme/pkg/watcherClient"
+)
+
+/*
+	Part of a watcher registry entry
+	needed to correlate requests.
+*/
+type RegistryEntry struct {
+	Container string
+	Requests  struct {
+		Current uint64
+		Active  map[uint64]int64
+		Leases  map[uint64]json.RawMessage
+	}
+}
+
+/*
+	Ids of requests the watcher still grants.
+*/
+func (e *RegistryEntry) RequestIDs() []uint64 {
+	ids := map[uint64]bool{e.Requests.Current: true}
+	for id := range e.Requests.Active {
+		ids[id] = true
+	}
+	for id := range e.Requests.Leases {
+		ids[id] = true
+	}
+	res := make([]uint64, 0, len(ids))
+	for id := range ids {
+		res = append(res, id)
+	}
+	return res
+}
+
+/*
+	Resources a watcher applied
+	to a function container.
+*/
+type Grant struct {
+	Node      string `json:"node"`
+	Container string `json:"container"`
+	Quotas    int64  `json:"quotas"`    // Effective CPU quotas, -1 when pinned
+	Requested int64  `json:"requested"` // CPU quotas desired by the request
+	Cpuset    string `json:"cpuset,omitempty"`
+	Memory    int64  `json:"memory,omitempty"` // Bytes, 0 when left untouched
 }
 
 type WatcherClient struct {
-	Node    string
-	BaseURL string
+	Node        string
+	BaseURL     string
+	RegistryURL string
+	Credential  *auth.Credential // Optional, token presented to the watcher
+	HTTPClient  *http.Client
+	certStore   *certs.Store // Optional, see UseTLS
+	channel     *channel     // Optional, see EnableStream
 }
 
 func NewWatcherClient(node string) *WatcherClient {
 	return &WatcherClient{
-		Node:    node,
-		BaseURL: "http://" + node + ":8080/api/function",
+		Node:        node,
+		BaseURL:     "http://" + node + ":8080/api/function",
+		RegistryURL: "http://" + node + ":8080/api/registry",
+		HTTPClient:  http.DefaultClient,
 	}
 }
 
-func (w *WatcherClient) SendRequest(r *wrq.Request) (bool, error) {
-	return w.executeRequest(w.BaseURL+"/requestResources", *r)
+/*
+	Node address of the watcher.
+*/
+func (w *WatcherClient) Address() string {
+	return w.Node
+}
+
+/*
+	Reaches the watcher over TLS, presenting
+	the certificate of store for mutual TLS.
+	Must be called before EnableStream.
+*/
+func (w *WatcherClient) UseTLS(store *certs.Store) {
+	if store == nil {
+		return
+	}
+	w.certStore = store
+	w.BaseURL = "https://" + w.Node + ":8080/api/function"
+	w.RegistryURL = "https://" + w.Node + ":8080/api/registry"
+	w.HTTPClient = store.HTTPClient(true)
 }
 
-func (w *WatcherClient) SendResetRequest(r *wrq.ResetRequest) (bool, error) {
-	return w.executeRequest(w.BaseURL+"/resetRequest", *r)
+/*
+	Fetches registry of the watcher.
+*/
+func (w *WatcherClient) Registry() (map[string]RegistryEntry, error) {
+	client := http.Client{Timeout: REGISTRY_TIMEOUT, Transport: w.HTTPClient.Transport}
+	req, err := http.NewRequest(http.MethodGet, w.RegistryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	w.authorize(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf(string(body))
+	}
+	res := struct {
+		Registry map[string]RegistryEntry `json:"registry"`
+	}{}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	return res.Registry, nil
 }
 
-func (w *WatcherClient) executeRequest(endpoint string, msg interface{}) (bool, error) {
-	var encoder = schema.NewEncoder()
-	params := url.Values{}
-	if err := encoder.Encode(msg, params); err != nil {
+/*
+	Sends a request, waiting for the watcher
+	to apply it until ctx is done.
+	Returns nil grant if function
+	is not found on watcher node.
+	Falls back to http when the stream breaks, a
+	request seen twice is granted once by the watcher.
+*/
+func (w *WatcherClient) RequestGrant(ctx context.Context, r *wrq.Request) (*Grant, error) {
+	msg := *r
+	if ack, err := w.sendCommand(ctx, &stream.Command{Allocate: &msg}); err == nil {
+		return w.ackGrant(ack)
+	} else if !errors.Is(err, ErrStreamUnavailable) {
+		return nil, err
+	}
+	resp, err := w.postMessage(ctx, w.BaseURL+"/requestResources", &msg)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == 404 {
+		return nil, nil
+	} else if resp.StatusCode != 200 {
+		return nil, fmt.Errorf(string(body))
+	}
+	res := struct {
+		Grant *Grant `json:"grant"`
+	}{}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	if res.Grant == nil {
+		res.Grant = &Grant{}
+	}
+	if res.Grant.Node == "" {
+		res.Grant.Node = w.Node
+	}
+	return res.Grant, nil
+}
+
+func (w *WatcherClient) SendResetRequest(ctx context.Context, r *wrq.ResetRequest) (bool, error) {
+	msg := *r
+	if ack, err := w.sendCommand(ctx, &stream.Command{Reset: &msg}); err == nil {
+		return ackResult(ack)
+	} else if !errors.Is(err, ErrStreamUnavailable) {
+		return false, err
+	}
+	return w.executeRequest(ctx, w.BaseURL+"/resetRequest", &msg)
+}
+
+func (w *WatcherClient) SendRenewRequest(ctx context.Context, r *wrq.ResetRequest) (bool, error) {
+	msg := *r
+	if ack, err := w.sendCommand(ctx, &stream.Command{Renew: &msg}); err == nil {
+		return ackResult(ack)
+	} else if !errors.Is(err, ErrStreamUnavailable) {
 		return false, err
 	}
-	resp, err := http.PostForm(endpoint, params)
+	return w.executeRequest(ctx, w.BaseURL+"/renewLease", &msg)
+}
+
+func (w *WatcherClient) executeRequest(ctx context.Context, endpoint string, msg wrq.Message) (bool, error) {
+	resp, err := w.postMessage(ctx, endpoint, msg)
 	if err != nil {
 		return false, err
 	}
@@ -80,3 +259,46 @@ func (w *WatcherClient) executeRequest(endpoint string, msg interface{}) (bool,
 		return false, fmt.Errorf(string(body))
 	}
 }
+
+/*
+	Posts msg as versioned json to endpoint in a
+	client span of the trace found in ctx,
+	propagating the trace to the watcher through
+	the W3C traceparent header.
+*/
+func (w *WatcherClient) postMessage(ctx context.Context, endpoint string, msg wrq.Message) (*http.Response, error) {
+	body, err := wrq.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := otel.Tracer(TRACER_NAME).Start(ctx, "watcher "+path.Base(endpoint),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("watcher.node", w.Node),
+			attribute.String("watcher.transport", "http"),
+		),
+	)
+	defer span.End()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", wrq.CONTENT_TYPE_JSON)
+	w.authorize(req)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := w.HTTPClient.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+	span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(resp.StatusCode))
+	return resp, nil
+}
+
+func (w *WatcherClient) authorize(req *http.Request) {
+	if h := w.Credential.Header(); h != "" {
+		req.Header.Set(auth.AUTHORIZATION_HEADER, h)
+	}
+}
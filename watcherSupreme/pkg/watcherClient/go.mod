@@ -22,25 +22,20 @@ module github.com/john98nf/SequenceClock/watcherSupreme/pkg/watcherClient
 
 go 1.15
 
+replace github.com/john98nf/SequenceClock/watcher/pkg/request => ../../../watcher/pkg/request
+
+replace github.com/john98nf/SequenceClock/watcher/pkg/stream => ../../../watcher/pkg/stream
+
+replace github.com/john98nf/SequenceClock/watcher/pkg/auth => ../../../watcher/pkg/auth
+
+replace github.com/john98nf/SequenceClock/watcher/pkg/certs => ../../../watcher/pkg/certs
+
 require (
-	github.com/ajg/form v1.5.1 // indirect
-	github.com/fasthttp-contrib/websocket v0.0.0-20160511215533-1f3b11f56072 // indirect
-	github.com/google/go-querystring v1.1.0 // indirect
-	github.com/imkira/go-interpol v1.1.0 // indirect
-	github.com/iris-contrib/schema v0.0.6
-	github.com/john98nf/SequenceClock/watcher/pkg/request v0.0.0-20210910091808-7dd4271b2430
-	github.com/k0kubun/colorstring v0.0.0-20150214042306-9440f1994b88 // indirect
-	github.com/kataras/iris/v12 v12.1.8 // indirect
-	github.com/mattn/go-colorable v0.1.8 // indirect
-	github.com/moul/http2curl v1.0.0 // indirect
-	github.com/onsi/gomega v1.16.0 // indirect
-	github.com/sergi/go-diff v1.2.0 // indirect
-	github.com/shurcooL/sanitized_anchor_name v1.0.0 // indirect
-	github.com/smartystreets/goconvey v1.6.4 // indirect
-	github.com/valyala/fasthttp v1.30.0 // indirect
-	github.com/xeipuuv/gojsonschema v1.2.0 // indirect
-	github.com/yalp/jsonpath v0.0.0-20180802001716-5cc68e5049a0 // indirect
-	github.com/yudai/gojsondiff v1.0.0 // indirect
-	github.com/yudai/golcs v0.0.0-20170316035057-ecda9a501e82 // indirect
-	github.com/yudai/pp v2.0.1+incompatible // indirect
+	github.com/john98nf/SequenceClock/watcher/pkg/auth v0.0.0-00010101000000-000000000000
+	github.com/john98nf/SequenceClock/watcher/pkg/certs v0.0.0-00010101000000-000000000000
+	github.com/john98nf/SequenceClock/watcher/pkg/request v0.0.0-00010101000000-000000000000
+	github.com/john98nf/SequenceClock/watcher/pkg/stream v0.0.0-00010101000000-000000000000
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	google.golang.org/grpc v1.40.0
 )
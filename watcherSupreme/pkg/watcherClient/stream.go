@@ -0,0 +1,270 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/john98nf/SequenceClock/watcher/pkg/stream"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const RECONNECT_INTERVAL time.Duration = 2 * time.Second
+
+/*
+	Returned by stream calls which did not reach
+	the watcher, so the http api is used instead.
+*/
+var ErrStreamUnavailable = errors.New("stream channel unavailable")
+
+/*
+	Receives events pushed by the watcher of node.
+	Called from the channel goroutine,
+	so it must not block.
+*/
+type EventHandler func(node string, e *stream.Event)
+
+/*
+	Long-lived channel to a watcher, reopened
+	in the background whenever it breaks.
+*/
+type channel struct {
+	conn    *grpc.ClientConn
+	mu      sync.Mutex // Guards fields below and sends
+	cc      stream.ChannelClient
+	seq     uint64
+	pending map[uint64]chan *stream.Ack
+}
+
+/*
+	Carries requests over a stream channel to the
+	watcher, listening on port, instead of http.
+	Calls fall back to http while the channel is down.
+	Must be called before the client is used.
+*/
+func (w *WatcherClient) EnableStream(port string, onEvent EventHandler) error {
+	var creds credentials.TransportCredentials
+	if w.certStore != nil {
+		creds = credentials.NewTLS(w.certStore.ClientConfig(true))
+	}
+	conn, err := stream.Dial(w.Node+":"+port, creds)
+	if err != nil {
+		return err
+	}
+	w.channel = &channel{
+		conn:    conn,
+		pending: make(map[uint64]chan *stream.Ack),
+	}
+	go w.channel.run(context.Background(), w, onEvent)
+	return nil
+}
+
+/*
+	Whether requests currently go over the stream.
+*/
+func (w *WatcherClient) StreamConnected() bool {
+	if w.channel == nil {
+		return false
+	}
+	w.channel.mu.Lock()
+	defer w.channel.mu.Unlock()
+	return w.channel.cc != nil
+}
+
+/*
+	Keeps the channel open. Failures to open it are
+	logged once, and again only when the error changes,
+	so a watcher without stream support stays quiet.
+*/
+func (c *channel) run(ctx context.Context, w *WatcherClient, onEvent EventHandler) {
+	node := w.Node
+	failure := ""
+	for ctx.Err() == nil {
+		cc, err := stream.OpenChannel(ctx, c.conn, w.Credential.Header())
+		if err != nil {
+			if err.Error() != failure {
+				failure = err.Error()
+				log.Printf("Stream channel to watcher %v not opened, retrying every %v: %v\n", node, RECONNECT_INTERVAL, err)
+			}
+			time.Sleep(RECONNECT_INTERVAL)
+			continue
+		}
+		failure = ""
+		log.Printf("Stream channel to watcher %v open\n", node)
+		c.mu.Lock()
+		c.cc = cc
+		c.mu.Unlock()
+		err = c.receive(cc, node, onEvent)
+		log.Printf("Stream channel to watcher %v closed: %v\n", node, err)
+		c.close()
+		time.Sleep(RECONNECT_INTERVAL)
+	}
+}
+
+func (c *channel) receive(cc stream.ChannelClient, node string, onEvent EventHandler) error {
+	for {
+		u, err := cc.Recv()
+		if err != nil {
+			return err
+		}
+		if u.Ack != nil {
+			c.mu.Lock()
+			done, ok := c.pending[u.Ack.Seq]
+			delete(c.pending, u.Ack.Seq)
+			c.mu.Unlock()
+			if ok {
+				done <- u.Ack
+			}
+		}
+		if u.Event != nil && onEvent != nil {
+			onEvent(node, u.Event)
+		}
+	}
+}
+
+/*
+	Marks the channel down, failing
+	calls still waiting for their ack.
+*/
+func (c *channel) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cc = nil
+	for seq, done := range c.pending {
+		close(done)
+		delete(c.pending, seq)
+	}
+}
+
+/*
+	Sends cmd and waits for its ack until ctx is done.
+	Commands lost with a broken channel fail with
+	ErrStreamUnavailable and are retried over http.
+	The watcher may have applied the command already:
+	it answers a repeated allocation with the grant in
+	place, and a repeated reset or renewal as not found.
+*/
+func (c *channel) call(ctx context.Context, cmd *stream.Command) (*stream.Ack, error) {
+	c.mu.Lock()
+	if c.cc == nil {
+		c.mu.Unlock()
+		return nil, ErrStreamUnavailable
+	}
+	c.seq++
+	cmd.Seq = c.seq
+	done := make(chan *stream.Ack, 1)
+	c.pending[cmd.Seq] = done
+	if err := c.cc.Send(cmd); err != nil {
+		delete(c.pending, cmd.Seq)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("%w: %v", ErrStreamUnavailable, err)
+	}
+	c.mu.Unlock()
+	select {
+	case ack, ok := <-done:
+		if !ok {
+			return nil, ErrStreamUnavailable
+		}
+		return ack, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, cmd.Seq)
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+/*
+	Sends cmd over the stream in a client span of
+	the trace found in ctx, carried by the command.
+*/
+func (w *WatcherClient) sendCommand(ctx context.Context, cmd *stream.Command) (*stream.Ack, error) {
+	if w.channel == nil {
+		return nil, ErrStreamUnavailable
+	}
+	ctx, span := otel.Tracer(TRACER_NAME).Start(ctx, "watcher "+cmd.Name(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("watcher.node", w.Node),
+			attribute.String("watcher.transport", "stream"),
+		),
+	)
+	defer span.End()
+	cmd.Trace = stream.Trace{}
+	otel.GetTextMapPropagator().Inject(ctx, cmd.Trace)
+	ack, err := w.channel.call(ctx, cmd)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(ack.Status))
+	span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(ack.Status))
+	return ack, nil
+}
+
+/*
+	Result of an ack, as executeRequest
+	returns it for http responses.
+*/
+func ackResult(ack *stream.Ack) (bool, error) {
+	if ack.Status == 200 {
+		return true, nil
+	} else if ack.Status == 404 {
+		return false, nil
+	}
+	return false, errors.New(ack.Error)
+}
+
+/*
+	Grant of an allocation ack,
+	nil if the function was not found.
+*/
+func (w *WatcherClient) ackGrant(ack *stream.Ack) (*Grant, error) {
+	if ack.Status == 404 {
+		return nil, nil
+	} else if ack.Status != 200 {
+		return nil, errors.New(ack.Error)
+	}
+	grant := &Grant{}
+	if len(ack.Grant) != 0 {
+		if err := json.Unmarshal(ack.Grant, grant); err != nil {
+			return nil, err
+		}
+	}
+	if grant.Node == "" {
+		grant.Node = w.Node
+	}
+	return grant, nil
+}
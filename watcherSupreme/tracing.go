@@ -0,0 +1,119 @@
+// Copyright © 2021 Giannis Fakinos
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	SERVICE_NAME      string = "sequence-clock-watcher-supreme"
+	TRACER_NAME       string = "github.com/john98nf/SequenceClock/watcherSupreme"
+	ACTIVATION_ID_KEY string = "openwhisk.activation_id"
+	OTLP_ENDPOINT_ENV string = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	OTLP_TRACES_ENV   string = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+)
+
+var tracer = otel.Tracer(TRACER_NAME)
+
+/*
+	Exports spans over OTLP/HTTP to the collector
+	named by OTEL_EXPORTER_OTLP_ENDPOINT.
+	Without a collector spans are not recorded,
+	though trace context of the controller
+	still reaches the watchers.
+*/
+func setupTracing() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if os.Getenv(OTLP_ENDPOINT_ENV) == "" && os.Getenv(OTLP_TRACES_ENV) == "" {
+		return
+	}
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String(SERVICE_NAME),
+			attribute.String("host.ip", podIP),
+		)),
+	))
+}
+
+/*
+	Continues the trace of an incoming
+	request in a server span, tagged with
+	the openwhisk activation carried as baggage.
+	Headers are rewritten so calls proxied
+	to the leader continue this span.
+*/
+func traced(c *gin.Context) {
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := tracer.Start(ctx, c.Request.Method+" "+c.FullPath(), trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	if activation := baggage.FromContext(ctx).Member(ACTIVATION_ID_KEY).Value(); activation != "" {
+		span.SetAttributes(attribute.String(ACTIVATION_ID_KEY, activation))
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(c.Request.Header))
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+	status := c.Writer.Status()
+	span.SetAttributes(
+		semconv.HTTPMethodKey.String(c.Request.Method),
+		semconv.HTTPRouteKey.String(c.FullPath()),
+		semconv.HTTPStatusCodeKey.Int(status),
+	)
+	span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(status))
+}
+
+/*
+	Tags the server span of a request
+	with the function and request id.
+*/
+func tagRequest(ctx context.Context, function string, id uint64) {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("function", function),
+		attribute.Int64("request.id", int64(id)),
+	)
+}
+
+/*
+	Returns a context that outlives the request
+	in ctx but continues its trace, for work
+	done after the response is sent.
+*/
+func detach(ctx context.Context) context.Context {
+	res := trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+	return baggage.ContextWithBaggage(res, baggage.FromContext(ctx))
+}